@@ -0,0 +1,224 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const apiVersionPath = "/api/v1"
+
+type ApiParameter struct {
+	Name        string
+	In          string
+	Type        string
+	Required    bool
+	Description string
+}
+
+// ApiError is a failure status a route answers with besides the 400 every
+// route can give. Response is the body it carries, if any.
+type ApiError struct {
+	Status      int
+	Description string
+	Response    any
+}
+
+var (
+	apiPhoneNotFound       = ApiError{Status: http.StatusNotFound, Description: "No such phone in the catalog"}
+	apiCatalogReadOnly     = ApiError{Status: http.StatusForbidden, Description: "The phone catalog is read-only"}
+	apiRunNotFound         = ApiError{Status: http.StatusNotFound, Description: "No such run, or its output was not kept"}
+	apiSimulationCancelled = ApiError{Status: http.StatusServiceUnavailable, Description: "The server is shutting down, try again after Retry-After seconds"}
+)
+
+// ApiRoute describes one JSON endpoint. The same table is used to mount the
+// routes and to generate the OpenAPI document, so the two cannot drift apart.
+type ApiRoute struct {
+	Method      string
+	Path        string
+	Summary     string
+	Handler     gin.HandlerFunc
+	Parameters  []ApiParameter
+	Request     any
+	Response    any
+	ContentType string
+	// set for requests that are not JSON, whose body is then a plain file
+	RequestContentType string
+	// the status of a success, http.StatusOK when zero. A 204 has no body.
+	Status int
+	Errors []ApiError
+}
+
+var phoneIdParameter = ApiParameter{Name: "id", In: "path", Type: "string", Required: true, Description: "phone model name without extension"}
+
+var apiRoutes = []ApiRoute{
+	{
+		Method:   http.MethodGet,
+		Path:     "/phone",
+		Summary:  "Dimensions of a single phone model",
+		Handler:  HandleApiPhone,
+		Response: PhoneConfig{},
+		Parameters: []ApiParameter{
+			{Name: "model", In: "query", Type: "string", Required: true, Description: "phone model name without extension"},
+		},
+		Errors: []ApiError{apiPhoneNotFound},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/phones",
+		Summary:  "List the phone catalog",
+		Handler:  HandleApiGetPhones,
+		Response: []PhoneOption{},
+	},
+	{
+		Method:     http.MethodGet,
+		Path:       "/phones/:id",
+		Summary:    "Dimensions of a single phone model",
+		Handler:    HandleApiGetPhone,
+		Response:   PhoneConfig{},
+		Parameters: []ApiParameter{phoneIdParameter},
+		Errors:     []ApiError{apiPhoneNotFound},
+	},
+	{
+		Method:     http.MethodPost,
+		Path:       "/phones/:id",
+		Summary:    "Add a phone model to the catalog",
+		Handler:    HandleApiCreatePhone,
+		Request:    PhoneConfig{},
+		Response:   PhoneConfig{},
+		Parameters: []ApiParameter{phoneIdParameter},
+		Status:     http.StatusCreated,
+		Errors:     []ApiError{apiCatalogReadOnly, {Status: http.StatusConflict, Description: "The phone is already in the catalog", Response: ValidationError{}}},
+	},
+	{
+		Method:     http.MethodPut,
+		Path:       "/phones/:id",
+		Summary:    "Replace the dimensions of a phone model",
+		Handler:    HandleApiUpdatePhone,
+		Request:    PhoneConfig{},
+		Response:   PhoneConfig{},
+		Parameters: []ApiParameter{phoneIdParameter},
+		Errors:     []ApiError{apiCatalogReadOnly, apiPhoneNotFound},
+	},
+	{
+		Method:     http.MethodDelete,
+		Path:       "/phones/:id",
+		Summary:    "Remove a phone model from the catalog",
+		Handler:    HandleApiDeletePhone,
+		Parameters: []ApiParameter{phoneIdParameter},
+		Status:     http.StatusNoContent,
+		Errors:     []ApiError{apiCatalogReadOnly, apiPhoneNotFound},
+	},
+	{
+		Method:             http.MethodPost,
+		Path:               "/phones/import",
+		Summary:            "Add phone models from a CSV spec sheet, reporting the rows rejected",
+		Handler:            HandleApiImportPhones,
+		RequestContentType: "text/csv",
+		Response:           PhoneImportReport{},
+		Parameters: []ApiParameter{
+			{Name: "update", In: "query", Type: "boolean", Description: "replace phones that already exist instead of rejecting them"},
+			{Name: "dryRun", In: "query", Type: "boolean", Description: "validate the sheet without changing the catalog"},
+		},
+		Errors: []ApiError{{Status: http.StatusForbidden, Description: "The phone catalog is read-only, nothing was imported", Response: PhoneImportReport{}}},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/simulation",
+		Summary:  "Run a simulation",
+		Handler:  HandleApiSimulation,
+		Request:  SimulationInput{},
+		Response: SimulationOutput{},
+		Errors:   []ApiError{apiSimulationCancelled},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/sweep",
+		Summary:  "Run a grid of simulations over one or more numeric inputs",
+		Handler:  HandleApiSweep,
+		Request:  SweepInput{},
+		Response: SweepOutput{},
+		Errors:   []ApiError{apiSimulationCancelled},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/optimize",
+		Summary:  "Search the reflector geometry for the best design",
+		Handler:  HandleApiOptimize,
+		Request:  OptimizeInput{},
+		Response: OptimizeOutput{},
+		Errors:   []ApiError{apiSimulationCancelled},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/compare",
+		Summary:  "Run two configurations with identical ray sampling and report their difference",
+		Handler:  HandleApiCompare,
+		Request:  CompareInput{},
+		Response: CompareOutput{},
+		Errors:   []ApiError{apiSimulationCancelled},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/runs",
+		Summary:  "List the run history, newest first",
+		Handler:  HandleApiGetRuns,
+		Response: []RunRecord{},
+		Parameters: []ApiParameter{
+			{Name: "phone", In: "query", Type: "string", Description: "only runs using this phone filename"},
+			{Name: "since", In: "query", Type: "string", Description: "RFC 3339 lower bound on the run time"},
+			{Name: "until", In: "query", Type: "string", Description: "RFC 3339 upper bound on the run time"},
+			{Name: "minUserHits", In: "query", Type: "integer", Description: "only runs with at least this many user sphere hits"},
+			{Name: "limit", In: "query", Type: "integer", Description: "maximum number of runs returned"},
+		},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/runs/:id",
+		Summary:  "Reload a prior run and its output",
+		Handler:  HandleApiGetRun,
+		Response: RunDetail{},
+		Parameters: []ApiParameter{
+			{Name: "id", In: "path", Type: "string", Required: true},
+		},
+		Errors: []ApiError{apiRunNotFound},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/export/:format",
+		Summary:     "Run a simulation and download its hits and ray paths as PLY, OBJ, CSV or a glTF scene",
+		Handler:     HandleApiExport,
+		Request:     SimulationInput{},
+		ContentType: "application/octet-stream",
+		Parameters: []ApiParameter{
+			{Name: "format", In: "path", Type: "string", Required: true, Description: "ply, obj, csv or glb"},
+		},
+		Errors: []ApiError{apiSimulationCancelled},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/runs/:id/export/:format",
+		Summary:     "Download a prior run's hits and ray paths as PLY, OBJ, CSV or a glTF scene",
+		Handler:     HandleApiExportRun,
+		ContentType: "application/octet-stream",
+		Parameters: []ApiParameter{
+			{Name: "id", In: "path", Type: "string", Required: true},
+			{Name: "format", In: "path", Type: "string", Required: true, Description: "ply, obj, csv or glb"},
+		},
+		Errors: []ApiError{apiRunNotFound, apiSimulationCancelled},
+	},
+}
+
+func registerApiRoutes(group *gin.RouterGroup) {
+	for i := 0; i < len(apiRoutes); i++ {
+		group.Handle(apiRoutes[i].Method, apiRoutes[i].Path, apiRoutes[i].Handler)
+	}
+}
+
+// deprecatedApi marks responses from the unversioned /api routes, which are
+// kept only so existing scripts keep working while they move to /api/v1
+func deprecatedApi(c *gin.Context) {
+	c.Header("Deprecation", "true")
+	c.Header("Link", "<"+basePath+apiVersionPath+c.Request.URL.Path[len(basePath+"/api"):]+">; rel=\"successor-version\"")
+	c.Next()
+}
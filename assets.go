@@ -0,0 +1,149 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"flag"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed ui
+var embeddedUi embed.FS
+
+//go:embed src/js
+var embeddedJs embed.FS
+
+// vendoredScripts are the third party scripts the UI loads from /vendor,
+// with the CDN they were taken from. They are embedded from ui/vendor, which
+// ui/vendor/fetch.sh fills in. Requests are only redirected to the CDN when
+// the server is started with -ui-cdn-fallback.
+var vendoredScripts = map[string]string{
+	"htmx.min.js":      "https://unpkg.com/htmx.org@2.0.2/dist/htmx.min.js",
+	"gl-matrix-min.js": "https://cdnjs.cloudflare.com/ajax/libs/gl-matrix/2.8.1/gl-matrix-min.js",
+}
+
+// assetDir is a tree of static files built into the binary, optionally
+// overridden file by file from a directory on disk
+type assetDir struct {
+	override string
+	embedded fs.FS
+}
+
+func newAssetDir(override string, embedded fs.FS, root string) assetDir {
+	sub, err := fs.Sub(embedded, root)
+	if err != nil {
+		// root is a constant naming an embedded directory
+		panic(err)
+	}
+	return assetDir{override: override, embedded: sub}
+}
+
+// read returns the override copy of name when there is one, else the
+// embedded copy. name is always a constant or a vendoredScripts key.
+func (assets assetDir) read(name string) ([]byte, error) {
+	if assets.override != "" {
+		byteValue, err := os.ReadFile(filepath.Join(assets.override, filepath.FromSlash(name)))
+		if !errors.Is(err, os.ErrNotExist) {
+			return byteValue, err
+		}
+	}
+	return fs.ReadFile(assets.embedded, name)
+}
+
+func (assets assetDir) serve(name string, contentType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		byteValue, err := assets.read(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.Data(http.StatusOK, contentType, byteValue)
+	}
+}
+
+// missingVendored lists the vendored scripts that are neither embedded nor in
+// the override directory
+func (assets assetDir) missingVendored() []string {
+	missing := []string{}
+	for name := range vendoredScripts {
+		_, err := assets.read("vendor/" + name)
+		if err != nil {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// serveVendored serves the scripts in ui/vendor. A missing one is redirected
+// to its CDN only when cdnFallback is set, otherwise it is not found.
+func (assets assetDir) serveVendored(cdnFallback bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("file")
+		upstream, ok := vendoredScripts[name]
+		if !ok {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		byteValue, err := assets.read("vendor/" + name)
+		if errors.Is(err, fs.ErrNotExist) && cdnFallback {
+			c.Redirect(http.StatusFound, upstream)
+			return
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.Data(http.StatusOK, "text/javascript; charset=utf-8", byteValue)
+	}
+}
+
+type assetFlags struct {
+	uiDir       *string
+	jsDir       *string
+	cdnFallback *bool
+}
+
+func addAssetFlags(flags *flag.FlagSet) *assetFlags {
+	return &assetFlags{
+		uiDir:       flags.String("ui-dir", "", "directory whose files override the built in ui/ (index.html, vendor/)"),
+		jsDir:       flags.String("js-dir", "", "directory whose files override the built in src/js/"),
+		cdnFallback: flags.Bool("ui-cdn-fallback", false, "redirect the browser to the CDNs for third party scripts missing from ui/vendor"),
+	}
+}
+
+// registerAssetRoutes serves the UI from the binary so the server does not
+// depend on its working directory
+func registerAssetRoutes(r gin.IRouter, flags *assetFlags) {
+	ui := newAssetDir(*flags.uiDir, embeddedUi, "ui")
+	js := newAssetDir(*flags.jsDir, embeddedJs, "src/js")
+
+	missing := ui.missingVendored()
+	if len(missing) > 0 && *flags.cdnFallback {
+		log.Printf("ui/vendor is missing %s, the UI will load them from their CDNs", strings.Join(missing, ", "))
+	} else if len(missing) > 0 {
+		log.Printf("ui/vendor is missing %s, the UI will not work; run ui/vendor/fetch.sh before building, or pass -ui-cdn-fallback", strings.Join(missing, ", "))
+	}
+
+	r.GET("/", ui.serve("index.html", "text/html; charset=utf-8"))
+	r.GET("/vendor/:file", ui.serveVendored(*flags.cdnFallback))
+	r.GET("/js/webgl.js", js.serve("webgl.js", "text/javascript; charset=utf-8"))
+	r.GET("/js/events.js", js.serve("events.js", "text/javascript; charset=utf-8"))
+}
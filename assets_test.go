@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAssetRoutes(t *testing.T) {
+	uiDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(uiDir, "index.html"), []byte("<p>override</p>"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir(filepath.Join(uiDir, "vendor"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(uiDir, "vendor", "htmx.min.js"), []byte("// htmx"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptyDir := ""
+	cdnFallback := false
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerAssetRoutes(r, &assetFlags{uiDir: &uiDir, jsDir: &emptyDir, cdnFallback: &cdnFallback})
+
+	recorder := serveTestRequest(r, http.MethodGet, "/", "")
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "<p>override</p>" {
+		t.Errorf("GET /: got %d %q, want the override", recorder.Code, recorder.Body.String())
+	}
+
+	// files missing from the override directory come from the binary
+	recorder = serveTestRequest(r, http.MethodGet, "/js/webgl.js", "")
+	embedded, err := embeddedJs.ReadFile("src/js/webgl.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder.Code != http.StatusOK || recorder.Body.String() != string(embedded) || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/javascript") {
+		t.Errorf("GET /js/webgl.js: got %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+
+	recorder = serveTestRequest(r, http.MethodGet, "/js/events.js", "")
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "phonesChanged") {
+		t.Errorf("GET /js/events.js: got %d", recorder.Code)
+	}
+
+	recorder = serveTestRequest(r, http.MethodGet, "/vendor/htmx.min.js", "")
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "// htmx" {
+		t.Errorf("GET /vendor/htmx.min.js: got %d %q, want the override", recorder.Code, recorder.Body.String())
+	}
+
+	// scripts missing from ui/vendor go to their CDN only when asked to
+	missing := newAssetDir(uiDir, embeddedUi, "ui").missingVendored()
+	fallback := gin.New()
+	cdnFallback = true
+	registerAssetRoutes(fallback, &assetFlags{uiDir: &uiDir, jsDir: &emptyDir, cdnFallback: &cdnFallback})
+	for i := 0; i < len(missing); i++ {
+		recorder = serveTestRequest(r, http.MethodGet, "/vendor/"+missing[i], "")
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("GET /vendor/%s: got %d without the fallback, want %d", missing[i], recorder.Code, http.StatusNotFound)
+		}
+		recorder = serveTestRequest(fallback, http.MethodGet, "/vendor/"+missing[i], "")
+		if recorder.Code != http.StatusFound || recorder.Header().Get("Location") != vendoredScripts[missing[i]] {
+			t.Errorf("GET /vendor/%s: got %d to %q with the fallback", missing[i], recorder.Code, recorder.Header().Get("Location"))
+		}
+	}
+
+	recorder = serveTestRequest(r, http.MethodGet, "/vendor/..%2findex.html", "")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("GET outside the vendored scripts: got %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"amphora/pkg/units"
+	"math"
+)
+
+// grid used for per-direction gain when the request does not ask for a hit map
+var defaultGainHitMap = HitMapInput{Scheme: "equalArea", ThetaBins: 18, PhiBins: 36, Axis: "paraboloid"}
+
+// half angle of the listening cone when the request does not give one
+var defaultConeAngle = units.Quantity{Value: 15, Unit: "deg"}
+
+// listeningCone returns the cone half angle a request asks for, or the
+// default, along with the value and unit to report it in
+func listeningCone(val float64, unitName string) (units.Angle, units.Quantity) {
+	requested := units.Quantity{Value: val, Unit: unitName}
+	if val == 0 {
+		requested = defaultConeAngle
+	}
+	coneAngle, _ := units.NewAngle(requested.Value, requested.Unit)
+	return coneAngle, requested
+}
+
+type BaselineInput struct {
+	// half angle of the listening cone around the paraboloid axis, 15° by default
+	ConeAngle      float64 `json:"coneAngle,omitempty" quantity:"angle,ConeAngleUnits"`
+	ConeAngleUnits string  `json:"coneAngleUnits,omitempty"`
+}
+
+func (baselineInput *BaselineInput) UnmarshalJSON(data []byte) error {
+	type plain BaselineInput
+	return decodeQuantities(data, (*plain)(baselineInput))
+}
+
+type BaselineOutput struct {
+	Metrics SimulationMetrics `json:"metrics"`
+	Gain    GainReport        `json:"gain"`
+}
+
+// GainReport compares the reflector design against the bare phone. Gains are
+// in dB and are null wherever the bare phone delivers no rays to compare to.
+// The cone the on-axis gain counts is echoed as the request gave it.
+type GainReport struct {
+	TotalDb        *float64   `json:"totalDb"`
+	OnAxisDb       *float64   `json:"onAxisDb"`
+	ConeAngle      float64    `json:"coneAngle"`
+	ConeAngleUnits string     `json:"coneAngleUnits"`
+	Scheme         string     `json:"scheme"`
+	Axis           string     `json:"axis"`
+	ThetaBins      int        `json:"thetaBins"`
+	PhiBins        int        `json:"phiBins"`
+	ThetaEdges     []float64  `json:"thetaEdges"`
+	PhiEdges       []float64  `json:"phiEdges"`
+	DirectionDb    []*float64 `json:"directionDb"`
+}
+
+func validateBaselineInput(validationError *ValidationError, baselineInput *BaselineInput) {
+	if baselineInput.ConeAngle == 0 && baselineInput.ConeAngleUnits == "" {
+		return
+	}
+	coneAngle, err := units.NewAngle(baselineInput.ConeAngle, baselineInput.ConeAngleUnits)
+	if err != nil {
+		validationError.Add("baseline.coneAngleUnits", "%v", err)
+		return
+	}
+	if validatePositive(validationError, "baseline.coneAngle", baselineInput.ConeAngle) && coneAngle > math.Pi {
+		validationError.Add("baseline.coneAngle", "must not exceed 180°")
+	}
+}
+
+func gainDb(design float64, baseline float64) *float64 {
+	if baseline <= 0 || design <= 0 {
+		return nil
+	}
+	gain := 10 * math.Log10(design/baseline)
+	return &gain
+}
+
+func computeGain(phoneConfig *PhoneConfig, simulationInput *SimulationInput, designOutput *SimulationOutput, baselineOutput *SimulationOutput) GainReport {
+	var gain GainReport
+
+	gain.TotalDb = gainDb(float64(designOutput.Metrics.RaysAtUser), float64(baselineOutput.Metrics.RaysAtUser))
+
+	coneAngle, requested := listeningCone(simulationInput.Baseline.ConeAngle, simulationInput.Baseline.ConeAngleUnits)
+	gain.ConeAngle = requested.Value
+	gain.ConeAngleUnits = requested.Unit
+	axis := paraboloidFrame(normalizedInput(simulationInput).ParaboloidAngle)[2]
+	cosCone := math.Cos(coneAngle.Radians())
+	gain.OnAxisDb = gainDb(float64(countWithinCone(designOutput.User, axis, cosCone)), float64(countWithinCone(baselineOutput.User, axis, cosCone)))
+
+	hitMapInput := simulationInput.HitMap
+	if hitMapInput == nil {
+		hitMapInput = &defaultGainHitMap
+	}
+	designMap := binUserHits(phoneConfig, hitMapInput, simulationInput, designOutput)
+	baselineMap := binUserHits(phoneConfig, hitMapInput, simulationInput, baselineOutput)
+
+	gain.Scheme = designMap.Scheme
+	gain.Axis = designMap.Axis
+	gain.ThetaBins = designMap.ThetaBins
+	gain.PhiBins = designMap.PhiBins
+	gain.ThetaEdges = designMap.ThetaEdges
+	gain.PhiEdges = designMap.PhiEdges
+	gain.DirectionDb = make([]*float64, len(designMap.Counts))
+	for i := 0; i < len(designMap.Counts); i++ {
+		gain.DirectionDb[i] = gainDb(float64(designMap.Counts[i]), float64(baselineMap.Counts[i]))
+	}
+
+	return gain
+}
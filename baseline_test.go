@@ -0,0 +1,115 @@
+package main
+
+import (
+	"amphora/pkg/units"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestGainDb(t *testing.T) {
+	tests := []struct {
+		design   float64
+		baseline float64
+		// NaN for no gain
+		want float64
+	}{
+		{design: 10, baseline: 10, want: 0},
+		{design: 100, baseline: 10, want: 10},
+		{design: 1, baseline: 100, want: -20},
+		{design: 5, baseline: 0, want: math.NaN()},
+		{design: 0, baseline: 5, want: math.NaN()},
+	}
+	for i := 0; i < len(tests); i++ {
+		gain := gainDb(tests[i].design, tests[i].baseline)
+		if math.IsNaN(tests[i].want) {
+			if gain != nil {
+				t.Errorf("gainDb(%g, %g) = %g, want null", tests[i].design, tests[i].baseline, *gain)
+			}
+			continue
+		}
+		if gain == nil || math.Abs(*gain-tests[i].want) > 1e-12 {
+			t.Errorf("gainDb(%g, %g) = %v, want %g", tests[i].design, tests[i].baseline, gain, tests[i].want)
+		}
+	}
+}
+
+func TestValidateBaselineInput(t *testing.T) {
+	tests := []struct {
+		name     string
+		baseline BaselineInput
+		fields   []string
+	}{
+		{name: "defaults", baseline: BaselineInput{}},
+		{name: "cone in degrees", baseline: BaselineInput{ConeAngle: 30, ConeAngleUnits: "deg"}},
+		{name: "missing units", baseline: BaselineInput{ConeAngle: 30}, fields: []string{"baseline.coneAngleUnits"}},
+		{name: "negative cone", baseline: BaselineInput{ConeAngle: -1, ConeAngleUnits: "rad"}, fields: []string{"baseline.coneAngle"}},
+		{name: "cone wider than the sphere", baseline: BaselineInput{ConeAngle: 200, ConeAngleUnits: "deg"}, fields: []string{"baseline.coneAngle"}},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			validationError := &ValidationError{Errors: []FieldError{}}
+			validateBaselineInput(validationError, &test.baseline)
+			fields := []string{}
+			for j := 0; j < len(validationError.Errors); j++ {
+				fields = append(fields, validationError.Errors[j].Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(test.fields, ",") {
+				t.Errorf("got errors %+v, want them on %v", validationError.Errors, test.fields)
+			}
+		})
+	}
+}
+
+func TestComputeGain(t *testing.T) {
+	simulationInput := testSimulationInput()
+	simulationInput.Paraboloid.Angle = 0
+	simulationInput.Baseline = &BaselineInput{ConeAngle: 10, ConeAngleUnits: "deg"}
+	simulationInput.HitMap = &HitMapInput{Scheme: "thetaPhi", ThetaBins: 2, PhiBins: 1}
+
+	// the design puts ten rays on the paraboloid axis, the bare phone one on
+	// axis and one to the side
+	design := &SimulationOutput{Metrics: SimulationMetrics{RaysAtUser: 10}}
+	for i := 0; i < 10; i++ {
+		design.User = append(design.User, 0, 0, 1)
+	}
+	baseline := &SimulationOutput{User: []float64{0, 0, 1, 0, 0, -1}, Metrics: SimulationMetrics{RaysAtUser: 2}}
+
+	gain := computeGain(testPhoneConfig(), &simulationInput, design, baseline)
+	if gain.TotalDb == nil || math.Abs(*gain.TotalDb-10*math.Log10(5)) > 1e-12 {
+		t.Errorf("got total gain %v, want %g", gain.TotalDb, 10*math.Log10(5))
+	}
+	if gain.OnAxisDb == nil || math.Abs(*gain.OnAxisDb-10) > 1e-12 {
+		t.Errorf("got on-axis gain %v, want 10", gain.OnAxisDb)
+	}
+	if len(gain.DirectionDb) != 2 || gain.DirectionDb[0] == nil || math.Abs(*gain.DirectionDb[0]-10) > 1e-12 || gain.DirectionDb[1] != nil {
+		t.Errorf("got direction gains %v", gain.DirectionDb)
+	}
+	if gain.ConeAngle != 10 || gain.ConeAngleUnits != "deg" {
+		t.Errorf("got cone %g %s, want it as requested", gain.ConeAngle, gain.ConeAngleUnits)
+	}
+}
+
+func TestListeningCone(t *testing.T) {
+	tests := []struct {
+		name      string
+		val       float64
+		unitName  string
+		want      units.Quantity
+		wantAngle float64
+	}{
+		{name: "default", want: units.Quantity{Value: 15, Unit: "deg"}, wantAngle: math.Pi / 12},
+		{name: "degrees", val: 30, unitName: "deg", want: units.Quantity{Value: 30, Unit: "deg"}, wantAngle: math.Pi / 6},
+		{name: "radians", val: 0.5, unitName: "rad", want: units.Quantity{Value: 0.5, Unit: "rad"}, wantAngle: 0.5},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			coneAngle, requested := listeningCone(test.val, test.unitName)
+			if requested != test.want || math.Abs(coneAngle.Radians()-test.wantAngle) > 1e-12 {
+				t.Errorf("got %g rad reported as %+v, want %g rad as %+v", coneAngle.Radians(), requested, test.wantAngle, test.want)
+			}
+		})
+	}
+}
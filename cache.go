@@ -0,0 +1,370 @@
+package main
+
+import (
+	"amphora/pkg/units"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bump whenever the engine output changes. On-disk entries live in a
+// directory per version and those of every other version are deleted.
+const simulationCacheVersion = 5
+
+// simulationCacheVersionDir matches the directories of any version, and
+// simulationCacheFilename the files written before there were any
+var simulationCacheVersionDir = regexp.MustCompile(`^v[0-9]+$`)
+var simulationCacheFilename = regexp.MustCompile(`^[0-9a-f]{64}\.json$`)
+
+type NormalizedSimulationInput struct {
+	PhoneAngle         units.Angle  `json:"phoneAngle"`
+	ParaboloidX        float64      `json:"paraboloidX"`
+	ParaboloidY        float64      `json:"paraboloidY"`
+	ParaboloidZ        float64      `json:"paraboloidZ"`
+	ParaboloidAngle    units.Angle  `json:"paraboloidAngle"`
+	SlicingPlaneHeight units.Length `json:"slicingPlaneHeight"`
+	SlicingPlaneAngle  units.Angle  `json:"slicingPlaneAngle"`
+	UserRadius         units.Length `json:"userRadius"`
+	LinearResolution   units.Length `json:"linearResolution"`
+	AngularResolution  units.Angle  `json:"angularResolution"`
+}
+
+type simulationCacheKeyInput struct {
+	Version           int                       `json:"version"`
+	Phone             PhoneConfig               `json:"phone"`
+	Input             NormalizedSimulationInput `json:"input"`
+	WithoutParaboloid bool                      `json:"withoutParaboloid,omitempty"`
+	RecordRays        bool                      `json:"recordRays,omitempty"`
+}
+
+type SimulationCache struct {
+	mu       sync.Mutex
+	capacity int
+	// the directory of the current version, empty when nothing is persisted
+	dir     string
+	entries map[string]*list.Element
+	order   *list.List
+	// the files in dir, most recently used first, which are deleted from the
+	// back once they add up to more than maxDiskBytes
+	maxDiskBytes int64
+	diskBytes    int64
+	files        map[string]*list.Element
+	fileOrder    *list.List
+}
+
+type simulationCacheEntry struct {
+	key    string
+	output *SimulationOutput
+}
+
+type simulationCacheFile struct {
+	key  string
+	size int64
+}
+
+// normalizeSimulationInput converts every quantity to mm/rad so that
+// equivalent inputs expressed in different units share a cache key. Each
+// missing or unknown unit is reported against its units field.
+func normalizeSimulationInput(simulationInput *SimulationInput) (NormalizedSimulationInput, *ValidationError) {
+	var normalized NormalizedSimulationInput
+	var err error
+	validationError := &ValidationError{Errors: []FieldError{}}
+	report := func(field string, err error) {
+		if err != nil {
+			validationError.Add(field, "%v", err)
+		}
+	}
+
+	normalized.PhoneAngle, err = units.NewAngle(simulationInput.Phone.Angle, simulationInput.Phone.AngleUnits)
+	report("phone.angleUnits", err)
+	normalized.ParaboloidX = simulationInput.Paraboloid.X
+	normalized.ParaboloidY = simulationInput.Paraboloid.Y
+	normalized.ParaboloidZ = simulationInput.Paraboloid.Z
+	normalized.ParaboloidAngle, err = units.NewAngle(simulationInput.Paraboloid.Angle, simulationInput.Paraboloid.AngleUnits)
+	report("paraboloid.angleUnits", err)
+	normalized.SlicingPlaneHeight, err = units.NewLength(simulationInput.SlicingPlane.Height, simulationInput.SlicingPlane.HeightUnits)
+	report("slicingPlane.heightUnits", err)
+	normalized.SlicingPlaneAngle, err = units.NewAngle(simulationInput.SlicingPlane.Angle, simulationInput.SlicingPlane.AngleUnits)
+	report("slicingPlane.angleUnits", err)
+	normalized.UserRadius, err = units.NewLength(simulationInput.UserRadius.Radius, simulationInput.UserRadius.RadiusUnits)
+	report("userRadius.radiusUnits", err)
+	normalized.LinearResolution, err = units.NewLength(simulationInput.Resolution.Linear, defaultUnit(simulationInput.Resolution.LinearUnits, "mm"))
+	report("resolution.linearUnits", err)
+	normalized.AngularResolution, err = units.NewAngle(simulationInput.Resolution.Angular, defaultUnit(simulationInput.Resolution.AngularUnits, "rad"))
+	report("resolution.angularUnits", err)
+
+	return normalized, validationError
+}
+
+// normalizedInput is normalizeSimulationInput for inputs that have already
+// passed validateSimulationInput
+func normalizedInput(simulationInput *SimulationInput) NormalizedSimulationInput {
+	normalized, _ := normalizeSimulationInput(simulationInput)
+	return normalized
+}
+
+func defaultUnit(unit string, fallback string) string {
+	if unit == "" {
+		return fallback
+	}
+	return unit
+}
+
+func simulationCacheKey(phoneConfig *PhoneConfig, simulationInput *SimulationInput, options simulationOptions) (string, error) {
+	var keyInput simulationCacheKeyInput
+	keyInput.Version = simulationCacheVersion
+	keyInput.Phone = *phoneConfig
+	keyInput.Input = normalizedInput(simulationInput)
+	keyInput.WithoutParaboloid = !options.withParaboloid
+	keyInput.RecordRays = options.recordRays
+
+	byteValue, err := json.Marshal(keyInput)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(byteValue)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// NewSimulationCache creates an LRU cache holding up to capacity results in
+// memory. When dir is non-empty every result is also written there so it
+// survives restarts, keeping the most recently used files within maxDiskBytes
+// (no limit when 0).
+func NewSimulationCache(capacity int, dir string, maxDiskBytes int64) (*SimulationCache, error) {
+	cache := &SimulationCache{
+		capacity:     capacity,
+		entries:      make(map[string]*list.Element),
+		order:        list.New(),
+		maxDiskBytes: maxDiskBytes,
+		files:        make(map[string]*list.Element),
+		fileOrder:    list.New(),
+	}
+	if dir == "" {
+		return cache, nil
+	}
+
+	cache.dir = filepath.Join(dir, fmt.Sprintf("v%d", simulationCacheVersion))
+	err := os.MkdirAll(cache.dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	err = removeStaleSimulationCache(dir)
+	if err != nil {
+		return nil, err
+	}
+	err = cache.loadFiles()
+	if err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// removeStaleSimulationCache deletes what earlier versions left in dir
+func removeStaleSimulationCache(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	current := fmt.Sprintf("v%d", simulationCacheVersion)
+	removed := 0
+	for i := 0; i < len(entries); i++ {
+		name := entries[i].Name()
+		stale := false
+		if entries[i].IsDir() {
+			stale = simulationCacheVersionDir.MatchString(name) && name != current
+		} else {
+			stale = simulationCacheFilename.MatchString(name)
+		}
+		if !stale {
+			continue
+		}
+		err = os.RemoveAll(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		removed++
+	}
+	if removed > 0 {
+		log.Printf("simulation cache: removed %d entries left by earlier versions", removed)
+	}
+	return nil
+}
+
+// loadFiles indexes the files already in dir by modification time, which
+// Get refreshes, so the least recently used are evicted first
+func (cache *SimulationCache) loadFiles() error {
+	entries, err := os.ReadDir(cache.dir)
+	if err != nil {
+		return err
+	}
+
+	type cacheFile struct {
+		simulationCacheFile
+		modTime time.Time
+	}
+	files := []cacheFile{}
+	for i := 0; i < len(entries); i++ {
+		name := entries[i].Name()
+		if !simulationCacheFilename.MatchString(name) {
+			continue
+		}
+		info, err := entries[i].Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cacheFile{simulationCacheFile{key: strings.TrimSuffix(name, ".json"), size: info.Size()}, info.ModTime()})
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for i := 0; i < len(files); i++ {
+		file := files[i].simulationCacheFile
+		cache.files[file.key] = cache.fileOrder.PushBack(&file)
+		cache.diskBytes += file.size
+	}
+	cache.evictFiles()
+	return nil
+}
+
+func (cache *SimulationCache) Get(key string) (*SimulationOutput, bool) {
+	cache.mu.Lock()
+	element, ok := cache.entries[key]
+	if ok {
+		cache.order.MoveToFront(element)
+		cache.mu.Unlock()
+		return element.Value.(*simulationCacheEntry).output, true
+	}
+	cache.mu.Unlock()
+
+	if cache.dir == "" {
+		return nil, false
+	}
+
+	output, err := cache.readFile(key)
+	if err != nil {
+		return nil, false
+	}
+
+	cache.add(key, output)
+	cache.touchFile(key)
+	return output, true
+}
+
+func (cache *SimulationCache) Put(key string, output *SimulationOutput) error {
+	cache.add(key, output)
+
+	if cache.dir == "" {
+		return nil
+	}
+	return cache.writeFile(key, output)
+}
+
+func (cache *SimulationCache) add(key string, output *SimulationOutput) {
+	if cache.capacity <= 0 {
+		return
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	element, ok := cache.entries[key]
+	if ok {
+		element.Value.(*simulationCacheEntry).output = output
+		cache.order.MoveToFront(element)
+		return
+	}
+
+	cache.entries[key] = cache.order.PushFront(&simulationCacheEntry{key: key, output: output})
+	for cache.order.Len() > cache.capacity {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*simulationCacheEntry).key)
+	}
+}
+
+func (cache *SimulationCache) path(key string) string {
+	return filepath.Join(cache.dir, key+".json")
+}
+
+func (cache *SimulationCache) readFile(key string) (*SimulationOutput, error) {
+	var output SimulationOutput
+	err := readJsonFile(cache.path(key), &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return &output, nil
+}
+
+func (cache *SimulationCache) writeFile(key string, output *SimulationOutput) error {
+	byteValue, err := json.Marshal(output)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(cache.path(key), byteValue)
+	if err != nil {
+		return err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	element, ok := cache.files[key]
+	if ok {
+		file := element.Value.(*simulationCacheFile)
+		cache.diskBytes += int64(len(byteValue)) - file.size
+		file.size = int64(len(byteValue))
+		cache.fileOrder.MoveToFront(element)
+	} else {
+		cache.files[key] = cache.fileOrder.PushFront(&simulationCacheFile{key: key, size: int64(len(byteValue))})
+		cache.diskBytes += int64(len(byteValue))
+	}
+	cache.evictFiles()
+	return nil
+}
+
+// touchFile marks a file read from disk as recently used, in its
+// modification time too so the order survives a restart
+func (cache *SimulationCache) touchFile(key string) {
+	now := time.Now()
+	os.Chtimes(cache.path(key), now, now)
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	element, ok := cache.files[key]
+	if ok {
+		cache.fileOrder.MoveToFront(element)
+	}
+}
+
+// evictFiles deletes the least recently used files until the rest fit in
+// maxDiskBytes, always keeping the newest. The caller holds mu.
+func (cache *SimulationCache) evictFiles() {
+	if cache.maxDiskBytes <= 0 {
+		return
+	}
+	for cache.diskBytes > cache.maxDiskBytes && cache.fileOrder.Len() > 1 {
+		oldest := cache.fileOrder.Back()
+		file := oldest.Value.(*simulationCacheFile)
+		err := os.Remove(cache.path(file.key))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("simulation cache: %v", err)
+		}
+		cache.fileOrder.Remove(oldest)
+		delete(cache.files, file.key)
+		cache.diskBytes -= file.size
+	}
+}
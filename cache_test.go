@@ -0,0 +1,198 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testSimulationOutput returns an output told apart by its only user hit
+func testSimulationOutput(id float64) *SimulationOutput {
+	return &SimulationOutput{User: []float64{id}}
+}
+
+func TestSimulationCacheKeyNormalization(t *testing.T) {
+	phoneConfig := testPhoneConfig()
+	base := testSimulationInput()
+	baseKey, err := simulationCacheKey(phoneConfig, &base, simulationOptions{withParaboloid: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name              string
+		change            func(simulationInput *SimulationInput, phoneConfig *PhoneConfig)
+		withoutParaboloid bool
+		recordRays        bool
+		sameKey           bool
+	}{
+		{name: "unchanged", change: func(*SimulationInput, *PhoneConfig) {}, sameKey: true},
+		{name: "height in mm", sameKey: true, change: func(simulationInput *SimulationInput, _ *PhoneConfig) {
+			simulationInput.SlicingPlane.Height, simulationInput.SlicingPlane.HeightUnits = 150, "mm"
+		}},
+		{name: "radius in cm", sameKey: true, change: func(simulationInput *SimulationInput, _ *PhoneConfig) {
+			simulationInput.UserRadius.Radius, simulationInput.UserRadius.RadiusUnits = 100, "cm"
+		}},
+		{name: "another phone file with the same dimensions", sameKey: true, change: func(simulationInput *SimulationInput, _ *PhoneConfig) {
+			simulationInput.Phone.Filename = "copy.xml"
+		}},
+		{name: "another height", change: func(simulationInput *SimulationInput, _ *PhoneConfig) {
+			simulationInput.SlicingPlane.Height = 16
+		}},
+		{name: "height in another unit", change: func(simulationInput *SimulationInput, _ *PhoneConfig) {
+			simulationInput.SlicingPlane.HeightUnits = "mm"
+		}},
+		{name: "another resolution", change: func(simulationInput *SimulationInput, _ *PhoneConfig) {
+			simulationInput.Resolution.Angular = 0.05
+		}},
+		{name: "bare phone baseline", change: func(*SimulationInput, *PhoneConfig) {}, withoutParaboloid: true},
+		{name: "recorded rays", change: func(*SimulationInput, *PhoneConfig) {}, recordRays: true},
+		{name: "another phone", change: func(_ *SimulationInput, phoneConfig *PhoneConfig) {
+			phoneConfig.Width += 1
+		}},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			simulationInput := testSimulationInput()
+			changedPhone := *phoneConfig
+			test.change(&simulationInput, &changedPhone)
+			key, err := simulationCacheKey(&changedPhone, &simulationInput, simulationOptions{withParaboloid: !test.withoutParaboloid, recordRays: test.recordRays})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (key == baseKey) != test.sameKey {
+				t.Errorf("same key is %v, want %v", key == baseKey, test.sameKey)
+			}
+		})
+	}
+}
+
+func TestSimulationCacheEviction(t *testing.T) {
+	cache, err := NewSimulationCache(2, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Put("a", testSimulationOutput(1))
+	cache.Put("b", testSimulationOutput(2))
+	// reading a makes b the least recently used
+	_, ok := cache.Get("a")
+	if !ok {
+		t.Fatal("a missing before eviction")
+	}
+	cache.Put("c", testSimulationOutput(3))
+
+	wants := map[string]float64{"a": 1, "b": 0, "c": 3}
+	for key, want := range wants {
+		output, ok := cache.Get(key)
+		if want == 0 {
+			if ok {
+				t.Errorf("%s: still cached", key)
+			}
+			continue
+		}
+		if !ok || output.User[0] != want {
+			t.Errorf("%s: got %v, %v, want %v", key, output, ok, want)
+		}
+	}
+
+	// replacing an entry keeps the count the same
+	cache.Put("c", testSimulationOutput(4))
+	output, ok := cache.Get("c")
+	if !ok || output.User[0] != 4 || cache.order.Len() != 2 {
+		t.Errorf("after replacing c: got %v, %v with %d entries", output, ok, cache.order.Len())
+	}
+
+	disabled, err := NewSimulationCache(0, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disabled.Put("a", testSimulationOutput(1))
+	_, ok = disabled.Get("a")
+	if ok {
+		t.Error("a cache of size 0 kept a result")
+	}
+}
+
+func TestSimulationCacheDir(t *testing.T) {
+	dir := t.TempDir()
+	stale := []string{"v1", "v4", strings.Repeat("a", 64) + ".json"}
+	kept := []string{"notes.txt", "other"}
+	err := os.Mkdir(filepath.Join(dir, "v1"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir(filepath.Join(dir, "v4"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir(filepath.Join(dir, "other"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []string{stale[2], kept[0]}
+	for i := 0; i < len(files); i++ {
+		err = os.WriteFile(filepath.Join(dir, files[i]), []byte("{}"), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, err := NewSimulationCache(0, dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(stale); i++ {
+		_, err = os.Stat(filepath.Join(dir, stale[i]))
+		if !os.IsNotExist(err) {
+			t.Errorf("%s left by an earlier version was not removed: %v", stale[i], err)
+		}
+	}
+	for i := 0; i < len(kept); i++ {
+		_, err = os.Stat(filepath.Join(dir, kept[i]))
+		if err != nil {
+			t.Errorf("%s was removed: %v", kept[i], err)
+		}
+	}
+
+	keys := []string{strings.Repeat("1", 64), strings.Repeat("2", 64), strings.Repeat("3", 64)}
+	err = first.Put(keys[0], testSimulationOutput(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every output below takes as many bytes, and two fit in the limit
+	fileSize := first.diskBytes
+
+	cache, err := NewSimulationCache(0, dir, 2*fileSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.diskBytes != fileSize {
+		t.Errorf("found %d bytes on disk, want %d", cache.diskBytes, fileSize)
+	}
+	err = cache.Put(keys[1], testSimulationOutput(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// reading the first file makes the second the least recently used
+	got, ok := cache.Get(keys[0])
+	if !ok || got.User[0] != 1 {
+		t.Fatalf("%s: got %v, %v from disk", keys[0], got, ok)
+	}
+	err = cache.Put(keys[2], testSimulationOutput(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wants := []bool{true, false, true}
+	for i := 0; i < len(keys); i++ {
+		_, err = os.Stat(cache.path(keys[i]))
+		if wants[i] != (err == nil) {
+			t.Errorf("%s on disk is %v, want %v", keys[i], err == nil, wants[i])
+		}
+	}
+	if cache.diskBytes > 2*fileSize {
+		t.Errorf("%d bytes on disk, over the limit of %d", cache.diskBytes, 2*fileSize)
+	}
+}
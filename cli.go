@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `usage: amphora <command> [flags]
+
+commands:
+  serve                     run the web UI and API (default)
+  simulate -i in -o out     run one simulation from a SimulationInput JSON file,
+                            -format ply|obj|csv|glb exports the hits and ray paths
+  sweep -i in -o out        run a parameter sweep from a SweepInput JSON file
+  phones list               list the phone catalog
+  phones show <name>        print one phone's dimensions
+  phones import <specs.csv> add phones from a CSV spec sheet, reporting
+                            rejected rows; -update replaces existing phones
+
+Use "amphora <command> -h" for the flags of a command.
+`
+
+// errValidation marks a failure that has already been reported in detail
+var errValidation = errors.New("invalid input")
+
+type cacheFlags struct {
+	size    *int
+	dir     *string
+	dirSize *int64
+}
+
+func addCacheFlags(flags *flag.FlagSet) *cacheFlags {
+	return &cacheFlags{
+		size:    flags.Int("cache-size", 64, "number of simulation results kept in memory (0 disables the cache)"),
+		dir:     flags.String("cache-dir", "", "directory for persisting cached simulation results"),
+		dirSize: flags.Int64("cache-dir-max-mb", 1024, "most megabytes of results kept in -cache-dir, least recently used go first (0 for no limit)"),
+	}
+}
+
+func (flags *cacheFlags) open() error {
+	if *flags.dirSize < 0 {
+		return fmt.Errorf("-cache-dir-max-mb must not be negative, got %d", *flags.dirSize)
+	}
+	var err error
+	simulationCache, err = NewSimulationCache(*flags.size, *flags.dir, *flags.dirSize<<20)
+	return err
+}
+
+// addLimitFlags lets the limits on request size be raised or lowered
+func addLimitFlags(flags *flag.FlagSet) {
+	flags.IntVar(&maxSimulationRays, "max-rays", maxSimulationRays, "most rays a single simulation may trace")
+	flags.IntVar(&maxSweepPoints, "max-sweep-points", maxSweepPoints, "most simulations a single sweep may run")
+	flags.IntVar(&maxSweepRays, "max-sweep-rays", maxSweepRays, "most rays all the simulations of a single sweep may trace")
+	flags.IntVar(&maxOptimizeEvaluations, "max-optimize-evaluations", maxOptimizeEvaluations, "most simulations a single optimization may run")
+	flags.IntVar(&maxHitMapBins, "max-hitmap-bins", maxHitMapBins, "most theta or phi bins a hit map may have")
+}
+
+func checkLimitFlags() error {
+	names := []string{"max-rays", "max-sweep-points", "max-sweep-rays", "max-optimize-evaluations", "max-hitmap-bins"}
+	limits := []int{maxSimulationRays, maxSweepPoints, maxSweepRays, maxOptimizeEvaluations, maxHitMapBins}
+	for i := 0; i < len(limits); i++ {
+		if limits[i] < 1 {
+			return fmt.Errorf("-%s must be at least 1, got %d", names[i], limits[i])
+		}
+	}
+	return nil
+}
+
+type phoneFlags struct {
+	dir      *string
+	embedded *bool
+}
+
+func addPhoneFlags(flags *flag.FlagSet) *phoneFlags {
+	return &phoneFlags{
+		dir:      flags.String("phones-dir", "phones", "directory of phones added to or overriding the built in catalog, created on first write"),
+		embedded: flags.Bool("phones-embedded", false, "serve only the read-only phone catalog built into the binary"),
+	}
+}
+
+func (flags *phoneFlags) open() {
+	if *flags.embedded {
+		phoneStore = NewEmbeddedPhoneStore()
+		return
+	}
+	phoneStore = NewOverlayPhoneStore(NewDirPhoneStore(*flags.dir), NewEmbeddedPhoneStore())
+}
+
+// runCommand dispatches to a subcommand and returns the process exit code
+func runCommand(args []string) int {
+	command := "serve"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		command = args[0]
+		args = args[1:]
+	}
+
+	var err error
+	switch command {
+	case "serve":
+		err = runServe(args)
+	case "simulate":
+		err = runSimulate(args)
+	case "sweep":
+		err = runSweep(args)
+	case "phones":
+		err = runPhones(args)
+	case "help":
+		fmt.Fprint(os.Stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "amphora: unknown command %q\n\n%s", command, usage)
+		return 2
+	}
+
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		if !errors.Is(err, errValidation) {
+			fmt.Fprintf(os.Stderr, "amphora %s: %v\n", command, err)
+		}
+		return 1
+	}
+	return 0
+}
+
+func readJsonInput(path string, v any) error {
+	var byteValue []byte
+	var err error
+	if path == "" || path == "-" {
+		byteValue, err = io.ReadAll(os.Stdin)
+	} else {
+		byteValue, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(byteValue, v)
+}
+
+func writeJsonOutput(path string, v any) error {
+	if path == "" || path == "-" {
+		encoder := json.NewEncoder(os.Stdout)
+		return encoder.Encode(v)
+	}
+	return writeJsonFile(path, v)
+}
+
+func reportValidationError(validationError *ValidationError) error {
+	for i := 0; i < len(validationError.Errors); i++ {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", validationError.Errors[i].Field, validationError.Errors[i].Message)
+	}
+	return errValidation
+}
+
+func runSimulate(args []string) error {
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	inputPath := flags.String("i", "-", "SimulationInput JSON file, - for stdin")
+	outputPath := flags.String("o", "-", "output file, - for stdout")
+	format := flags.String("format", "", "json, ply, obj, csv or glb (default from the -o extension, else json)")
+	cacheFlags := addCacheFlags(flags)
+	phoneFlags := addPhoneFlags(flags)
+	addLimitFlags(flags)
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	err = checkLimitFlags()
+	if err != nil {
+		return err
+	}
+
+	phoneFlags.open()
+
+	if *format == "" {
+		*format = exportFormatFromPath(*outputPath)
+	}
+	if _, ok := exportFormats[*format]; !ok && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	err = cacheFlags.open()
+	if err != nil {
+		return err
+	}
+
+	var simulationInput SimulationInput
+	err = readJsonInput(*inputPath, &simulationInput)
+	if err != nil {
+		return err
+	}
+
+	if *format != "json" {
+		simulationInput.RecordRays = true
+	}
+
+	phoneConfig, validationError := prepareSimulation(&simulationInput)
+	if validationError != nil {
+		return reportValidationError(validationError)
+	}
+
+	simulationOutput, _, err := runSimulation(phoneConfig, &simulationInput)
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		return writeJsonOutput(*outputPath, simulationOutput)
+	}
+	return writeExportOutput(*outputPath, *format, phoneConfig, &simulationInput, simulationOutput)
+}
+
+func runSweep(args []string) error {
+	flags := flag.NewFlagSet("sweep", flag.ContinueOnError)
+	inputPath := flags.String("i", "-", "SweepInput JSON file, - for stdin")
+	outputPath := flags.String("o", "-", "output JSON file, - for stdout")
+	cacheFlags := addCacheFlags(flags)
+	phoneFlags := addPhoneFlags(flags)
+	addLimitFlags(flags)
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	err = checkLimitFlags()
+	if err != nil {
+		return err
+	}
+
+	phoneFlags.open()
+
+	err = cacheFlags.open()
+	if err != nil {
+		return err
+	}
+
+	var sweepInput SweepInput
+	err = readJsonInput(*inputPath, &sweepInput)
+	if err != nil {
+		return err
+	}
+
+	sweepOutput, validationError, err := executeSweep(context.Background(), &sweepInput)
+	if validationError != nil {
+		return reportValidationError(validationError)
+	}
+	if err != nil {
+		return err
+	}
+
+	return writeJsonOutput(*outputPath, sweepOutput)
+}
+
+func runPhones(args []string) error {
+	flags := flag.NewFlagSet("phones", flag.ContinueOnError)
+	phoneFlags := addPhoneFlags(flags)
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	phoneFlags.open()
+
+	args = flags.Args()
+	if len(args) == 0 {
+		return errors.New("expected a subcommand: list, show or import")
+	}
+
+	switch args[0] {
+	case "list":
+		phoneOptions, err := phoneStore.List()
+		if err != nil {
+			return err
+		}
+		invalid := 0
+		for i := 0; i < len(phoneOptions); i++ {
+			if len(phoneOptions[i].Errors) > 0 {
+				fmt.Fprintln(os.Stderr, phoneOptions[i].Errors.Error())
+				invalid++
+				continue
+			}
+			fmt.Printf("%s\t%s\n", phoneOptions[i].Name, phoneOptions[i].Filename)
+		}
+		if invalid > 0 {
+			return fmt.Errorf("%d invalid phone definitions", invalid)
+		}
+		return nil
+	case "show":
+		if len(args) != 2 {
+			return errors.New("usage: amphora phones show <name>")
+		}
+		phoneConfig, err := phoneStore.Get(args[1])
+		if err != nil {
+			return err
+		}
+		return writeJsonOutput("-", phoneConfig)
+	case "import":
+		return runPhonesImport(args[1:])
+	}
+
+	return fmt.Errorf("unknown phones subcommand %q", args[0])
+}
+
+// runPhonesImport adds the phones of a CSV spec sheet, listing rejected rows
+// on stderr as file:line: id: field: message
+func runPhonesImport(args []string) error {
+	flags := flag.NewFlagSet("phones import", flag.ContinueOnError)
+	update := flags.Bool("update", false, "replace phones that already exist instead of rejecting their rows")
+	dryRun := flags.Bool("dry-run", false, "validate the sheet without changing the catalog")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: amphora phones import [-update] [-dry-run] <specs.csv>")
+	}
+	path := flags.Arg(0)
+
+	var input io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+	}
+
+	report, validationError, err := importPhonesCsv(input, phoneStore, phoneImportOptions{update: *update, dryRun: *dryRun})
+	if validationError != nil {
+		return reportValidationError(validationError)
+	}
+	if report == nil {
+		return err
+	}
+
+	for i := 0; i < len(report.Imported); i++ {
+		fmt.Printf("imported\t%s\n", report.Imported[i])
+	}
+	for i := 0; i < len(report.Updated); i++ {
+		fmt.Printf("updated\t%s\n", report.Updated[i])
+	}
+	for i := 0; i < len(report.Rejected); i++ {
+		rejection := report.Rejected[i]
+		for j := 0; j < len(rejection.Errors); j++ {
+			fmt.Fprintf(os.Stderr, "%s:%d: %s: %s: %s\n", path, rejection.Line, rejection.Id, rejection.Errors[j].Field, rejection.Errors[j].Message)
+		}
+	}
+	// the rows listed above were written even if the store failed after them
+	if err != nil {
+		return err
+	}
+	if len(report.Rejected) > 0 {
+		return fmt.Errorf("%d rows rejected", len(report.Rejected))
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunCommandExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	invalidPath := filepath.Join(dir, "invalid.json")
+	err := os.WriteFile(invalidPath, []byte(`{"phone": {"filename": ""}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	malformedPath := filepath.Join(dir, "malformed.json")
+	err = os.WriteFile(malformedPath, []byte(`{`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{name: "help", args: []string{"help"}, want: 0},
+		{name: "flag help", args: []string{"simulate", "-h"}, want: 0},
+		{name: "unknown command", args: []string{"render"}, want: 2},
+		{name: "unknown flag", args: []string{"simulate", "-x"}, want: 1},
+		{name: "invalid input", args: []string{"simulate", "-i", invalidPath, "-cache-size", "0"}, want: 1},
+		{name: "malformed input", args: []string{"sweep", "-i", malformedPath, "-cache-size", "0"}, want: 1},
+		{name: "missing input file", args: []string{"simulate", "-i", filepath.Join(dir, "missing.json")}, want: 1},
+		{name: "phones without subcommand", args: []string{"phones"}, want: 1},
+		{name: "unknown phone", args: []string{"phones", "show", "nokia3310"}, want: 1},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			code := runCommand(test.args)
+			if code != test.want {
+				t.Errorf("runCommand(%q) = %d, want %d", test.args, code, test.want)
+			}
+		})
+	}
+}
+
+func TestRunCommandSimulate(t *testing.T) {
+	dir := t.TempDir()
+	simulationInput := testSimulationInput()
+	simulationInput.Resolution = ResolutionInput{Linear: 2, Angular: 0.5}
+	byteValue, err := json.Marshal(simulationInput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputPath := filepath.Join(dir, "input.json")
+	err = os.WriteFile(inputPath, byteValue, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputPath := filepath.Join(dir, "output.json")
+
+	code := runCommand([]string{"simulate", "-i", inputPath, "-o", outputPath, "-cache-size", "0"})
+	if code != 0 {
+		t.Fatalf("simulate exited with %d", code)
+	}
+
+	byteValue, err = os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var simulationOutput SimulationOutput
+	err = json.Unmarshal(byteValue, &simulationOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if simulationOutput.Metrics.RaysEmitted == 0 || len(simulationOutput.User)/3 != simulationOutput.Metrics.RaysAtUser {
+		t.Errorf("got metrics %+v with %d user hits", simulationOutput.Metrics, len(simulationOutput.User)/3)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"amphora/pkg/units"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CompareInput struct {
+	A SimulationInput `json:"a"`
+	B SimulationInput `json:"b"`
+	// half angle of the listening cone around a's paraboloid axis, 15° by default
+	ConeAngle      float64 `json:"coneAngle,omitempty" quantity:"angle,ConeAngleUnits"`
+	ConeAngleUnits string  `json:"coneAngleUnits,omitempty"`
+}
+
+func (compareInput *CompareInput) UnmarshalJSON(data []byte) error {
+	type plain CompareInput
+	return decodeQuantities(data, (*plain)(compareInput))
+}
+
+// CompareDifference reports b relative to a. Directions are measured in a's
+// frame so that both designs are binned on the same grid.
+// The cone the on-axis gain counts is echoed as the request gave it.
+type CompareDifference struct {
+	TotalGainDb                  *float64  `json:"totalGainDb"`
+	OnAxisGainDb                 *float64  `json:"onAxisGainDb"`
+	ConeAngle                    float64   `json:"coneAngle"`
+	ConeAngleUnits               string    `json:"coneAngleUnits"`
+	MeanBouncesDifference        float64   `json:"meanBouncesDifference"`
+	ParaboloidFractionDifference float64   `json:"paraboloidFractionDifference"`
+	PhoneRehitFractionDifference float64   `json:"phoneRehitFractionDifference"`
+	AngularSpreadDifference      float64   `json:"angularSpreadDifference"`
+	BounceHistogramDifference    []int     `json:"bounceHistogramDifference"`
+	Scheme                       string    `json:"scheme"`
+	Axis                         string    `json:"axis"`
+	ThetaBins                    int       `json:"thetaBins"`
+	PhiBins                      int       `json:"phiBins"`
+	ThetaEdges                   []float64 `json:"thetaEdges"`
+	PhiEdges                     []float64 `json:"phiEdges"`
+	DensityDifference            []float64 `json:"densityDifference"`
+}
+
+type CompareOutput struct {
+	A          *SimulationOutput `json:"a"`
+	B          *SimulationOutput `json:"b"`
+	Difference CompareDifference `json:"difference"`
+}
+
+func validateCompareInput(compareInput *CompareInput, phoneConfigA *PhoneConfig, phoneConfigB *PhoneConfig) *ValidationError {
+	validationError := &ValidationError{Errors: []FieldError{}}
+
+	errorA := validateSimulationInput(&compareInput.A, phoneConfigA)
+	for i := 0; i < len(errorA.Errors); i++ {
+		validationError.Add("a."+errorA.Errors[i].Field, "%s", errorA.Errors[i].Message)
+	}
+	errorB := validateSimulationInput(&compareInput.B, phoneConfigB)
+	for i := 0; i < len(errorB.Errors); i++ {
+		validationError.Add("b."+errorB.Errors[i].Field, "%s", errorB.Errors[i].Message)
+	}
+
+	// identical resolutions give both designs the same emitted ray directions
+	normalizedA := normalizedInput(&compareInput.A)
+	normalizedB := normalizedInput(&compareInput.B)
+	if normalizedA.LinearResolution != normalizedB.LinearResolution || normalizedA.AngularResolution != normalizedB.AngularResolution {
+		validationError.Add("b.resolution", "must match a.resolution so both designs trace the same rays")
+	}
+
+	if compareInput.ConeAngle != 0 || compareInput.ConeAngleUnits != "" {
+		if validateUnit(validationError, "coneAngleUnits", compareInput.ConeAngleUnits, units.KindAngle) {
+			validatePositive(validationError, "coneAngle", compareInput.ConeAngle)
+		}
+	}
+
+	return validationError
+}
+
+func compareOutputs(phoneConfigA *PhoneConfig, compareInput *CompareInput, outputA *SimulationOutput, outputB *SimulationOutput) CompareDifference {
+	var difference CompareDifference
+	metricsA := outputA.Metrics
+	metricsB := outputB.Metrics
+
+	difference.TotalGainDb = gainDb(float64(metricsB.RaysAtUser), float64(metricsA.RaysAtUser))
+
+	coneAngle, requested := listeningCone(compareInput.ConeAngle, compareInput.ConeAngleUnits)
+	difference.ConeAngle = requested.Value
+	difference.ConeAngleUnits = requested.Unit
+	axis := paraboloidFrame(normalizedInput(&compareInput.A).ParaboloidAngle)[2]
+	cosCone := math.Cos(coneAngle.Radians())
+	difference.OnAxisGainDb = gainDb(float64(countWithinCone(outputB.User, axis, cosCone)), float64(countWithinCone(outputA.User, axis, cosCone)))
+
+	difference.MeanBouncesDifference = metricsB.MeanBounces - metricsA.MeanBounces
+	difference.ParaboloidFractionDifference = metricsB.ParaboloidFraction - metricsA.ParaboloidFraction
+	difference.PhoneRehitFractionDifference = metricsB.PhoneRehitFraction - metricsA.PhoneRehitFraction
+	difference.AngularSpreadDifference = metricsB.AngularSpread - metricsA.AngularSpread
+
+	bins := max(len(metricsA.BounceHistogram), len(metricsB.BounceHistogram))
+	difference.BounceHistogramDifference = make([]int, bins)
+	for i := 0; i < bins; i++ {
+		if i < len(metricsB.BounceHistogram) {
+			difference.BounceHistogramDifference[i] += metricsB.BounceHistogram[i]
+		}
+		if i < len(metricsA.BounceHistogram) {
+			difference.BounceHistogramDifference[i] -= metricsA.BounceHistogram[i]
+		}
+	}
+
+	hitMapInput := compareInput.A.HitMap
+	if hitMapInput == nil {
+		hitMapInput = &defaultGainHitMap
+	}
+	mapA := binUserHits(phoneConfigA, hitMapInput, &compareInput.A, outputA)
+	mapB := binUserHits(phoneConfigA, hitMapInput, &compareInput.A, outputB)
+
+	difference.Scheme = mapA.Scheme
+	difference.Axis = mapA.Axis
+	difference.ThetaBins = mapA.ThetaBins
+	difference.PhiBins = mapA.PhiBins
+	difference.ThetaEdges = mapA.ThetaEdges
+	difference.PhiEdges = mapA.PhiEdges
+	difference.DensityDifference = make([]float64, len(mapA.Densities))
+	for i := 0; i < len(mapA.Densities); i++ {
+		difference.DensityDifference[i] = mapB.Densities[i] - mapA.Densities[i]
+	}
+
+	return difference
+}
+
+func HandleApiCompare(c *gin.Context) {
+	var compareInput CompareInput
+	err := c.ShouldBindJSON(&compareInput)
+	if err != nil {
+		validationError := &ValidationError{}
+		validationError.Add("body", "malformed request body: %v", err)
+		abortWithValidationError(c, validationError)
+		return
+	}
+
+	phoneConfigA := lookupPhoneConfig(compareInput.A.Phone.Filename)
+	phoneConfigB := lookupPhoneConfig(compareInput.B.Phone.Filename)
+
+	validationError := validateCompareInput(&compareInput, phoneConfigA, phoneConfigB)
+	if !validationError.Empty() {
+		abortWithValidationError(c, validationError)
+		return
+	}
+
+	var outputA, outputB *SimulationOutput
+	var errA, errB error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		outputA, _, errA = runWorldSimulation(c.Request.Context(), phoneConfigA, &compareInput.A)
+	}()
+	go func() {
+		defer wg.Done()
+		outputB, _, errB = runWorldSimulation(c.Request.Context(), phoneConfigB, &compareInput.B)
+	}()
+	wg.Wait()
+
+	if errA != nil {
+		abortWithSimulationError(c, errA)
+		return
+	}
+	if errB != nil {
+		abortWithSimulationError(c, errB)
+		return
+	}
+
+	// the difference is measured in world coordinates, before each design is
+	// written out in the coordinates it asked for
+	var compareOutput CompareOutput
+	compareOutput.Difference = compareOutputs(phoneConfigA, &compareInput, outputA, outputB)
+	compareOutput.A, errA = frameSimulationOutput(phoneConfigA, &compareInput.A, outputA)
+	compareOutput.B, errB = frameSimulationOutput(phoneConfigB, &compareInput.B, outputB)
+	if errA != nil || errB != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.Join(errA, errB))
+		return
+	}
+
+	c.JSON(http.StatusOK, compareOutput)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateCompareInput(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(compareInput *CompareInput)
+		fields []string
+	}{
+		{name: "valid", change: func(*CompareInput) {}},
+		{name: "errors are prefixed by design", fields: []string{"a.paraboloid.x", "b.phone.angle"}, change: func(compareInput *CompareInput) {
+			compareInput.A.Paraboloid.X = 0
+			compareInput.B.Phone.Angle = 90
+		}},
+		{name: "different resolutions", fields: []string{"b.resolution"}, change: func(compareInput *CompareInput) {
+			compareInput.B.Resolution.Angular = 0.2
+		}},
+		{name: "cone without units", fields: []string{"coneAngleUnits"}, change: func(compareInput *CompareInput) {
+			compareInput.ConeAngle = 10
+		}},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			compareInput := CompareInput{A: testSimulationInput(), B: testSimulationInput()}
+			test.change(&compareInput)
+			validationError := validateCompareInput(&compareInput, testPhoneConfig(), testPhoneConfig())
+			fields := []string{}
+			for j := 0; j < len(validationError.Errors); j++ {
+				fields = append(fields, validationError.Errors[j].Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(test.fields, ",") {
+				t.Errorf("got errors %+v, want them on %v", validationError.Errors, test.fields)
+			}
+		})
+	}
+}
+
+func TestCompareOutputs(t *testing.T) {
+	compareInput := CompareInput{A: testSimulationInput(), B: testSimulationInput()}
+	compareInput.A.Paraboloid.Angle = 0
+	compareInput.A.HitMap = &HitMapInput{Scheme: "thetaPhi", ThetaBins: 2, PhiBins: 1}
+
+	outputA := &SimulationOutput{
+		User:    []float64{0, 0, 1, 0, 0, -1},
+		Metrics: SimulationMetrics{RaysAtUser: 2, MeanBounces: 1, BounceHistogram: []int{1, 1}},
+	}
+	outputB := &SimulationOutput{
+		User:    []float64{0, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 1},
+		Metrics: SimulationMetrics{RaysAtUser: 4, MeanBounces: 2.5, BounceHistogram: []int{0, 1, 2, 1}},
+	}
+
+	difference := compareOutputs(testPhoneConfig(), &compareInput, outputA, outputB)
+	if difference.TotalGainDb == nil || math.Abs(*difference.TotalGainDb-10*math.Log10(2)) > 1e-12 {
+		t.Errorf("got total gain %v, want %g", difference.TotalGainDb, 10*math.Log10(2))
+	}
+	if difference.OnAxisGainDb == nil || math.Abs(*difference.OnAxisGainDb-10*math.Log10(4)) > 1e-12 {
+		t.Errorf("got on-axis gain %v, want %g", difference.OnAxisGainDb, 10*math.Log10(4))
+	}
+	if difference.MeanBouncesDifference != 1.5 {
+		t.Errorf("got mean bounces difference %g, want 1.5", difference.MeanBouncesDifference)
+	}
+	if !reflect.DeepEqual(difference.BounceHistogramDifference, []int{-1, 0, 2, 1}) {
+		t.Errorf("got histogram difference %v, want [-1 0 2 1]", difference.BounceHistogramDifference)
+	}
+	if len(difference.DensityDifference) != 2 || !(difference.DensityDifference[0] > 0) || !(difference.DensityDifference[1] < 0) {
+		t.Errorf("got density difference %v, want more on axis and less behind", difference.DensityDifference)
+	}
+	if difference.ConeAngle != 15 || difference.ConeAngleUnits != "deg" {
+		t.Errorf("got cone %g %s, want the default 15 deg", difference.ConeAngle, difference.ConeAngleUnits)
+	}
+
+	// a requested cone is echoed as given
+	compareInput.ConeAngle = 0.2
+	compareInput.ConeAngleUnits = "rad"
+	difference = compareOutputs(testPhoneConfig(), &compareInput, outputA, outputB)
+	if difference.ConeAngle != 0.2 || difference.ConeAngleUnits != "rad" {
+		t.Errorf("got cone %g %s, want 0.2 rad", difference.ConeAngle, difference.ConeAngleUnits)
+	}
+}
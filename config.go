@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// every serve flag can also be set from the environment, -phones-dir as
+// AMPHORA_PHONES_DIR, or from the config file under its own name
+const configEnvPrefix = "AMPHORA_"
+
+// basePath is the URL path the server is mounted under, empty for the root
+var basePath string
+
+type serverFlags struct {
+	config       *string
+	listen       *string
+	tlsCert      *string
+	tlsKey       *string
+	basePath     *string
+	drainTimeout *time.Duration
+}
+
+func addServerFlags(flags *flag.FlagSet) *serverFlags {
+	return &serverFlags{
+		config:       flags.String("config", "", "YAML file of flag values keyed by flag name, for flags not given on the command line or in the environment"),
+		listen:       flags.String("listen", "localhost:8080", "address to listen on"),
+		tlsCert:      flags.String("tls-cert", "", "certificate file, serves HTTPS together with -tls-key"),
+		tlsKey:       flags.String("tls-key", "", "private key file for -tls-cert"),
+		basePath:     flags.String("base-path", "", "URL path to serve under, such as /amphora behind a reverse proxy"),
+		drainTimeout: addDurationFlag(flags, "drain-timeout", 30*time.Second, "how long a shutdown waits for running requests before cancelling their simulations, in seconds without a unit"),
+	}
+}
+
+func (flags *serverFlags) check() error {
+	if (*flags.tlsCert == "") != (*flags.tlsKey == "") {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+	basePath = normalizeBasePath(*flags.basePath)
+	return nil
+}
+
+// normalizeBasePath gives the base path a leading slash and no trailing one,
+// so that routes can be appended to it
+func normalizeBasePath(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return ""
+	}
+	return "/" + path
+}
+
+func configEnvName(name string) string {
+	return configEnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// readConfigValues collects the settings of a config file. Each value is
+// passed on exactly as written, so 1e6 or 0x10 reach the flag as they are
+// rather than as YAML read them.
+func readConfigValues(document *yaml.Node, flags *flag.FlagSet, configFlag string, values map[string]string) error {
+	// an empty file has no content at all
+	if len(document.Content) == 0 {
+		return nil
+	}
+	mapping := document.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected settings keyed by flag name", mapping.Line)
+	}
+
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key := mapping.Content[i]
+		value := mapping.Content[i+1]
+		if value.Kind == yaml.AliasNode {
+			value = value.Alias
+		}
+		name := key.Value
+		if name == configFlag || flags.Lookup(name) == nil {
+			return fmt.Errorf("line %d: unknown setting %q", key.Line, name)
+		}
+		if _, ok := values[name]; ok {
+			return fmt.Errorf("line %d: %s is set more than once", key.Line, name)
+		}
+		if value.Kind != yaml.ScalarNode || value.ShortTag() == "!!null" {
+			return fmt.Errorf("line %d: %s must be a single value", value.Line, name)
+		}
+		values[name] = value.Value
+	}
+	return nil
+}
+
+// durationValue is a time.Duration flag that also takes a bare number of
+// seconds, so drain-timeout: 30 means thirty seconds
+type durationValue time.Duration
+
+func (d *durationValue) Set(s string) error {
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err == nil && !math.IsNaN(seconds) && !math.IsInf(seconds, 0) {
+		*d = durationValue(seconds * float64(time.Second))
+		return nil
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = durationValue(duration)
+	return nil
+}
+
+func (d *durationValue) String() string {
+	return time.Duration(*d).String()
+}
+
+// addDurationFlag is flags.Duration for a durationValue
+func addDurationFlag(flags *flag.FlagSet, name string, value time.Duration, usage string) *time.Duration {
+	duration := new(time.Duration)
+	*duration = value
+	flags.Var((*durationValue)(duration), name, usage)
+	return duration
+}
+
+// loadFlagConfig fills in the flags that were not given on the command line,
+// first from the environment and then from the config file named by
+// configFlag. It runs after flags.Parse.
+func loadFlagConfig(flags *flag.FlagSet, configFlag string) error {
+	given := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	values := map[string]string{}
+	sources := map[string]string{}
+	configPath := flags.Lookup(configFlag).Value.String()
+	if !given[configFlag] {
+		configPath = os.Getenv(configEnvName(configFlag))
+	}
+	if configPath != "" {
+		byteValue, err := os.ReadFile(configPath)
+		if err != nil {
+			return err
+		}
+		var document yaml.Node
+		err = yaml.Unmarshal(byteValue, &document)
+		if err != nil {
+			return fmt.Errorf("%s: %w", configPath, err)
+		}
+		err = readConfigValues(&document, flags, configFlag, values)
+		if err != nil {
+			return fmt.Errorf("%s: %w", configPath, err)
+		}
+		for name := range values {
+			sources[name] = configPath
+		}
+	}
+
+	flags.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(configEnvName(f.Name))
+		if ok && f.Name != configFlag {
+			values[f.Name] = value
+			sources[f.Name] = configEnvName(f.Name)
+		}
+	})
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i := 0; i < len(names); i++ {
+		if given[names[i]] {
+			continue
+		}
+		err := flags.Set(names[i], values[names[i]])
+		if err != nil {
+			return fmt.Errorf("%s: invalid value %q for %s: %v", sources[names[i]], values[names[i]], names[i], err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestFlagSet parses args into a flag set with config, listen, base-path
+// and cache-size flags, then fills it in with loadFlagConfig
+func newTestFlagSet(args []string) (*flag.FlagSet, error) {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	addServerFlags(flags)
+	flags.Int("cache-size", 128, "")
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	return flags, loadFlagConfig(flags, "config")
+}
+
+func TestLoadFlagConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "amphora.yaml")
+	err := os.WriteFile(configPath, []byte("listen: file:1\nbase-path: /file\ncache-size: 16\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		// listen, base-path and cache-size
+		want []string
+	}{
+		{name: "defaults", want: []string{"localhost:8080", "", "128"}},
+		{name: "file", args: []string{"-config", configPath}, want: []string{"file:1", "/file", "16"}},
+		{name: "config file named by the environment", env: map[string]string{"AMPHORA_CONFIG": configPath}, want: []string{"file:1", "/file", "16"}},
+		{name: "environment over file", args: []string{"-config", configPath}, env: map[string]string{"AMPHORA_LISTEN": "env:2", "AMPHORA_CACHE_SIZE": "32"}, want: []string{"env:2", "/file", "32"}},
+		{name: "flag over environment and file", args: []string{"-config", configPath, "-listen", "flag:3"}, env: map[string]string{"AMPHORA_LISTEN": "env:2"}, want: []string{"flag:3", "/file", "16"}},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			flags, err := newTestFlagSet(test.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{flags.Lookup("listen").Value.String(), flags.Lookup("base-path").Value.String(), flags.Lookup("cache-size").Value.String()}
+			if strings.Join(got, ",") != strings.Join(test.want, ",") {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadFlagConfigKeepsValues(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "amphora.yaml")
+	err := os.WriteFile(configPath, []byte("base-path: 1e6\ncache-size: 0x10\ndrain-timeout: 30\nlisten: &address host:1\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags, err := newTestFlagSet([]string{"-config", configPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"base-path":     "1e6",
+		"cache-size":    "16",
+		"drain-timeout": "30s",
+		"listen":        "host:1",
+	}
+	for name, value := range want {
+		got := flags.Lookup(name).Value.String()
+		if got != value {
+			t.Errorf("%s: got %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestDurationValue(t *testing.T) {
+	values := map[string]time.Duration{
+		"30":    30 * time.Second,
+		"1.5":   1500 * time.Millisecond,
+		"0":     0,
+		"2m":    2 * time.Minute,
+		"250ms": 250 * time.Millisecond,
+	}
+	for value, want := range values {
+		var got durationValue
+		err := got.Set(value)
+		if err != nil || time.Duration(got) != want {
+			t.Errorf("Set(%q) = %v, %v, want %v", value, time.Duration(got), err, want)
+		}
+	}
+
+	invalid := []string{"", "soon", "NaN", "Inf"}
+	for i := 0; i < len(invalid); i++ {
+		var got durationValue
+		if got.Set(invalid[i]) == nil {
+			t.Errorf("Set(%q) took %v", invalid[i], time.Duration(got))
+		}
+	}
+}
+
+func TestLoadFlagConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"unknown setting":  "colour: red\n",
+		"nested value":     "listen:\n  host: localhost\n",
+		"invalid value":    "cache-size: many\n",
+		"config in config": "config: other.yaml\n",
+		"repeated setting": "listen: a:1\nlisten: b:2\n",
+		"empty value":      "listen:\n",
+		"list of settings": "- listen: a:1\n",
+	}
+	for name, contents := range files {
+		configPath := filepath.Join(dir, strings.ReplaceAll(name, " ", "-")+".yaml")
+		err := os.WriteFile(configPath, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = newTestFlagSet([]string{"-config", configPath})
+		if err == nil || !strings.Contains(err.Error(), configPath) {
+			t.Errorf("%s: got %v, want an error naming the file", name, err)
+		}
+	}
+
+	t.Setenv("AMPHORA_CACHE_SIZE", "many")
+	_, err := newTestFlagSet(nil)
+	if err == nil || !strings.Contains(err.Error(), "AMPHORA_CACHE_SIZE") {
+		t.Errorf("got %v, want an error naming the variable", err)
+	}
+}
+
+func TestNormalizeBasePath(t *testing.T) {
+	paths := map[string]string{
+		"":          "",
+		"/":         "",
+		"amphora":   "/amphora",
+		"/amphora/": "/amphora",
+		"/a/b":      "/a/b",
+	}
+	for path, want := range paths {
+		got := normalizeBasePath(path)
+		if got != want {
+			t.Errorf("normalizeBasePath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// surfaces in the order they are written, numbered as in the PLY surface
+// property. The speaker points are the ray origins and only exist when the
+// simulation recorded its rays.
+var exportSurfaces = []string{"phone", "paraboloid", "user", "speaker"}
+
+// colors match the WebGL viewport
+var exportColors = [][3]uint8{{255, 0, 0}, {0, 255, 0}, {0, 0, 255}, {255, 255, 255}}
+
+type exportFormat struct {
+	ContentType string
+	Write       func(*exportScene, io.Writer) error
+}
+
+var exportFormats = map[string]exportFormat{
+	"ply": {ContentType: "application/octet-stream", Write: writePly},
+	"obj": {ContentType: "text/plain; charset=utf-8", Write: writeObj},
+	"csv": {ContentType: "text/csv; charset=utf-8", Write: writeCsv},
+	"glb": {ContentType: "model/gltf-binary", Write: writeGlb},
+}
+
+type exportPoint struct {
+	Surface int
+	Ray     int
+	Bounce  int
+	X, Y, Z float64
+}
+
+// exportScene flattens a SimulationOutput into points and, when rays were
+// recorded, the path of every ray as indices into the points. Phone and Input
+// describe the geometry for formats that draw the surfaces themselves.
+type exportScene struct {
+	Phone       *PhoneConfig
+	Input       *SimulationInput
+	Coordinates OutputCoordinates
+	Points      []exportPoint
+	Paths       [][]int
+}
+
+func newExportScene(phoneConfig *PhoneConfig, simulationInput *SimulationInput, output *SimulationOutput) *exportScene {
+	var scene exportScene
+	scene.Phone = phoneConfig
+	scene.Input = simulationInput
+	// runs saved before outputs declared their coordinates are in world metres
+	scene.Coordinates = OutputCoordinates{Units: defaultOutputInput.Units, Frame: defaultOutputInput.Frame}
+	if output.Coordinates != nil {
+		scene.Coordinates = *output.Coordinates
+	}
+	rays := output.Rays
+	sets := [][]float64{output.Phone, output.Paraboloid, output.User}
+	var rayIds, bounceIds [][]int
+	if rays != nil {
+		sets = append(sets, rays.Origins)
+		rayIds = [][]int{rays.PhoneRays, rays.ParaboloidRays, rays.UserRays, nil}
+		bounceIds = [][]int{rays.PhoneBounces, rays.ParaboloidBounces, rays.UserBounces, nil}
+	}
+
+	for surface := 0; surface < len(sets); surface++ {
+		for i := 0; i+2 < len(sets[surface]); i += 3 {
+			var point exportPoint
+			point.Surface = surface
+			point.Ray = -1
+			point.Bounce = -1
+			point.X = sets[surface][i]
+			point.Y = sets[surface][i+1]
+			point.Z = sets[surface][i+2]
+			if rays != nil {
+				if rayIds[surface] == nil {
+					point.Ray = i / 3
+					point.Bounce = 0
+				} else if i/3 < len(rayIds[surface]) {
+					point.Ray = rayIds[surface][i/3]
+					point.Bounce = bounceIds[surface][i/3]
+				}
+			}
+			scene.Points = append(scene.Points, point)
+		}
+	}
+
+	if rays == nil {
+		return &scene
+	}
+
+	scene.Paths = make([][]int, len(rays.Origins)/3)
+	for i := 0; i < len(scene.Points); i++ {
+		ray := scene.Points[i].Ray
+		if ray >= 0 && ray < len(scene.Paths) {
+			scene.Paths[ray] = append(scene.Paths[ray], i)
+		}
+	}
+	for i := 0; i < len(scene.Paths); i++ {
+		path := scene.Paths[i]
+		sort.SliceStable(path, func(a, b int) bool {
+			return scene.Points[path[a]].Bounce < scene.Points[path[b]].Bounce
+		})
+	}
+
+	return &scene
+}
+
+func (scene *exportScene) edgeCount() int {
+	count := 0
+	for i := 0; i < len(scene.Paths); i++ {
+		if len(scene.Paths[i]) > 1 {
+			count += len(scene.Paths[i]) - 1
+		}
+	}
+	return count
+}
+
+// writePly writes a binary little endian PLY with one vertex per hit and one
+// edge per ray segment
+func writePly(scene *exportScene, w io.Writer) error {
+	buffered := bufio.NewWriter(w)
+
+	fmt.Fprintf(buffered, "ply\nformat binary_little_endian 1.0\n")
+	fmt.Fprintf(buffered, "comment amphora simulation export, coordinates in %s, %s frame\n", scene.Coordinates.Units, scene.Coordinates.Frame)
+	fmt.Fprintf(buffered, "comment surface 0 phone, 1 paraboloid, 2 user, 3 speaker\n")
+	fmt.Fprintf(buffered, "comment bounce and ray are -1 when the rays were not recorded\n")
+	fmt.Fprintf(buffered, "element vertex %d\n", len(scene.Points))
+	fmt.Fprintf(buffered, "property float x\nproperty float y\nproperty float z\n")
+	fmt.Fprintf(buffered, "property uchar surface\nproperty int bounce\nproperty int ray\n")
+	fmt.Fprintf(buffered, "property uchar red\nproperty uchar green\nproperty uchar blue\n")
+	fmt.Fprintf(buffered, "element edge %d\n", scene.edgeCount())
+	fmt.Fprintf(buffered, "property int vertex1\nproperty int vertex2\n")
+	fmt.Fprintf(buffered, "end_header\n")
+
+	vertex := make([]byte, 24)
+	for i := 0; i < len(scene.Points); i++ {
+		point := scene.Points[i]
+		binary.LittleEndian.PutUint32(vertex[0:], math.Float32bits(float32(point.X)))
+		binary.LittleEndian.PutUint32(vertex[4:], math.Float32bits(float32(point.Y)))
+		binary.LittleEndian.PutUint32(vertex[8:], math.Float32bits(float32(point.Z)))
+		vertex[12] = uint8(point.Surface)
+		binary.LittleEndian.PutUint32(vertex[13:], uint32(int32(point.Bounce)))
+		binary.LittleEndian.PutUint32(vertex[17:], uint32(int32(point.Ray)))
+		vertex[21] = exportColors[point.Surface][0]
+		vertex[22] = exportColors[point.Surface][1]
+		vertex[23] = exportColors[point.Surface][2]
+		buffered.Write(vertex)
+	}
+
+	edge := make([]byte, 8)
+	for i := 0; i < len(scene.Paths); i++ {
+		path := scene.Paths[i]
+		for j := 1; j < len(path); j++ {
+			binary.LittleEndian.PutUint32(edge[0:], uint32(path[j-1]))
+			binary.LittleEndian.PutUint32(edge[4:], uint32(path[j]))
+			buffered.Write(edge)
+		}
+	}
+
+	return buffered.Flush()
+}
+
+// writeObj writes each surface as a group of points and the ray paths as
+// polylines in a separate rays group
+func writeObj(scene *exportScene, w io.Writer) error {
+	buffered := bufio.NewWriter(w)
+	fmt.Fprintf(buffered, "# amphora simulation export, coordinates in %s, %s frame\n", scene.Coordinates.Units, scene.Coordinates.Frame)
+
+	for i := 0; i < len(scene.Points); i++ {
+		fmt.Fprintf(buffered, "v %g %g %g\n", scene.Points[i].X, scene.Points[i].Y, scene.Points[i].Z)
+	}
+
+	// point elements reference the vertices written above, OBJ indices are 1 based
+	for i := 0; i < len(scene.Points); i++ {
+		if i == 0 || scene.Points[i].Surface != scene.Points[i-1].Surface {
+			fmt.Fprintf(buffered, "g %s\n", exportSurfaces[scene.Points[i].Surface])
+		}
+		fmt.Fprintf(buffered, "p %d\n", i+1)
+	}
+
+	if len(scene.Paths) > 0 {
+		fmt.Fprintf(buffered, "g rays\n")
+	}
+	for i := 0; i < len(scene.Paths); i++ {
+		path := scene.Paths[i]
+		if len(path) < 2 {
+			continue
+		}
+		fmt.Fprintf(buffered, "l")
+		for j := 0; j < len(path); j++ {
+			fmt.Fprintf(buffered, " %d", path[j]+1)
+		}
+		fmt.Fprintf(buffered, "\n")
+	}
+
+	return buffered.Flush()
+}
+
+func writeCsv(scene *exportScene, w io.Writer) error {
+	buffered := bufio.NewWriter(w)
+	fmt.Fprintf(buffered, "surface,ray,bounce,x,y,z\n")
+	for i := 0; i < len(scene.Points); i++ {
+		point := scene.Points[i]
+		fmt.Fprintf(buffered, "%s,%d,%d,%g,%g,%g\n", exportSurfaces[point.Surface], point.Ray, point.Bounce, point.X, point.Y, point.Z)
+	}
+	return buffered.Flush()
+}
+
+func writeExport(format string, phoneConfig *PhoneConfig, simulationInput *SimulationInput, output *SimulationOutput, w io.Writer) error {
+	exporter, ok := exportFormats[format]
+	if !ok {
+		return fmt.Errorf("unknown export format %q", format)
+	}
+	return exporter.Write(newExportScene(phoneConfig, simulationInput, output), w)
+}
+
+// exportFormatFromPath infers the format from an output file extension,
+// falling back to json
+func exportFormatFromPath(path string) string {
+	extension := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	if _, ok := exportFormats[extension]; ok {
+		return extension
+	}
+	return "json"
+}
+
+func writeExportOutput(path string, format string, phoneConfig *PhoneConfig, simulationInput *SimulationInput, output *SimulationOutput) error {
+	if path == "" || path == "-" {
+		return writeExport(format, phoneConfig, simulationInput, output, os.Stdout)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = writeExport(format, phoneConfig, simulationInput, output, f)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func sendExport(c *gin.Context, format string, name string, phoneConfig *PhoneConfig, simulationInput *SimulationInput, output *SimulationOutput) {
+	c.Header("Content-Type", exportFormats[format].ContentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+format))
+	c.Status(http.StatusOK)
+	err := writeExport(format, phoneConfig, simulationInput, output, c.Writer)
+	if err != nil {
+		c.Error(err)
+	}
+}
+
+func validateExportFormat(validationError *ValidationError, format string) bool {
+	if _, ok := exportFormats[format]; !ok {
+		validationError.Add("format", "must be one of ply, obj, csv, glb")
+		return false
+	}
+	return true
+}
+
+func HandleApiExport(c *gin.Context) {
+	validationError := &ValidationError{}
+	format := c.Param("format")
+	validateExportFormat(validationError, format)
+
+	var simulationInput SimulationInput
+	err := c.ShouldBindJSON(&simulationInput)
+	if err != nil {
+		validationError.Add("body", "malformed request body: %v", err)
+	}
+	if !validationError.Empty() {
+		abortWithValidationError(c, validationError)
+		return
+	}
+
+	simulationInput.RecordRays = true
+	phoneConfig, validationError := prepareSimulation(&simulationInput)
+	if validationError != nil {
+		abortWithValidationError(c, validationError)
+		return
+	}
+
+	output, _, err := runSimulation(phoneConfig, &simulationInput)
+	if err != nil {
+		abortWithSimulationError(c, err)
+		return
+	}
+
+	sendExport(c, format, "simulation", phoneConfig, &simulationInput, output)
+}
+
+// HandleApiExportRun exports a stored run. Runs saved without their ray
+// records are traced again with recording switched on.
+func HandleApiExportRun(c *gin.Context) {
+	validationError := &ValidationError{}
+	format := c.Param("format")
+	if !validateExportFormat(validationError, format) {
+		abortWithValidationError(c, validationError)
+		return
+	}
+
+	record, err := runStore.Get(c.Param("id"))
+	if errors.Is(err, ErrRunNotFound) {
+		c.AbortWithError(http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	var output *SimulationOutput
+	if record.HasOutput {
+		output, err = runStore.GetOutput(record.Id)
+	}
+	if err == nil && (output == nil || output.Rays == nil) {
+		simulationInput := record.Input
+		simulationInput.RecordRays = true
+		output, _, err = runSimulation(&record.Phone, &simulationInput)
+	}
+	// the output may have been pruned since the record was read
+	if errors.Is(err, ErrRunNotFound) {
+		c.AbortWithError(http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		abortWithSimulationError(c, err)
+		return
+	}
+
+	sendExport(c, format, record.Id, &record.Phone, &record.Input, output)
+}
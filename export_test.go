@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testExportOutput holds two rays: ray 0 bounces off the phone and the
+// paraboloid before reaching the user, ray 1 goes straight to the user
+func testExportOutput() *SimulationOutput {
+	return &SimulationOutput{
+		Phone:      []float64{0, 0, 1},
+		Paraboloid: []float64{0, 1, 1},
+		User:       []float64{0, 1, 2, 1, 0, 2},
+		Rays: &RayRecord{
+			Origins:           []float64{0, 0, 0, 1, 0, 0},
+			PhoneRays:         []int{0},
+			PhoneBounces:      []int{1},
+			ParaboloidRays:    []int{0},
+			ParaboloidBounces: []int{2},
+			UserRays:          []int{0, 1},
+			UserBounces:       []int{3, 1},
+		},
+	}
+}
+
+func TestRecordRays(t *testing.T) {
+	simulationInput := testSimulationInput()
+	simulationInput.Resolution = ResolutionInput{Linear: 2, Angular: 0.5}
+	output, err := generateSimulation(testPhoneConfig(), normalizedInput(&simulationInput), simulationOptions{withParaboloid: true, recordRays: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rays := output.Rays
+	if rays == nil {
+		t.Fatal("no rays recorded")
+	}
+	if len(rays.Origins) != 3*output.Metrics.RaysEmitted {
+		t.Errorf("got %d origin coordinates for %d rays", len(rays.Origins), output.Metrics.RaysEmitted)
+	}
+	if len(rays.PhoneRays) != len(output.Phone)/3 || len(rays.ParaboloidRays) != len(output.Paraboloid)/3 || len(rays.UserRays) != len(output.User)/3 {
+		t.Fatalf("got %d, %d and %d ray ids for %d, %d and %d hits", len(rays.PhoneRays), len(rays.ParaboloidRays), len(rays.UserRays), len(output.Phone)/3, len(output.Paraboloid)/3, len(output.User)/3)
+	}
+
+	// every ray reaches the user at most once, as its last bounce
+	lastBounce := map[int]int{}
+	hits := [][]int{rays.PhoneRays, rays.ParaboloidRays}
+	bounces := [][]int{rays.PhoneBounces, rays.ParaboloidBounces}
+	for i := 0; i < len(hits); i++ {
+		for j := 0; j < len(hits[i]); j++ {
+			lastBounce[hits[i][j]] = max(lastBounce[hits[i][j]], bounces[i][j])
+		}
+	}
+	seen := map[int]bool{}
+	for i := 0; i < len(rays.UserRays); i++ {
+		ray := rays.UserRays[i]
+		if seen[ray] {
+			t.Errorf("ray %d reaches the user twice", ray)
+		}
+		seen[ray] = true
+		if rays.UserBounces[i] != lastBounce[ray]+1 {
+			t.Errorf("ray %d reaches the user at bounce %d after %d surface hits", ray, rays.UserBounces[i], lastBounce[ray])
+		}
+	}
+
+	plain, err := generateSimulation(testPhoneConfig(), normalizedInput(&simulationInput), simulationOptions{withParaboloid: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain.Rays != nil || len(plain.User) != len(output.User) {
+		t.Error("recording rays changed the simulation")
+	}
+}
+
+func TestNewExportScene(t *testing.T) {
+	scene := newExportScene(nil, nil, testExportOutput())
+	if len(scene.Points) != 6 {
+		t.Fatalf("got %d points, want 6", len(scene.Points))
+	}
+	// points are phone, paraboloid, user, user, speaker, speaker
+	wantPaths := [][]int{{4, 0, 1, 2}, {5, 3}}
+	if len(scene.Paths) != len(wantPaths) {
+		t.Fatalf("got paths %v, want %v", scene.Paths, wantPaths)
+	}
+	for i := 0; i < len(wantPaths); i++ {
+		if len(scene.Paths[i]) != len(wantPaths[i]) {
+			t.Fatalf("got paths %v, want %v", scene.Paths, wantPaths)
+		}
+		for j := 0; j < len(wantPaths[i]); j++ {
+			if scene.Paths[i][j] != wantPaths[i][j] {
+				t.Fatalf("got paths %v, want %v", scene.Paths, wantPaths)
+			}
+		}
+	}
+	if scene.edgeCount() != 4 {
+		t.Errorf("got %d edges, want 4", scene.edgeCount())
+	}
+
+	unrecorded := testExportOutput()
+	unrecorded.Rays = nil
+	scene = newExportScene(nil, nil, unrecorded)
+	if len(scene.Points) != 4 || scene.Paths != nil || scene.Points[0].Ray != -1 || scene.Points[0].Bounce != -1 {
+		t.Errorf("got %+v without recorded rays", scene)
+	}
+}
+
+func TestWriteExport(t *testing.T) {
+	output := testExportOutput()
+
+	var csv bytes.Buffer
+	err := writeExport("csv", nil, nil, output, &csv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCsv := "surface,ray,bounce,x,y,z\n" +
+		"phone,0,1,0,0,1\n" +
+		"paraboloid,0,2,0,1,1\n" +
+		"user,0,3,0,1,2\n" +
+		"user,1,1,1,0,2\n" +
+		"speaker,0,0,0,0,0\n" +
+		"speaker,1,0,1,0,0\n"
+	if csv.String() != wantCsv {
+		t.Errorf("got CSV\n%s\nwant\n%s", csv.String(), wantCsv)
+	}
+
+	var obj bytes.Buffer
+	err = writeExport("obj", nil, nil, output, &obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(obj.String(), "\nv ") != 6 || !strings.Contains(obj.String(), "g rays\nl 5 1 2 3\nl 6 4\n") {
+		t.Errorf("got OBJ\n%s", obj.String())
+	}
+
+	var ply bytes.Buffer
+	err = writeExport("ply", nil, nil, output, &ply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, body, ok := strings.Cut(ply.String(), "end_header\n")
+	if !ok || !strings.Contains(header, "element vertex 6\n") || !strings.Contains(header, "element edge 4\n") {
+		t.Fatalf("got PLY header\n%s", header)
+	}
+	if len(body) != 6*24+4*8 {
+		t.Errorf("got %d bytes of PLY data, want %d", len(body), 6*24+4*8)
+	}
+
+	err = writeExport("stl", nil, nil, output, &ply)
+	if err == nil {
+		t.Error("an unknown format was accepted")
+	}
+}
+
+func TestExportFormatFromPath(t *testing.T) {
+	paths := map[string]string{
+		"out.ply":      "ply",
+		"OUT.OBJ":      "obj",
+		"dir.v2/a.csv": "csv",
+		"scene.glb":    "glb",
+		"out.json":     "json",
+		"out":          "json",
+		"-":            "json",
+	}
+	for path, want := range paths {
+		got := exportFormatFromPath(path)
+		if got != want {
+			t.Errorf("exportFormatFromPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestHandleApiExportRun(t *testing.T) {
+	var err error
+	runStore, err = NewRunStore(t.TempDir(), true, RunRetention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { runStore = nil }()
+	r := newTestRouter()
+
+	record := &RunRecord{CreatedAt: time.Now()}
+	err = runStore.Save(record, testExportOutput())
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := serveTestRequest(r, http.MethodGet, apiVersionPath+"/runs/"+record.Id+"/export/csv", "")
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Body.String(), "surface,ray,bounce,x,y,z\n") {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	// a record whose output has gone is not found rather than a server error
+	err = os.Remove(runStore.outputPath(record.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder = serveTestRequest(r, http.MethodGet, apiVersionPath+"/runs/"+record.Id+"/export/csv", "")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("run without its output: got status %d, want 404", recorder.Code)
+	}
+	recorder = serveTestRequest(r, http.MethodGet, apiVersionPath+"/runs/20260102T030000Z-00000000/export/csv", "")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("unknown run: got status %d, want 404", recorder.Code)
+	}
+}
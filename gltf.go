@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+)
+
+const (
+	gltfFloat        = 5126
+	gltfUnsignedInt  = 5125
+	gltfArrayBuffer  = 34962
+	gltfElementArray = 34963
+	gltfPoints       = 0
+	gltfLines        = 1
+	gltfTriangles    = 4
+)
+
+// tessellation of the paraboloid and listener sphere meshes
+const (
+	gltfParaboloidRings    = 32
+	gltfParaboloidSegments = 72
+	gltfSphereRings        = 24
+	gltfSphereSegments     = 48
+)
+
+// gltfBuilder packs accessors into a single binary buffer and collects the
+// JSON document that describes them
+type gltfBuilder struct {
+	buffer      bytes.Buffer
+	bufferViews []map[string]any
+	accessors   []map[string]any
+	materials   []map[string]any
+	meshes      []map[string]any
+	nodes       []int
+}
+
+func (builder *gltfBuilder) addBufferView(data []byte, target int) int {
+	for builder.buffer.Len()%4 != 0 {
+		builder.buffer.WriteByte(0)
+	}
+	builder.bufferViews = append(builder.bufferViews, map[string]any{
+		"buffer":     0,
+		"byteOffset": builder.buffer.Len(),
+		"byteLength": len(data),
+		"target":     target,
+	})
+	builder.buffer.Write(data)
+	return len(builder.bufferViews) - 1
+}
+
+// addPositions stores xyz triples, in metres, as a VEC3 float accessor
+func (builder *gltfBuilder) addPositions(positions []float64) int {
+	data := make([]byte, 4*len(positions))
+	minimum := []float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	maximum := []float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for i := 0; i < len(positions); i++ {
+		value := float32(positions[i])
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(value))
+		minimum[i%3] = math.Min(minimum[i%3], float64(value))
+		maximum[i%3] = math.Max(maximum[i%3], float64(value))
+	}
+
+	builder.accessors = append(builder.accessors, map[string]any{
+		"bufferView":    builder.addBufferView(data, gltfArrayBuffer),
+		"componentType": gltfFloat,
+		"count":         len(positions) / 3,
+		"type":          "VEC3",
+		"min":           minimum,
+		"max":           maximum,
+	})
+	return len(builder.accessors) - 1
+}
+
+func (builder *gltfBuilder) addIndices(indices []int) int {
+	data := make([]byte, 4*len(indices))
+	for i := 0; i < len(indices); i++ {
+		binary.LittleEndian.PutUint32(data[4*i:], uint32(indices[i]))
+	}
+
+	builder.accessors = append(builder.accessors, map[string]any{
+		"bufferView":    builder.addBufferView(data, gltfElementArray),
+		"componentType": gltfUnsignedInt,
+		"count":         len(indices),
+		"type":          "SCALAR",
+	})
+	return len(builder.accessors) - 1
+}
+
+func (builder *gltfBuilder) addMaterial(name string, color [4]float64) int {
+	material := map[string]any{
+		"name": name,
+		"pbrMetallicRoughness": map[string]any{
+			"baseColorFactor": color,
+			"metallicFactor":  0,
+			"roughnessFactor": 1,
+		},
+		"doubleSided": true,
+	}
+	if color[3] < 1 {
+		material["alphaMode"] = "BLEND"
+	}
+	builder.materials = append(builder.materials, material)
+	return len(builder.materials) - 1
+}
+
+// addMesh adds a mesh with a single primitive and a node placing it in the
+// scene. Empty geometry is skipped before anything is written to the buffer,
+// since glTF forbids zero length accessors and nothing may refer to an unused
+// one.
+func (builder *gltfBuilder) addMesh(name string, mode int, material int, positions []float64, indices []int) {
+	if len(positions) == 0 || (indices != nil && len(indices) == 0) {
+		return
+	}
+
+	primitive := map[string]any{
+		"attributes": map[string]any{"POSITION": builder.addPositions(positions)},
+		"mode":       mode,
+		"material":   material,
+	}
+	if indices != nil {
+		primitive["indices"] = builder.addIndices(indices)
+	}
+
+	builder.meshes = append(builder.meshes, map[string]any{
+		"name":       name,
+		"primitives": []any{primitive},
+	})
+	builder.nodes = append(builder.nodes, len(builder.meshes)-1)
+}
+
+// writeGlb writes the binary container: a 12 byte header followed by the JSON
+// and BIN chunks, each padded to a multiple of four bytes
+func (builder *gltfBuilder) writeGlb(w io.Writer) error {
+	for builder.buffer.Len()%4 != 0 {
+		builder.buffer.WriteByte(0)
+	}
+
+	nodes := make([]map[string]any, len(builder.nodes))
+	sceneNodes := make([]int, len(builder.nodes))
+	for i := 0; i < len(builder.nodes); i++ {
+		nodes[i] = map[string]any{
+			"name": builder.meshes[builder.nodes[i]]["name"],
+			"mesh": builder.nodes[i],
+		}
+		sceneNodes[i] = i
+	}
+
+	document := map[string]any{
+		"asset":       map[string]any{"version": "2.0", "generator": "amphora"},
+		"scene":       0,
+		"scenes":      []any{map[string]any{"name": "simulation", "nodes": sceneNodes}},
+		"nodes":       nodes,
+		"meshes":      builder.meshes,
+		"materials":   builder.materials,
+		"accessors":   builder.accessors,
+		"bufferViews": builder.bufferViews,
+		"buffers":     []any{map[string]any{"byteLength": builder.buffer.Len()}},
+	}
+	jsonChunk, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	for len(jsonChunk)%4 != 0 {
+		jsonChunk = append(jsonChunk, ' ')
+	}
+
+	header := make([]byte, 20)
+	binary.LittleEndian.PutUint32(header[0:], 0x46546c67)
+	binary.LittleEndian.PutUint32(header[4:], 2)
+	binary.LittleEndian.PutUint32(header[8:], uint32(12+8+len(jsonChunk)+8+builder.buffer.Len()))
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(jsonChunk)))
+	binary.LittleEndian.PutUint32(header[16:], 0x4e4f534a)
+	_, err = w.Write(header)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(jsonChunk)
+	if err != nil {
+		return err
+	}
+
+	binHeader := make([]byte, 8)
+	binary.LittleEndian.PutUint32(binHeader[0:], uint32(builder.buffer.Len()))
+	binary.LittleEndian.PutUint32(binHeader[4:], 0x004e4942)
+	_, err = w.Write(binHeader)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(builder.buffer.Bytes())
+	return err
+}
+
+// phoneBoxMesh returns the eight corners of the phone body, in world mm, and
+// the triangles of its six faces. The box includes the case but leaves the
+// corners square.
+func phoneBoxMesh(phoneConfig *PhoneConfig, normalized NormalizedSimulationInput) ([]float64, []int) {
+	phoneConfig = phoneConfig.body()
+	placement := placePhone(phoneConfig, normalized)
+
+	positions := make([]float64, 0, 24)
+	for i := 0; i < 8; i++ {
+		width := float64(i&1) * phoneConfig.Width
+		length := float64(i>>1&1) * phoneConfig.Length
+		height := float64(i>>2&1) * phoneConfig.Height
+		for j := 0; j < 3; j++ {
+			positions = append(positions, placement.Corner[j]-width*placement.Width[j]+length*placement.Length[j]+height*placement.Height[j])
+		}
+	}
+
+	indices := []int{
+		0, 1, 3, 0, 3, 2,
+		4, 6, 7, 4, 7, 5,
+		0, 4, 5, 0, 5, 1,
+		2, 3, 7, 2, 7, 6,
+		0, 2, 6, 0, 6, 4,
+		1, 5, 7, 1, 7, 3,
+	}
+	return positions, indices
+}
+
+// paraboloidMesh tessellates the capsule out to where the slicing plane trims
+// it. The surface is parametrised in the paraboloid frame as x = ρcosφ/√X,
+// y = ρsinφ/√Y, z = ρ²/Z, with ρ running from the apex to the trim line.
+// Positions are in world mm.
+func paraboloidMesh(simulationInput *SimulationInput) ([]float64, []int) {
+	normalized := normalizedInput(simulationInput)
+	frame := paraboloidFrame(normalized.ParaboloidAngle)
+	coefficients := []float64{normalized.ParaboloidX, normalized.ParaboloidY, normalized.ParaboloidZ}
+
+	// the slicing plane keeps the points p with normal·p + offset >= 0
+	angle := (normalized.ParaboloidAngle + normalized.SlicingPlaneAngle).Radians()
+	normal := []float64{0, math.Sin(angle), -math.Cos(angle)}
+	offset := normalized.SlicingPlaneHeight.Millimetres() * math.Cos(normalized.SlicingPlaneAngle.Radians())
+	if offset <= 0 {
+		return nil, nil
+	}
+
+	// directions that never meet the plane stop at the listener sphere height
+	limit := math.Sqrt(coefficients[2] * normalized.UserRadius.Millimetres())
+
+	a := (normal[0]*frame[2][0] + normal[1]*frame[2][1] + normal[2]*frame[2][2]) / coefficients[2]
+	positions := []float64{0, 0, 0}
+	for j := 0; j < gltfParaboloidSegments; j++ {
+		phi := 2 * math.Pi * float64(j) / gltfParaboloidSegments
+		radial := []float64{0, 0, 0}
+		for k := 0; k < 3; k++ {
+			radial[k] = math.Cos(phi)/math.Sqrt(coefficients[0])*frame[0][k] + math.Sin(phi)/math.Sqrt(coefficients[1])*frame[1][k]
+		}
+		b := normal[0]*radial[0] + normal[1]*radial[1] + normal[2]*radial[2]
+
+		rho := smallestPositiveRoot(a, b, offset)
+		if rho <= 0 || rho > limit {
+			rho = limit
+		}
+
+		for i := 1; i <= gltfParaboloidRings; i++ {
+			r := rho * float64(i) / gltfParaboloidRings
+			for k := 0; k < 3; k++ {
+				positions = append(positions, r*radial[k]+r*r/coefficients[2]*frame[2][k])
+			}
+		}
+	}
+
+	// vertex 0 is the apex, then one run of rings per segment
+	vertex := func(segment int, ring int) int {
+		return 1 + (segment%gltfParaboloidSegments)*gltfParaboloidRings + ring - 1
+	}
+	indices := []int{}
+	for j := 0; j < gltfParaboloidSegments; j++ {
+		indices = append(indices, 0, vertex(j, 1), vertex(j+1, 1))
+		for i := 1; i < gltfParaboloidRings; i++ {
+			indices = append(indices, vertex(j, i), vertex(j, i+1), vertex(j+1, i+1))
+			indices = append(indices, vertex(j, i), vertex(j+1, i+1), vertex(j+1, i))
+		}
+	}
+	return positions, indices
+}
+
+// smallestPositiveRoot of aρ² + bρ + c, or -1 when there is none
+func smallestPositiveRoot(a float64, b float64, c float64) float64 {
+	if a == 0 {
+		if b == 0 || -c/b <= 0 {
+			return -1
+		}
+		return -c / b
+	}
+
+	discriminant := b*b - 4*a*c
+	if discriminant < 0 {
+		return -1
+	}
+	roots := []float64{(-b - math.Sqrt(discriminant)) / (2 * a), (-b + math.Sqrt(discriminant)) / (2 * a)}
+	smallest := -1.0
+	for i := 0; i < len(roots); i++ {
+		if roots[i] > 0 && (smallest < 0 || roots[i] < smallest) {
+			smallest = roots[i]
+		}
+	}
+	return smallest
+}
+
+// sphereMesh is a latitude/longitude sphere around the origin
+func sphereMesh(radius float64) ([]float64, []int) {
+	positions := []float64{}
+	for i := 0; i <= gltfSphereRings; i++ {
+		theta := math.Pi * float64(i) / gltfSphereRings
+		for j := 0; j <= gltfSphereSegments; j++ {
+			phi := 2 * math.Pi * float64(j) / gltfSphereSegments
+			positions = append(positions, radius*math.Sin(theta)*math.Cos(phi), radius*math.Sin(theta)*math.Sin(phi), radius*math.Cos(theta))
+		}
+	}
+
+	indices := []int{}
+	for i := 0; i < gltfSphereRings; i++ {
+		for j := 0; j < gltfSphereSegments; j++ {
+			first := i*(gltfSphereSegments+1) + j
+			second := first + gltfSphereSegments + 1
+			indices = append(indices, first, second, first+1, second, second+1, first+1)
+		}
+	}
+	return positions, indices
+}
+
+// writeGlb writes the phone, capsule and listener sphere as meshes and the
+// hits and ray paths as points and lines, in the units and frame of the
+// points. glTF viewers expect metres, the default. Colors follow the WebGL
+// viewport.
+func writeGlb(scene *exportScene, w io.Writer) error {
+	var builder gltfBuilder
+	simulationInput := scene.Input
+	transform, err := newOutputTransform(scene.Phone, simulationInput)
+	if err != nil {
+		return err
+	}
+
+	surfaceMaterials := make([]int, len(exportSurfaces))
+	for i := 0; i < len(exportSurfaces); i++ {
+		color := exportColors[i]
+		surfaceMaterials[i] = builder.addMaterial(exportSurfaces[i]+" hits", [4]float64{float64(color[0]) / 255, float64(color[1]) / 255, float64(color[2]) / 255, 1})
+	}
+
+	positions, indices := phoneBoxMesh(scene.Phone, normalizedInput(simulationInput))
+	builder.addMesh("phone", gltfTriangles, builder.addMaterial("phone", [4]float64{1, 0, 0, 0.6}), transform.apply(positions), indices)
+
+	positions, indices = paraboloidMesh(simulationInput)
+	builder.addMesh("paraboloid", gltfTriangles, builder.addMaterial("paraboloid", [4]float64{0, 1, 0, 0.35}), transform.apply(positions), indices)
+
+	positions, indices = sphereMesh(normalizedInput(simulationInput).UserRadius.Millimetres())
+	builder.addMesh("user", gltfTriangles, builder.addMaterial("user", [4]float64{0, 0, 1, 0.1}), transform.apply(positions), indices)
+
+	// the hits of each surface become one point primitive
+	allPositions := make([]float64, 0, 3*len(scene.Points))
+	for i := 0; i < len(scene.Points); i++ {
+		allPositions = append(allPositions, scene.Points[i].X, scene.Points[i].Y, scene.Points[i].Z)
+	}
+	start := 0
+	for i := 1; i <= len(scene.Points); i++ {
+		if i == len(scene.Points) || scene.Points[i].Surface != scene.Points[start].Surface {
+			surface := scene.Points[start].Surface
+			builder.addMesh(exportSurfaces[surface]+" hits", gltfPoints, surfaceMaterials[surface], allPositions[3*start:3*i], nil)
+			start = i
+		}
+	}
+
+	if len(scene.Paths) > 0 {
+		lines := make([]int, 0, 2*scene.edgeCount())
+		for i := 0; i < len(scene.Paths); i++ {
+			path := scene.Paths[i]
+			for j := 1; j < len(path); j++ {
+				lines = append(lines, path[j-1], path[j])
+			}
+		}
+		builder.addMesh("rays", gltfLines, builder.addMaterial("rays", [4]float64{1, 1, 1, 0.2}), allPositions, lines)
+	}
+
+	return builder.writeGlb(w)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+type testGltfDocument struct {
+	Meshes []struct {
+		Name       string `json:"name"`
+		Primitives []struct {
+			Attributes map[string]int `json:"attributes"`
+			Indices    *int           `json:"indices"`
+			Mode       int            `json:"mode"`
+		} `json:"primitives"`
+	} `json:"meshes"`
+	Accessors []struct {
+		BufferView int `json:"bufferView"`
+		Count      int `json:"count"`
+	} `json:"accessors"`
+	BufferViews []struct {
+		ByteOffset int `json:"byteOffset"`
+		ByteLength int `json:"byteLength"`
+	} `json:"bufferViews"`
+	Buffers []struct {
+		ByteLength int `json:"byteLength"`
+	} `json:"buffers"`
+}
+
+// readTestGlb checks the container framing and returns the JSON document and
+// the length of the BIN chunk
+func readTestGlb(t *testing.T, data []byte) (testGltfDocument, int) {
+	t.Helper()
+	var document testGltfDocument
+	if len(data) < 20 || binary.LittleEndian.Uint32(data[0:]) != 0x46546c67 || binary.LittleEndian.Uint32(data[4:]) != 2 {
+		t.Fatal("missing glTF 2 header")
+	}
+	if int(binary.LittleEndian.Uint32(data[8:])) != len(data) {
+		t.Fatalf("header length %d, file length %d", binary.LittleEndian.Uint32(data[8:]), len(data))
+	}
+	jsonLength := int(binary.LittleEndian.Uint32(data[12:]))
+	if jsonLength%4 != 0 || binary.LittleEndian.Uint32(data[16:]) != 0x4e4f534a {
+		t.Fatalf("bad JSON chunk of %d bytes", jsonLength)
+	}
+	err := json.Unmarshal(data[20:20+jsonLength], &document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := data[20+jsonLength:]
+	binLength := int(binary.LittleEndian.Uint32(bin[0:]))
+	if binary.LittleEndian.Uint32(bin[4:]) != 0x004e4942 || binLength != len(bin)-8 || binLength%4 != 0 {
+		t.Fatalf("bad BIN chunk of %d bytes", binLength)
+	}
+	return document, binLength
+}
+
+func TestWriteGlb(t *testing.T) {
+	simulationInput := testSimulationInput()
+	var glb bytes.Buffer
+	err := writeExport("glb", testPhoneConfig(), &simulationInput, testExportOutput(), &glb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	document, binLength := readTestGlb(t, glb.Bytes())
+
+	names := []string{}
+	for i := 0; i < len(document.Meshes); i++ {
+		names = append(names, document.Meshes[i].Name)
+	}
+	wantNames := []string{"phone", "paraboloid", "user", "phone hits", "paraboloid hits", "user hits", "speaker hits", "rays"}
+	if len(names) != len(wantNames) {
+		t.Fatalf("got meshes %v, want %v", names, wantNames)
+	}
+	for i := 0; i < len(wantNames); i++ {
+		if names[i] != wantNames[i] {
+			t.Fatalf("got meshes %v, want %v", names, wantNames)
+		}
+	}
+	rays := document.Meshes[len(document.Meshes)-1].Primitives[0]
+	if rays.Mode != gltfLines || rays.Indices == nil || document.Accessors[*rays.Indices].Count != 8 {
+		t.Errorf("got ray primitive %+v", rays)
+	}
+
+	if len(document.Buffers) != 1 || document.Buffers[0].ByteLength != binLength {
+		t.Errorf("got buffers %+v for %d bytes", document.Buffers, binLength)
+	}
+	for i := 0; i < len(document.BufferViews); i++ {
+		view := document.BufferViews[i]
+		if view.ByteOffset%4 != 0 || view.ByteLength == 0 || view.ByteOffset+view.ByteLength > binLength {
+			t.Errorf("buffer view %d: %+v outside %d bytes", i, view, binLength)
+		}
+	}
+	for i := 0; i < len(document.Accessors); i++ {
+		if document.Accessors[i].Count == 0 || document.Accessors[i].BufferView >= len(document.BufferViews) {
+			t.Errorf("accessor %d: %+v", i, document.Accessors[i])
+		}
+	}
+}
+
+func TestPhoneBoxMesh(t *testing.T) {
+	phoneConfig := testPhoneConfig()
+	simulationInput := testSimulationInput()
+	positions, indices := phoneBoxMesh(phoneConfig, normalizedInput(&simulationInput))
+	if len(positions) != 24 || len(indices) != 36 {
+		t.Fatalf("got %d coordinates and %d indices", len(positions), len(indices))
+	}
+
+	// corners 1, 2 and 4 are one step along width, length and height from corner 0
+	distance := func(a int, b int) float64 {
+		sum := 0.0
+		for j := 0; j < 3; j++ {
+			sum += (positions[3*a+j] - positions[3*b+j]) * (positions[3*a+j] - positions[3*b+j])
+		}
+		return math.Sqrt(sum)
+	}
+	wants := map[int]float64{1: phoneConfig.Width, 2: phoneConfig.Length, 4: phoneConfig.Height}
+	for corner, want := range wants {
+		if math.Abs(distance(0, corner)-want) > 1e-6 {
+			t.Errorf("corner %d is %g mm from corner 0, want %g", corner, distance(0, corner), want)
+		}
+	}
+}
+
+func TestGltfBuilderSkipsEmptyMeshes(t *testing.T) {
+	var builder gltfBuilder
+	material := builder.addMaterial("white", [4]float64{1, 1, 1, 1})
+	builder.addMesh("no positions", gltfPoints, material, nil, nil)
+	builder.addMesh("no lines", gltfLines, material, []float64{0, 0, 0, 1, 1, 1}, []int{})
+	builder.addMesh("points", gltfPoints, material, []float64{0, 0, 0}, nil)
+
+	if len(builder.meshes) != 1 || builder.meshes[0]["name"] != "points" {
+		t.Errorf("got meshes %v, want only points", builder.meshes)
+	}
+	// nothing is left behind by the meshes that were skipped
+	if len(builder.accessors) != 1 || len(builder.bufferViews) != 1 || builder.buffer.Len() != 12 {
+		t.Errorf("got %d accessors, %d buffer views and %d bytes, want 1, 1 and 12", len(builder.accessors), len(builder.bufferViews), builder.buffer.Len())
+	}
+}
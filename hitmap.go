@@ -0,0 +1,142 @@
+package main
+
+import (
+	"amphora/pkg/units"
+	"math"
+	"strings"
+)
+
+var maxHitMapBins = 720
+
+var hitMapSchemes = []string{"thetaPhi", "equalArea"}
+var hitMapAxes = []string{outputFrameWorld, outputFrameParaboloid, outputFramePhone}
+
+type HitMapInput struct {
+	// thetaPhi uses bins of equal angle, equalArea uses bins of equal cos θ
+	// so every cell covers the same solid angle
+	Scheme    string `json:"scheme"`
+	ThetaBins int    `json:"thetaBins"`
+	PhiBins   int    `json:"phiBins"`
+	// world measures θ from +z, paraboloid from the paraboloid axis and phone
+	// from the normal of the phone's front face, always about the paraboloid
+	// apex. Without one the world axes are used, whatever the output frame.
+	Axis string `json:"axis,omitempty"`
+}
+
+type HitMap struct {
+	Scheme     string    `json:"scheme"`
+	Axis       string    `json:"axis"`
+	ThetaBins  int       `json:"thetaBins"`
+	PhiBins    int       `json:"phiBins"`
+	ThetaEdges []float64 `json:"thetaEdges"`
+	PhiEdges   []float64 `json:"phiEdges"`
+	// per cell values in row-major order, θ rows by φ columns
+	Counts    []int     `json:"counts"`
+	Fractions []float64 `json:"fractions"`
+	Densities []float64 `json:"densities"`
+}
+
+func validateHitMapInput(validationError *ValidationError, hitMapInput *HitMapInput) {
+	if !isOneOf(hitMapInput.Scheme, hitMapSchemes) {
+		validationError.Add("hitMap.scheme", "unknown scheme %q, expected one of thetaPhi, equalArea", hitMapInput.Scheme)
+	}
+	if hitMapInput.Axis != "" && !isOneOf(hitMapInput.Axis, hitMapAxes) {
+		validationError.Add("hitMap.axis", "unknown axis %q, expected one of %s", hitMapInput.Axis, strings.Join(hitMapAxes, ", "))
+	}
+	if hitMapInput.ThetaBins < 1 || hitMapInput.ThetaBins > maxHitMapBins {
+		validationError.Add("hitMap.thetaBins", "must be between 1 and %d", maxHitMapBins)
+	}
+	if hitMapInput.PhiBins < 1 || hitMapInput.PhiBins > maxHitMapBins {
+		validationError.Add("hitMap.phiBins", "must be between 1 and %d", maxHitMapBins)
+	}
+}
+
+// paraboloidFrame returns the paraboloid's local x, y and z axes in world
+// coordinates, z being the axis the paraboloid opens along
+func paraboloidFrame(angleParaboloid units.Angle) [3][]float64 {
+	cosAngleParaboloid := math.Cos(angleParaboloid.Radians())
+	sinAngleParaboloid := math.Sin(angleParaboloid.Radians())
+
+	return [3][]float64{
+		{1, 0, 0},
+		{0, cosAngleParaboloid, sinAngleParaboloid},
+		{0, -sinAngleParaboloid, cosAngleParaboloid},
+	}
+}
+
+func binUserHits(phoneConfig *PhoneConfig, hitMapInput *HitMapInput, simulationInput *SimulationInput, output *SimulationOutput) *HitMap {
+	hitMap := &HitMap{
+		Scheme:    hitMapInput.Scheme,
+		Axis:      hitMapInput.Axis,
+		ThetaBins: hitMapInput.ThetaBins,
+		PhiBins:   hitMapInput.PhiBins,
+	}
+	if hitMap.Axis == "" {
+		hitMap.Axis = outputFrameWorld
+	}
+
+	// the axis has been validated
+	frame := [3][]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	_, axes, _ := frameAxes(phoneConfig, normalizedInput(simulationInput), hitMap.Axis)
+	if axes != nil {
+		frame = *axes
+	}
+
+	hitMap.ThetaEdges = make([]float64, hitMap.ThetaBins+1)
+	for i := 0; i <= hitMap.ThetaBins; i++ {
+		t := float64(i) / float64(hitMap.ThetaBins)
+		if hitMap.Scheme == "equalArea" {
+			hitMap.ThetaEdges[i] = math.Acos(1 - 2*t)
+		} else {
+			hitMap.ThetaEdges[i] = math.Pi * t
+		}
+	}
+
+	hitMap.PhiEdges = make([]float64, hitMap.PhiBins+1)
+	for j := 0; j <= hitMap.PhiBins; j++ {
+		hitMap.PhiEdges[j] = -math.Pi + 2*math.Pi*float64(j)/float64(hitMap.PhiBins)
+	}
+
+	cellCount := hitMap.ThetaBins * hitMap.PhiBins
+	hitMap.Counts = make([]int, cellCount)
+	hitMap.Fractions = make([]float64, cellCount)
+	hitMap.Densities = make([]float64, cellCount)
+
+	verticies := output.User
+	for i := 0; i+2 < len(verticies); i += 3 {
+		x := verticies[i]*frame[0][0] + verticies[i+1]*frame[0][1] + verticies[i+2]*frame[0][2]
+		y := verticies[i]*frame[1][0] + verticies[i+1]*frame[1][1] + verticies[i+2]*frame[1][2]
+		z := verticies[i]*frame[2][0] + verticies[i+1]*frame[2][1] + verticies[i+2]*frame[2][2]
+		length := math.Sqrt(x*x + y*y + z*z)
+		if length == 0 {
+			continue
+		}
+
+		cosTheta := math.Max(-1, math.Min(1, z/length))
+		var row int
+		if hitMap.Scheme == "equalArea" {
+			row = int((1 - cosTheta) / 2 * float64(hitMap.ThetaBins))
+		} else {
+			row = int(math.Acos(cosTheta) / math.Pi * float64(hitMap.ThetaBins))
+		}
+		column := int((math.Atan2(y, x) + math.Pi) / (2 * math.Pi) * float64(hitMap.PhiBins))
+		row = min(row, hitMap.ThetaBins-1)
+		column = min(column, hitMap.PhiBins-1)
+
+		hitMap.Counts[row*hitMap.PhiBins+column]++
+	}
+
+	phiWidth := 2 * math.Pi / float64(hitMap.PhiBins)
+	for row := 0; row < hitMap.ThetaBins; row++ {
+		solidAngle := (math.Cos(hitMap.ThetaEdges[row]) - math.Cos(hitMap.ThetaEdges[row+1])) * phiWidth
+		for column := 0; column < hitMap.PhiBins; column++ {
+			cell := row*hitMap.PhiBins + column
+			if output.Metrics.RaysEmitted > 0 {
+				hitMap.Fractions[cell] = float64(hitMap.Counts[cell]) / float64(output.Metrics.RaysEmitted)
+			}
+			hitMap.Densities[cell] = float64(hitMap.Counts[cell]) / solidAngle
+		}
+	}
+
+	return hitMap
+}
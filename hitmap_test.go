@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBinUserHits(t *testing.T) {
+	simulationInput := testSimulationInput()
+	simulationInput.Paraboloid.Angle = 90
+	output := &SimulationOutput{
+		// straight up, straight down and twice along +y
+		User:    []float64{0, 0, 2, 0, 0, -1, 0, 3, 0, 0, 1, 0},
+		Metrics: SimulationMetrics{RaysEmitted: 8},
+	}
+
+	tests := []struct {
+		name     string
+		hitMap   HitMapInput
+		output   *OutputInput
+		wantAxis string
+		// expected count per cell, row-major
+		want []int
+	}{
+		// +y lies on the θ = 90° edge, so it falls into the lower row
+		{name: "thetaPhi world", hitMap: HitMapInput{Scheme: "thetaPhi", ThetaBins: 2, PhiBins: 4}, wantAxis: "world", want: []int{0, 0, 1, 0, 0, 0, 1, 2}},
+		{name: "equalArea world", hitMap: HitMapInput{Scheme: "equalArea", ThetaBins: 2, PhiBins: 1}, wantAxis: "world", want: []int{1, 3}},
+		// tilted by 90°, the paraboloid axis points along -y
+		{name: "paraboloid axis", hitMap: HitMapInput{Scheme: "thetaPhi", ThetaBins: 2, PhiBins: 1, Axis: "paraboloid"}, wantAxis: "paraboloid", want: []int{0, 4}},
+		{name: "world axis in another output frame", hitMap: HitMapInput{Scheme: "thetaPhi", ThetaBins: 2, PhiBins: 1}, output: &OutputInput{Frame: outputFrameParaboloid}, wantAxis: "world", want: []int{1, 3}},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			simulationInput.Output = test.output
+			hitMap := binUserHits(testPhoneConfig(), &test.hitMap, &simulationInput, output)
+			if hitMap.Axis != test.wantAxis {
+				t.Errorf("got axis %q, want %q", hitMap.Axis, test.wantAxis)
+			}
+			if len(hitMap.ThetaEdges) != test.hitMap.ThetaBins+1 || len(hitMap.PhiEdges) != test.hitMap.PhiBins+1 {
+				t.Fatalf("got %d θ and %d φ edges", len(hitMap.ThetaEdges), len(hitMap.PhiEdges))
+			}
+			if len(hitMap.Counts) != len(test.want) {
+				t.Fatalf("got counts %v, want %v", hitMap.Counts, test.want)
+			}
+			solidAngle := 0.0
+			for j := 0; j < len(test.want); j++ {
+				if hitMap.Counts[j] != test.want[j] {
+					t.Errorf("got counts %v, want %v", hitMap.Counts, test.want)
+					break
+				}
+				if hitMap.Fractions[j] != float64(test.want[j])/8 {
+					t.Errorf("cell %d: got fraction %g", j, hitMap.Fractions[j])
+				}
+				if hitMap.Counts[j] > 0 {
+					solidAngle += float64(hitMap.Counts[j]) / hitMap.Densities[j]
+				}
+			}
+			if solidAngle > 4*math.Pi+1e-9 {
+				t.Errorf("occupied cells cover %g sr, more than the sphere", solidAngle)
+			}
+		})
+	}
+}
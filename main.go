@@ -2,15 +2,19 @@ package main
 
 import (
 	"amphora/pkg/linalg"
-	"encoding/xml"
+	"amphora/pkg/units"
+	"context"
+	"flag"
 	"fmt"
-	"io"
+	"html"
+	"log"
 	"math"
 	"math/rand/v2"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
     "github.com/aurowora/compress"
@@ -19,52 +23,140 @@ import (
 )
 
 type PhoneOption struct {
-	Name     string
-	Filename string
+	Name         string `json:"name"`
+	Filename     string `json:"filename"`
+	DisplayName  string `json:"displayName,omitempty"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Year         int    `json:"year,omitempty"`
+	// set when the definition file is invalid, such phones cannot be simulated
+	Errors PhoneDefinitionErrors `json:"errors,omitempty"`
 }
 
 type PhoneConfig struct {
-	Width   float64       `xml:"width"`
-	Length  float64       `xml:"length"`
-	Height  float64       `xml:"height"`
-	Speaker SpeakerConfig `xml:"Speaker"`
+	DisplayName  string        `xml:"displayName,omitempty" json:"displayName,omitempty" yaml:"displayName,omitempty"`
+	Manufacturer string        `xml:"manufacturer,omitempty" json:"manufacturer,omitempty" yaml:"manufacturer,omitempty"`
+	Year         int           `xml:"year,omitempty" json:"year,omitempty" yaml:"year,omitempty"`
+	Width        float64       `xml:"width" json:"width" yaml:"width"`
+	Length       float64       `xml:"length" json:"length" yaml:"length"`
+	Height       float64       `xml:"height" json:"height" yaml:"height"`
+	// rounds the corners of the width by length outline
+	CornerRadius float64 `xml:"cornerRadius,omitempty" json:"cornerRadius,omitempty" yaml:"cornerRadius,omitempty"`
+	// thickness a protective case adds on every side
+	CaseOffset float64       `xml:"caseOffset,omitempty" json:"caseOffset,omitempty" yaml:"caseOffset,omitempty"`
+	Speaker    SpeakerConfig `xml:"Speaker" json:"speaker" yaml:"speaker"`
 }
 
+// SpeakerConfig places the speaker opening on one face of the phone. On the
+// bottom and top edges Center is measured across the width from the right
+// edge, and Height runs through the thickness. On the left and right edges
+// Center is measured along the length from the bottom edge. On the front and
+// back faces Center is across the width, Offset is along the length from the
+// bottom edge and Height runs along the length.
 type SpeakerConfig struct {
-	Width  float64 `xml:"width"`
-	Height float64 `xml:"height"`
-	Center float64 `xml:"center"`
+	Face   string  `xml:"face,omitempty" json:"face,omitempty" yaml:"face,omitempty"`
+	Width  float64 `xml:"width" json:"width" yaml:"width"`
+	Height float64 `xml:"height" json:"height" yaml:"height"`
+	Center float64 `xml:"center" json:"center" yaml:"center"`
+	Offset float64 `xml:"offset,omitempty" json:"offset,omitempty" yaml:"offset,omitempty"`
+}
+
+// speaker faces, the bottom edge is the one resting in the paraboloid and the
+// back face lies against its wall, so rays mostly reflect off the front
+const (
+	speakerFaceBottom = "bottom"
+	speakerFaceTop    = "top"
+	speakerFaceLeft   = "left"
+	speakerFaceRight  = "right"
+	speakerFaceFront  = "front"
+	speakerFaceBack   = "back"
+)
+
+var speakerFaces = []string{speakerFaceBottom, speakerFaceTop, speakerFaceLeft, speakerFaceRight, speakerFaceFront, speakerFaceBack}
+
+// face defaults to the bottom edge, where the catalog phones have theirs
+func (speakerConfig *SpeakerConfig) face() string {
+	if speakerConfig.Face == "" {
+		return speakerFaceBottom
+	}
+	return speakerConfig.Face
+}
+
+// body returns the outline the simulation sees: the phone grown by its case
+// on every side, with the speaker opening moved out with the case wall
+func (phoneConfig *PhoneConfig) body() *PhoneConfig {
+	body := *phoneConfig
+	if body.CaseOffset == 0 {
+		return &body
+	}
+	body.Width += 2 * phoneConfig.CaseOffset
+	body.Length += 2 * phoneConfig.CaseOffset
+	body.Height += 2 * phoneConfig.CaseOffset
+	body.CornerRadius += phoneConfig.CaseOffset
+	body.Speaker.Center += phoneConfig.CaseOffset
+	if body.Speaker.face() == speakerFaceFront || body.Speaker.face() == speakerFaceBack {
+		body.Speaker.Offset += phoneConfig.CaseOffset
+	}
+	body.CaseOffset = 0
+	return &body
 }
 
 type PhoneInput struct {
 	Filename string  `json:"filename"`
-	Angle    float64 `json:"angle"`
-    AngleUnits string `json:"angleUnits"`
+	Angle    float64 `json:"angle" quantity:"angle,AngleUnits"`
+    AngleUnits string `json:"angleUnits,omitempty"`
 }
 
 type ParaboloidInput struct {
 	X     float64 `json:"x"`
 	Y     float64 `json:"y"`
 	Z     float64 `json:"z"`
-	Angle float64 `json:"angle"`
-    AngleUnits string `json:"angleUnits"`
+	Angle float64 `json:"angle" quantity:"angle,AngleUnits"`
+    AngleUnits string `json:"angleUnits,omitempty"`
 }
 
 type SlicingPlaneInput struct {
-	Height float64 `json:"height"`
-    HeightUnits string `json:"heightUnits"`
-	Angle  float64 `json:"angle"`
-    AngleUnits string `json:"angleUnits"`
+	Height float64 `json:"height" quantity:"length,HeightUnits"`
+    HeightUnits string `json:"heightUnits,omitempty"`
+	Angle  float64 `json:"angle" quantity:"angle,AngleUnits"`
+    AngleUnits string `json:"angleUnits,omitempty"`
 }
 
 type UserRadiusInput struct {
-	Radius float64 `json:"radius"`
-    RadiusUnits string `json:"radiusUnits"`
+	Radius float64 `json:"radius" quantity:"length,RadiusUnits"`
+    RadiusUnits string `json:"radiusUnits,omitempty"`
 }
 
+// ResolutionInput is in mm and rad unless told otherwise
 type ResolutionInput struct {
-	Linear  float64 `json:"linear"`
-	Angular float64 `json:"angular"`
+	Linear       float64 `json:"linear" quantity:"length,LinearUnits"`
+	LinearUnits  string  `json:"linearUnits,omitempty"`
+	Angular      float64 `json:"angular" quantity:"angle,AngularUnits"`
+	AngularUnits string  `json:"angularUnits,omitempty"`
+}
+
+func (phoneInput *PhoneInput) UnmarshalJSON(data []byte) error {
+	type plain PhoneInput
+	return decodeQuantities(data, (*plain)(phoneInput))
+}
+
+func (paraboloidInput *ParaboloidInput) UnmarshalJSON(data []byte) error {
+	type plain ParaboloidInput
+	return decodeQuantities(data, (*plain)(paraboloidInput))
+}
+
+func (slicingPlaneInput *SlicingPlaneInput) UnmarshalJSON(data []byte) error {
+	type plain SlicingPlaneInput
+	return decodeQuantities(data, (*plain)(slicingPlaneInput))
+}
+
+func (userRadiusInput *UserRadiusInput) UnmarshalJSON(data []byte) error {
+	type plain UserRadiusInput
+	return decodeQuantities(data, (*plain)(userRadiusInput))
+}
+
+func (resolutionInput *ResolutionInput) UnmarshalJSON(data []byte) error {
+	type plain ResolutionInput
+	return decodeQuantities(data, (*plain)(resolutionInput))
 }
 
 type SimulationInput struct {
@@ -73,56 +165,82 @@ type SimulationInput struct {
 	SlicingPlane SlicingPlaneInput `json:"slicingPlane"`
 	UserRadius   UserRadiusInput   `json:"userRadius"`
 	Resolution   ResolutionInput   `json:"resolution"`
+	HitMap       *HitMapInput      `json:"hitMap,omitempty"`
+	Baseline     *BaselineInput    `json:"baseline,omitempty"`
+	RecordRays   bool              `json:"recordRays,omitempty"`
+	Output       *OutputInput      `json:"output,omitempty"`
 }
 
 type SimulationOutput struct {
-	Phone      []float64
-	Paraboloid []float64
-	User       []float64
-}
-
-
-func conversion(val float64, unit string) float64 {
-    var outputVal float64
-    if unit == "mm" {
-        outputVal = val
-    } else if unit == "cm" {
-        outputVal = 10 * val
-    } else if unit == "m" {
-        outputVal = 1000 * val
-    } else if unit == "in" {
-        outputVal = 25.4 * val
-    } else if unit == "ft" {
-        outputVal = 25.4*12 * val
-    } else if unit == "deg" {
-        outputVal = math.Pi * val/180
-    } else if unit == "rad" {
-        outputVal = val
-    }
+	Phone      []float64 `json:"phone"`
+	Paraboloid []float64 `json:"paraboloid"`
+	User       []float64 `json:"user"`
+	Metrics    SimulationMetrics `json:"metrics"`
+	HitMap     *HitMap           `json:"hitMap,omitempty"`
+	Baseline   *BaselineOutput   `json:"baseline,omitempty"`
+	Rays       *RayRecord        `json:"rays,omitempty"`
+	// nil in the cache, which holds world mm
+	Coordinates *OutputCoordinates `json:"coordinates,omitempty"`
+}
+
 
-    return outputVal
+var simulationCache *SimulationCache
+var runStore *RunStore
+
+func main() {
+	os.Exit(runCommand(os.Args[1:]))
 }
 
-func parseXml(xmlFile string) (*PhoneConfig, error) {
-	f, err := os.Open(xmlFile)
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	serverFlags := addServerFlags(flags)
+	cacheFlags := addCacheFlags(flags)
+	phoneFlags := addPhoneFlags(flags)
+	assetFlags := addAssetFlags(flags)
+	phonesPoll := addDurationFlag(flags, "phones-poll", 2*time.Second, "how often to check the phone catalog directory for changes, in seconds without a unit (0 disables)")
+	runsDir := flags.String("runs-dir", "runs", "directory for the run history")
+	runsStoreOutput := flags.Bool("runs-store-output", true, "keep full vertex output with each run")
+	runsMax := flags.Int("runs-max", 1000, "runs to keep in the history, the oldest are deleted first (0 keeps all)")
+	runsMaxAge := addDurationFlag(flags, "runs-max-age", 0, "delete runs older than this, in seconds without a unit (0 keeps them)")
+	addLimitFlags(flags)
+	err := flags.Parse(args)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	err = loadFlagConfig(flags, "config")
+	if err != nil {
+		return err
+	}
+	err = serverFlags.check()
+	if err != nil {
+		return err
+	}
+	err = checkLimitFlags()
+	if err != nil {
+		return err
 	}
-	defer f.Close()
 
-	byteValue, err := io.ReadAll(f)
+	err = cacheFlags.open()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	var phone PhoneConfig
-	xml.Unmarshal(byteValue, &phone)
+	phoneFlags.open()
+	cachedPhoneStore, err := NewCachedPhoneStore(phoneStore)
+	if err != nil {
+		return err
+	}
+	phoneStore = cachedPhoneStore
+	go cachedPhoneStore.Watch(*phonesPoll, serverStopping)
 
-	return &phone, err
-}
+	runStore, err = NewRunStore(*runsDir, *runsStoreOutput, RunRetention{MaxRuns: *runsMax, MaxAge: *runsMaxAge})
+	if err != nil {
+		return err
+	}
 
-func main() {
 	r := gin.Default()
+	r.Use(trackRequests)
+	r.Use(recordRequestMetrics)
 	r.Use(compress.Compress(
         compress.WithAlgo(compress.BROTLI, false),
         compress.WithAlgo(compress.GZIP, false),
@@ -130,54 +248,396 @@ func main() {
         compress.WithAlgo(compress.ZSTD, true),
         compress.WithCompressLevel(compress.ZSTD, compress.ZstdSpeedFastest),
     ))
-    // serve index page
-	r.StaticFile("", "./ui/index.html")
+	// everything is served under the base path, the UI uses relative URLs
+	root := r.Group(basePath)
 
-	// serve javascript webgl
-	r.StaticFile("/js/webgl.js", "./src/js/webgl.js")
+    // serve index page, vendored scripts and javascript webgl
+	registerAssetRoutes(root, assetFlags)
 
 	// htmx
-	r.GET("/htmx/phones", HandleHtmxGetPhones)
+	root.GET("/htmx/phones", HandleHtmxGetPhones)
+	root.POST("/htmx/phones", HandleHtmxCreatePhone)
+	root.GET("/htmx/phones/events", HandleHtmxPhoneEvents)
+	root.GET("/htmx/runs", HandleHtmxGetRuns)
 
 	// api
-	r.GET("/api/phone", HandleApiPhone)
-	r.GET("/api/phones", HandleApiGetPhones)
+	v1 := root.Group(apiVersionPath)
+	registerApiRoutes(v1)
+	v1.GET("/openapi.json", HandleApiOpenApi)
 
-	r.POST("/api/simulation", HandleApiSimulation)
+	// unversioned aliases for scripts written before /api/v1
+	legacy := root.Group("/api", deprecatedApi)
+	registerApiRoutes(legacy)
 
-	// profiler registrations
-	pprof.Register(r)
+	// profiler registrations and prometheus metrics
+	pprof.Register(root)
+	root.GET("/metrics", HandleMetrics)
 
 	// run web server
-	r.Run("localhost:8080")
+	return serve(r, serverFlags)
 }
 
 func HandleApiSimulation(c *gin.Context) {
 	var simulationInput SimulationInput
-	err := c.BindJSON(&simulationInput)
+	err := c.ShouldBindJSON(&simulationInput)
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		validationError := &ValidationError{}
+		validationError.Add("body", "malformed request body: %v", err)
+		abortWithValidationError(c, validationError)
+		return
 	}
 
-	phoneConfig, err := getPhoneDimensions(simulationInput.Phone.Filename)
-	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+	phoneConfig, validationError := prepareSimulation(&simulationInput)
+	if validationError != nil {
+		abortWithValidationError(c, validationError)
+		return
 	}
 
-	phoneVerticies, paraboloidVerticies, userVerticies, err := generateSimulation(phoneConfig, &simulationInput.Phone, &simulationInput.Paraboloid, &simulationInput.SlicingPlane, &simulationInput.UserRadius, &simulationInput.Resolution)
+	startTime := time.Now()
+	simulationOutput, cached, err := runSimulation(phoneConfig, &simulationInput)
 	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
+		abortWithSimulationError(c, err)
+		return
 	}
 
-	var simulationOutput SimulationOutput
-	simulationOutput.Phone = phoneVerticies
-	simulationOutput.Paraboloid = paraboloidVerticies
-	simulationOutput.User = userVerticies
+	var runRecord RunRecord
+	runRecord.CreatedAt = startTime
+	runRecord.DurationMs = float64(time.Since(startTime).Microseconds()) / 1000
+	runRecord.Cached = cached
+	runRecord.Input = simulationInput
+	runRecord.Phone = *phoneConfig
+	runRecord.Summary = summarizeOutput(simulationOutput)
+	err = runStore.Save(&runRecord, simulationOutput)
+	if err != nil {
+		log.Printf("run history: %v", err)
+	}
 
+	if cached {
+		c.Header("X-Cache", "HIT")
+	} else {
+		c.Header("X-Cache", "MISS")
+	}
+	c.Header("X-Run-Id", runRecord.Id)
 	c.JSON(http.StatusOK, simulationOutput)
 }
 
-func generateSimulation(phoneConfig *PhoneConfig, phoneInput *PhoneInput, paraboloidInput *ParaboloidInput, slicingPlaneInput *SlicingPlaneInput, userRadiusInput *UserRadiusInput, resolutionInput *ResolutionInput) ([]float64, []float64, []float64, error) {
+// runSimulation returns the output for the given configuration in the units
+// and frame it asks for, reusing a cached result when one exists. The second
+// return value reports a cache hit.
+func runSimulation(phoneConfig *PhoneConfig, simulationInput *SimulationInput) (*SimulationOutput, bool, error) {
+	simulationOutput, cached, err := runWorldSimulation(context.Background(), phoneConfig, simulationInput)
+	if err != nil {
+		return nil, false, err
+	}
+
+	simulationOutput, err = frameSimulationOutput(phoneConfig, simulationInput, simulationOutput)
+	if err != nil {
+		return nil, false, err
+	}
+	return simulationOutput, cached, nil
+}
+
+// runWorldSimulation is runSimulation with vertices left in world mm, for
+// callers that measure directions from the paraboloid apex themselves. Tracing
+// stops early once ctx is done.
+func runWorldSimulation(ctx context.Context, phoneConfig *PhoneConfig, simulationInput *SimulationInput) (*SimulationOutput, bool, error) {
+	simulationOutput, cached, err := runCachedSimulation(phoneConfig, simulationInput, simulationOptions{withParaboloid: true, recordRays: simulationInput.RecordRays, ctx: ctx})
+	if err != nil {
+		return nil, false, err
+	}
+
+	// binning and the baseline comparison are applied on top of the cached
+	// result rather than being part of the cache key
+	if simulationInput.HitMap != nil || simulationInput.Baseline != nil {
+		extendedOutput := *simulationOutput
+		simulationOutput = &extendedOutput
+	}
+
+	if simulationInput.HitMap != nil {
+		simulationOutput.HitMap = binUserHits(phoneConfig, simulationInput.HitMap, simulationInput, simulationOutput)
+	}
+
+	if simulationInput.Baseline != nil {
+		baselineOutput, _, err := runCachedSimulation(phoneConfig, simulationInput, simulationOptions{withParaboloid: false, ctx: ctx})
+		if err != nil {
+			return nil, false, err
+		}
+
+		simulationOutput.Baseline = &BaselineOutput{
+			Metrics: baselineOutput.Metrics,
+			Gain:    computeGain(phoneConfig, simulationInput, simulationOutput, baselineOutput),
+		}
+	}
+
+	return simulationOutput, cached, nil
+}
+
+func runCachedSimulation(phoneConfig *PhoneConfig, simulationInput *SimulationInput, options simulationOptions) (*SimulationOutput, bool, error) {
+	cacheKey, err := simulationCacheKey(phoneConfig, simulationInput, options)
+	if err != nil {
+		return nil, false, err
+	}
+
+	simulationOutput, cached := simulationCache.Get(cacheKey)
+	observeCacheLookup(cached)
+	if cached {
+		return simulationOutput, true, nil
+	}
+
+	simulationOutput, err = generateTrackedSimulation(phoneConfig, simulationInput, options)
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = simulationCache.Put(cacheKey, simulationOutput)
+	if err != nil {
+		log.Printf("simulation cache: %v", err)
+	}
+
+	return simulationOutput, false, nil
+}
+
+// generateTrackedSimulation runs generateSimulation under the in-flight gauge,
+// which comes back down even when a simulation panics
+func generateTrackedSimulation(phoneConfig *PhoneConfig, simulationInput *SimulationInput, options simulationOptions) (*SimulationOutput, error) {
+	simulationsInFlight.add(1)
+	defer simulationsInFlight.add(-1)
+
+	startTime := time.Now()
+	simulationOutput, err := generateSimulation(phoneConfig, normalizedInput(simulationInput), options)
+	if err != nil {
+		return nil, err
+	}
+	observeSimulation(simulationOutput, time.Since(startTime))
+	return simulationOutput, nil
+}
+
+// phonePlacement is where the capsule puts the phone, in mm. Corner is the
+// corner of the back face at the speaker end; the face spans -Width and
+// +Length from it and the body rises along Height, away from the paraboloid
+// wall.
+type phonePlacement struct {
+	Corner []float64
+	Width  []float64
+	Length []float64
+	Height []float64
+}
+
+func placePhone(phoneConfig *PhoneConfig, normalized NormalizedSimulationInput) phonePlacement {
+	var placement phonePlacement
+
+	coefficientsParaboloidX := normalized.ParaboloidX
+	coefficientsParaboloidY := normalized.ParaboloidY
+	coefficientsParaboloidZ := normalized.ParaboloidZ
+	angleParaboloid := normalized.ParaboloidAngle.Radians()
+	anglePhone := normalized.PhoneAngle.Radians()
+	widthPhone := phoneConfig.Width
+	lengthPhone := phoneConfig.Length
+
+	cosAngleParaboloid := math.Cos(angleParaboloid)
+	sinAngleParaboloid := math.Sin(angleParaboloid)
+	cosAnglePhone := math.Cos(anglePhone)
+	sinAnglePhone := math.Sin(anglePhone)
+	sqWidthPhone := math.Pow(widthPhone, 2)
+	sqLengthPhone := math.Pow(lengthPhone, 2)
+	sqCoefficientsParaboloidY := math.Pow(coefficientsParaboloidY, 2)
+	sqCoefficientsParaboloidZ := math.Pow(coefficientsParaboloidZ, 2)
+
+	placement.Corner = []float64{
+		0.5 * widthPhone,
+		+(-8.0*sqWidthPhone*coefficientsParaboloidX*coefficientsParaboloidY*sinAngleParaboloid - 6.0*sqLengthPhone*sqCoefficientsParaboloidY*sinAngleParaboloid - 8.0*sqCoefficientsParaboloidZ*sinAngleParaboloid - 16.0*lengthPhone*coefficientsParaboloidY*coefficientsParaboloidZ*sinAnglePhone - 8.0*lengthPhone*coefficientsParaboloidY*coefficientsParaboloidZ*math.Sin(2.0*angleParaboloid+anglePhone) - 4.0*sqWidthPhone*coefficientsParaboloidX*coefficientsParaboloidY*math.Sin(angleParaboloid+2.0*anglePhone) - 4.0*sqLengthPhone*sqCoefficientsParaboloidY*math.Sin(angleParaboloid+2.0*anglePhone) + 12.0*sqCoefficientsParaboloidZ*math.Sin(angleParaboloid+2.0*anglePhone) + 4.0*sqWidthPhone*coefficientsParaboloidX*coefficientsParaboloidY*math.Sin(3.0*angleParaboloid+2.0*anglePhone) + 4.0*sqLengthPhone*sqCoefficientsParaboloidY*math.Sin(3.0*angleParaboloid+2.0*anglePhone) + 4.0*sqCoefficientsParaboloidZ*math.Sin(3.0*angleParaboloid+2.0*anglePhone) + 8.0*lengthPhone*coefficientsParaboloidY*coefficientsParaboloidZ*math.Sin(2.0*angleParaboloid+3.0*anglePhone) + sqLengthPhone*sqCoefficientsParaboloidY*math.Sin(3.0*angleParaboloid+4.0*anglePhone) - sqLengthPhone*sqCoefficientsParaboloidY*math.Sin(5.0*angleParaboloid+4.0*anglePhone)) / (64.0 * coefficientsParaboloidY * coefficientsParaboloidZ * math.Pow(math.Sin(angleParaboloid+anglePhone), 2)),
+		-(-8.0*sqWidthPhone*cosAngleParaboloid*coefficientsParaboloidX*coefficientsParaboloidY + 4.0*sqWidthPhone*math.Cos(angleParaboloid+2.0*anglePhone)*coefficientsParaboloidX*coefficientsParaboloidY + 4.0*sqWidthPhone*math.Cos(3.0*angleParaboloid+2.0*anglePhone)*coefficientsParaboloidX*coefficientsParaboloidY - 6.0*sqLengthPhone*cosAngleParaboloid*sqCoefficientsParaboloidY + 4.0*sqLengthPhone*math.Cos(angleParaboloid+2.0*anglePhone)*sqCoefficientsParaboloidY + 4.0*sqLengthPhone*math.Cos(3.0*angleParaboloid+2.0*anglePhone)*sqCoefficientsParaboloidY - sqLengthPhone*math.Cos(3.0*angleParaboloid+4.0*anglePhone)*sqCoefficientsParaboloidY - sqLengthPhone*math.Cos(5.0*angleParaboloid+4.0*anglePhone)*sqCoefficientsParaboloidY + 16.0*lengthPhone*cosAnglePhone*coefficientsParaboloidY*coefficientsParaboloidZ - 8.0*lengthPhone*math.Cos(2.0*angleParaboloid+anglePhone)*coefficientsParaboloidY*coefficientsParaboloidZ - 8.0*lengthPhone*math.Cos(2.0*angleParaboloid+3.0*anglePhone)*coefficientsParaboloidY*coefficientsParaboloidZ - 8.0*cosAngleParaboloid*sqCoefficientsParaboloidZ - 12.0*math.Cos(angleParaboloid+2.0*anglePhone)*sqCoefficientsParaboloidZ + 4.0*math.Cos(3.0*angleParaboloid+2.0*anglePhone)*sqCoefficientsParaboloidZ) / (64.0 * coefficientsParaboloidY * coefficientsParaboloidZ * math.Pow(math.Sin(angleParaboloid+anglePhone), 2)),
+	}
+
+	placement.Width = []float64{widthPhone, 0, 0}
+	linalg.Normalize(placement.Width, 3)
+	placement.Length = []float64{0, lengthPhone * sinAnglePhone, lengthPhone * cosAnglePhone}
+	linalg.Normalize(placement.Length, 3)
+	placement.Height = []float64{0, 0, 0}
+	placement.Height[0] = placement.Length[1]*placement.Width[2] - placement.Length[2]*placement.Width[1]
+	placement.Height[1] = placement.Length[2]*placement.Width[0] - placement.Length[0]*placement.Width[2]
+	placement.Height[2] = placement.Length[0]*placement.Width[1] - placement.Length[1]*placement.Width[0]
+	linalg.Normalize(placement.Height, 3)
+
+	return placement
+}
+
+// speakerFrame is where rays leave the speaker opening: its centre, the
+// outward normal of its face, and the directions its width and height span
+type speakerFrame struct {
+	Center []float64
+	Normal []float64
+	Across []float64
+	Up     []float64
+}
+
+// placeSpeaker finds the speaker opening of a phone body placed by placePhone.
+// The body spans corner - s·Width + t·Length + r·Height for s, t and r between
+// zero and its width, length and height.
+func placeSpeaker(phoneConfig *PhoneConfig, placement phonePlacement) speakerFrame {
+	var frame speakerFrame
+	frame.Center = []float64{0, 0, 0}
+	frame.Normal = []float64{0, 0, 0}
+	speaker := &phoneConfig.Speaker
+
+	// offsets of the opening centre along Width, Length and Height
+	var s, t, r float64
+	switch speaker.face() {
+	case speakerFaceTop:
+		s, t, r = -speaker.Center, phoneConfig.Length, 0.5*phoneConfig.Height
+		frame.Across, frame.Up = placement.Width, placement.Height
+		linalg.Equivalent(frame.Normal, placement.Length, 3)
+	case speakerFaceRight:
+		s, t, r = 0, speaker.Center, 0.5*phoneConfig.Height
+		frame.Across, frame.Up = placement.Length, placement.Height
+		linalg.Equivalent(frame.Normal, placement.Width, 3)
+	case speakerFaceLeft:
+		s, t, r = -phoneConfig.Width, speaker.Center, 0.5*phoneConfig.Height
+		frame.Across, frame.Up = placement.Length, placement.Height
+		for i := 0; i < 3; i++ {
+			frame.Normal[i] = -placement.Width[i]
+		}
+	case speakerFaceFront:
+		s, t, r = -speaker.Center, speaker.Offset, phoneConfig.Height
+		frame.Across, frame.Up = placement.Width, placement.Length
+		linalg.Equivalent(frame.Normal, placement.Height, 3)
+	case speakerFaceBack:
+		s, t, r = -speaker.Center, speaker.Offset, 0
+		frame.Across, frame.Up = placement.Width, placement.Length
+		for i := 0; i < 3; i++ {
+			frame.Normal[i] = -placement.Height[i]
+		}
+	default:
+		s, t, r = -speaker.Center, 0, 0.5*phoneConfig.Height
+		frame.Across, frame.Up = placement.Width, placement.Height
+		for i := 0; i < 3; i++ {
+			frame.Normal[i] = -placement.Length[i]
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		frame.Center[i] = placement.Corner[i] + s*placement.Width[i] + r*placement.Height[i]
+		if t != 0 {
+			frame.Center[i] += t * placement.Length[i]
+		}
+	}
+	return frame
+}
+
+// insideRoundedRect reports whether (s, t) lies within a width by length
+// rectangle whose corners are rounded to radius
+func insideRoundedRect(s float64, t float64, width float64, length float64, radius float64) bool {
+	nearestS := math.Max(radius, math.Min(s, width-radius))
+	nearestT := math.Max(radius, math.Min(t, length-radius))
+	return math.Pow(s-nearestS, 2)+math.Pow(t-nearestT, 2) <= math.Pow(radius, 2)
+}
+
+// intersectPhoneBody returns how far along direction a ray from location
+// first meets the phone body placed by placePhone, or -1 when it misses, and
+// stores the outward normal of the face it meets in normal. In placement
+// coordinates the body spans s from 0 to its width along -Width, t from 0 to
+// its length along Length and r from 0 to its height along Height; its edges
+// along Height are rounded to the corner radius. Hits closer than threshold
+// are ignored so that a ray leaving a face does not meet it again.
+func intersectPhoneBody(normal []float64, phoneConfig *PhoneConfig, placement phonePlacement, location []float64, direction []float64, threshold float64) float64 {
+	width := phoneConfig.Width
+	length := phoneConfig.Length
+	height := phoneConfig.Height
+	radius := phoneConfig.CornerRadius
+
+	relative := []float64{0, 0, 0}
+	for i := 0; i < 3; i++ {
+		relative[i] = location[i] - placement.Corner[i]
+	}
+	s0 := -linalg.DotProduct(relative, placement.Width, 3)
+	t0 := linalg.DotProduct(relative, placement.Length, 3)
+	r0 := linalg.DotProduct(relative, placement.Height, 3)
+	ds := -linalg.DotProduct(direction, placement.Width, 3)
+	dt := linalg.DotProduct(direction, placement.Length, 3)
+	dr := linalg.DotProduct(direction, placement.Height, 3)
+
+	nearest := -1.0
+	var normalS, normalT, normalR float64
+	consider := func(distance float64, ns float64, nt float64, nr float64) {
+		if distance > threshold && (nearest < 0 || distance < nearest) {
+			nearest = distance
+			normalS, normalT, normalR = ns, nt, nr
+		}
+	}
+
+	// the back and front faces
+	faces := []float64{0, height}
+	sides := []float64{-1, 1}
+	for i := 0; i < 2 && dr != 0; i++ {
+		distance := (faces[i] - r0) / dr
+		if insideRoundedRect(s0+distance*ds, t0+distance*dt, width, length, radius) {
+			consider(distance, 0, 0, sides[i])
+		}
+	}
+
+	// the long edges, then the short ones at the speaker end and the far end
+	faces = []float64{0, width}
+	for i := 0; i < 2 && ds != 0; i++ {
+		distance := (faces[i] - s0) / ds
+		t := t0 + distance*dt
+		r := r0 + distance*dr
+		if radius <= t && t <= length-radius && 0 <= r && r <= height {
+			consider(distance, sides[i], 0, 0)
+		}
+	}
+	faces = []float64{0, length}
+	for i := 0; i < 2 && dt != 0; i++ {
+		distance := (faces[i] - t0) / dt
+		s := s0 + distance*ds
+		r := r0 + distance*dr
+		if radius <= s && s <= width-radius && 0 <= r && r <= height {
+			consider(distance, 0, sides[i], 0)
+		}
+	}
+
+	// the rounded corners are quarter cylinders along Height; only the
+	// nearer root matters as rays never start inside the body
+	a := ds*ds + dt*dt
+	if radius > 0 && a > 0 {
+		centresS := []float64{radius, width - radius}
+		centresT := []float64{radius, length - radius}
+		for i := 0; i < 2; i++ {
+			for j := 0; j < 2; j++ {
+				offsetS := s0 - centresS[i]
+				offsetT := t0 - centresT[j]
+				b := 2 * (offsetS*ds + offsetT*dt)
+				c := offsetS*offsetS + offsetT*offsetT - radius*radius
+				discriminant := b*b - 4*a*c
+				if discriminant < 0 {
+					continue
+				}
+				distance := (-b - math.Sqrt(discriminant)) / (2 * a)
+				alongS := offsetS + distance*ds
+				alongT := offsetT + distance*dt
+				r := r0 + distance*dr
+				if alongS*sides[i] >= 0 && alongT*sides[j] >= 0 && 0 <= r && r <= height {
+					consider(distance, alongS/radius, alongT/radius, 0)
+				}
+			}
+		}
+	}
+
+	if nearest < 0 {
+		return -1
+	}
+	for i := 0; i < 3; i++ {
+		normal[i] = -normalS*placement.Width[i] + normalT*placement.Length[i] + normalR*placement.Height[i]
+	}
+	return nearest
+}
+
+// rays leave the speaker up to 30° off its axis, all the way around it
+var emissionSpanAzimuthal = units.FromDegrees(30)
+var emissionSpanPolar = units.Angle(2 * math.Pi)
+
+// generateSimulation traces rays from the speaker until they reach the
+// listener. Vertices are in world mm.
+func generateSimulation(phoneConfig *PhoneConfig, normalized NormalizedSimulationInput, options simulationOptions) (*SimulationOutput, error) {
 	var coefficientsParaboloidX float64
 	var coefficientsParaboloidY float64
 	var coefficientsParaboloidZ float64
@@ -185,34 +645,23 @@ func generateSimulation(phoneConfig *PhoneConfig, phoneInput *PhoneInput, parabo
 	var heightSlicingPlane float64
 	var angleSlicingPlane float64
 
-	var anglePhone float64
 	var radiusUser float64
 	var linearResolution float64
 	var angularResolution float64
 
-	var widthPhone float64
-	var lengthPhone float64
-	var heightPhone float64
 	var widthSpeaker float64
 	var heightSpeaker float64
-	var centerSpeaker float64
 
-	var xPhone float64
-	var yPhone float64
-	var zPhone float64
-	cornerPhone := []float64{0, 0, 0}
-
-	vecPhoneWidth := []float64{0, 0, 0}
-	vecPhoneLength := []float64{0, 0, 0}
-	vecPhoneHeight := []float64{0, 0, 0}
+	normalPhone := []float64{0, 0, 0}
 
 	initialPhononLocation := []float64{0, 0, 0}
 	initialPhononProjection := []float64{0, 0, 0}
+	axisPolar := []float64{0, 0, 0}
 
 	locationSpeaker := []float64{0, 0, 0}
 
-	spanAzimuthal := conversion(30.0, "deg")
-	spanPolar := conversion(2*math.Pi, "rad")
+	spanAzimuthal := emissionSpanAzimuthal.Radians()
+	spanPolar := emissionSpanPolar.Radians()
 
 	locationPhonon := []float64{0, 0, 0}
 	projectionPhonon := []float64{0, 0, 0}
@@ -228,6 +677,15 @@ func generateSimulation(phoneConfig *PhoneConfig, phoneInput *PhoneInput, parabo
 	}
 
 	atUser := false
+	var bounces int
+	var hitParaboloid bool
+	var hitPhone bool
+	var metrics metricsAccumulator
+	var rayId int
+	var rays *RayRecord
+	if options.recordRays {
+		rays = newRayRecord()
+	}
 
 	intersectParaboloid := []float64{0, 0, 0}
 	intersectPhone := []float64{0, 0, 0}
@@ -248,25 +706,21 @@ func generateSimulation(phoneConfig *PhoneConfig, phoneInput *PhoneInput, parabo
 	paraboloidVerticies := make([]float64, 0, 50000)
 	userVerticies := make([]float64, 0, 50000)
 
-	coefficientsParaboloidX = paraboloidInput.X
-	coefficientsParaboloidY = paraboloidInput.Y
-	coefficientsParaboloidZ = paraboloidInput.Z
-	angleParaboloid = conversion(paraboloidInput.Angle, paraboloidInput.AngleUnits)
+	coefficientsParaboloidX = normalized.ParaboloidX
+	coefficientsParaboloidY = normalized.ParaboloidY
+	coefficientsParaboloidZ = normalized.ParaboloidZ
+	angleParaboloid = normalized.ParaboloidAngle.Radians()
 
-	heightSlicingPlane = conversion(slicingPlaneInput.Height, slicingPlaneInput.HeightUnits)
-	angleSlicingPlane = conversion(slicingPlaneInput.Angle, slicingPlaneInput.AngleUnits)
+	heightSlicingPlane = normalized.SlicingPlaneHeight.Millimetres()
+	angleSlicingPlane = normalized.SlicingPlaneAngle.Radians()
 
-    anglePhone = conversion(phoneInput.Angle, phoneInput.AngleUnits)
+	radiusUser = normalized.UserRadius.Millimetres()
 
-	radiusUser = conversion(userRadiusInput.Radius, userRadiusInput.RadiusUnits)
+	linearResolution = normalized.LinearResolution.Millimetres()
+	angularResolution = normalized.AngularResolution.Radians()
 
-	linearResolution = resolutionInput.Linear
-	angularResolution = resolutionInput.Angular
-
-	widthPhone = phoneConfig.Width
-	lengthPhone = phoneConfig.Length
-	heightPhone = phoneConfig.Height
-	centerSpeaker = phoneConfig.Speaker.Center
+	// rays see the outside of the case, if there is one
+	phoneConfig = phoneConfig.body()
 	heightSpeaker = phoneConfig.Speaker.Height
 	widthSpeaker = phoneConfig.Speaker.Width
 
@@ -275,62 +729,54 @@ func generateSimulation(phoneConfig *PhoneConfig, phoneInput *PhoneInput, parabo
     sinAngleParaboloid := math.Sin(angleParaboloid)
     cosAngleSlicingPlane := math.Cos(angleSlicingPlane)
     sinAngleSlicingPlane := math.Sin(angleSlicingPlane)
-    cosAnglePhone := math.Cos(anglePhone)
-    sinAnglePhone := math.Sin(anglePhone)
-    sqWidthPhone := math.Pow(widthPhone, 2)
-    sqLengthPhone := math.Pow(lengthPhone, 2)
-    //sqCoefficientsParaboloidX := math.Pow(coefficientsParaboloidX, 2)
-    sqCoefficientsParaboloidY := math.Pow(coefficientsParaboloidY, 2)
-    sqCoefficientsParaboloidZ := math.Pow(coefficientsParaboloidZ, 2)
     sqCosAngleParaboloid := math.Pow(cosAngleParaboloid, 2)
     sqSinAngleParaboloid := math.Pow(sinAngleParaboloid, 2)
     sqRadiusUser := math.Pow(radiusUser, 2)
     thresholdVal := math.Pow(10.0, -6)
-	
-    xPhone = 0.5 * widthPhone
-	yPhone = +(-8.0*sqWidthPhone*coefficientsParaboloidX*coefficientsParaboloidY*sinAngleParaboloid - 6.0*sqLengthPhone*sqCoefficientsParaboloidY*sinAngleParaboloid - 8.0*sqCoefficientsParaboloidZ*sinAngleParaboloid - 16.0*lengthPhone*coefficientsParaboloidY*coefficientsParaboloidZ*sinAnglePhone - 8.0*lengthPhone*coefficientsParaboloidY*coefficientsParaboloidZ*math.Sin(2.0*angleParaboloid+anglePhone) - 4.0*sqWidthPhone*coefficientsParaboloidX*coefficientsParaboloidY*math.Sin(angleParaboloid+2.0*anglePhone) - 4.0*sqLengthPhone*sqCoefficientsParaboloidY*math.Sin(angleParaboloid+2.0*anglePhone) + 12.0*sqCoefficientsParaboloidZ*math.Sin(angleParaboloid+2.0*anglePhone) + 4.0*sqWidthPhone*coefficientsParaboloidX*coefficientsParaboloidY*math.Sin(3.0*angleParaboloid+2.0*anglePhone) + 4.0*sqLengthPhone*sqCoefficientsParaboloidY*math.Sin(3.0*angleParaboloid+2.0*anglePhone) + 4.0*sqCoefficientsParaboloidZ*math.Sin(3.0*angleParaboloid+2.0*anglePhone) + 8.0*lengthPhone*coefficientsParaboloidY*coefficientsParaboloidZ*math.Sin(2.0*angleParaboloid+3.0*anglePhone) + sqLengthPhone*sqCoefficientsParaboloidY*math.Sin(3.0*angleParaboloid+4.0*anglePhone) - sqLengthPhone*sqCoefficientsParaboloidY*math.Sin(5.0*angleParaboloid+4.0*anglePhone)) / (64.0 * coefficientsParaboloidY * coefficientsParaboloidZ * math.Pow(math.Sin(angleParaboloid+anglePhone), 2))
-	zPhone = -(-8.0*sqWidthPhone*cosAngleParaboloid*coefficientsParaboloidX*coefficientsParaboloidY + 4.0*sqWidthPhone*math.Cos(angleParaboloid+2.0*anglePhone)*coefficientsParaboloidX*coefficientsParaboloidY + 4.0*sqWidthPhone*math.Cos(3.0*angleParaboloid+2.0*anglePhone)*coefficientsParaboloidX*coefficientsParaboloidY - 6.0*sqLengthPhone*cosAngleParaboloid*sqCoefficientsParaboloidY + 4.0*sqLengthPhone*math.Cos(angleParaboloid+2.0*anglePhone)*sqCoefficientsParaboloidY + 4.0*sqLengthPhone*math.Cos(3.0*angleParaboloid+2.0*anglePhone)*sqCoefficientsParaboloidY - sqLengthPhone*math.Cos(3.0*angleParaboloid+4.0*anglePhone)*sqCoefficientsParaboloidY - sqLengthPhone*math.Cos(5.0*angleParaboloid+4.0*anglePhone)*sqCoefficientsParaboloidY + 16.0*lengthPhone*cosAnglePhone*coefficientsParaboloidY*coefficientsParaboloidZ - 8.0*lengthPhone*math.Cos(2.0*angleParaboloid+anglePhone)*coefficientsParaboloidY*coefficientsParaboloidZ - 8.0*lengthPhone*math.Cos(2.0*angleParaboloid+3.0*anglePhone)*coefficientsParaboloidY*coefficientsParaboloidZ - 8.0*cosAngleParaboloid*sqCoefficientsParaboloidZ - 12.0*math.Cos(angleParaboloid+2.0*anglePhone)*sqCoefficientsParaboloidZ + 4.0*math.Cos(3.0*angleParaboloid+2.0*anglePhone)*sqCoefficientsParaboloidZ) / (64.0 * coefficientsParaboloidY * coefficientsParaboloidZ * math.Pow(math.Sin(angleParaboloid+anglePhone), 2))
-	cornerPhone[0] = xPhone
-	cornerPhone[1] = yPhone
-	cornerPhone[2] = zPhone
-
-	vecPhoneWidth[0] = widthPhone
-	vecPhoneWidth[1] = 0
-	vecPhoneWidth[2] = 0
-	linalg.Normalize(vecPhoneWidth, 3)
-
-	vecPhoneLength[0] = 0
-	vecPhoneLength[1] = lengthPhone * sinAnglePhone
-	vecPhoneLength[2] = lengthPhone * cosAnglePhone
-	linalg.Normalize(vecPhoneLength, 3)
-
-	vecPhoneHeight[0] = vecPhoneLength[1]*vecPhoneWidth[2] - vecPhoneLength[2]*vecPhoneWidth[1]
-	vecPhoneHeight[1] = vecPhoneLength[2]*vecPhoneWidth[0] - vecPhoneLength[0]*vecPhoneWidth[2]
-	vecPhoneHeight[2] = vecPhoneLength[0]*vecPhoneWidth[1] - vecPhoneLength[1]*vecPhoneWidth[0]
-	linalg.Normalize(vecPhoneHeight, 3)
 
+	placement := placePhone(phoneConfig, normalized)
+
+	speaker := placeSpeaker(phoneConfig, placement)
+	linalg.Equivalent(initialPhononLocation, speaker.Center, 3)
+	linalg.Equivalent(initialPhononProjection, speaker.Normal, 3)
+	// the cone of rays spins about the speaker normal and opens towards its width
 	for i := 0; i < 3; i++ {
-		initialPhononLocation[i] = cornerPhone[i] - centerSpeaker*vecPhoneWidth[i] + 0.5*heightPhone*vecPhoneHeight[i]
-		initialPhononProjection[i] = -vecPhoneLength[i]
+		axisPolar[i] = -speaker.Normal[i]
 	}
 
 	for gridSpeakerWidth := -0.5 * widthSpeaker; gridSpeakerWidth <= 0.5*widthSpeaker; gridSpeakerWidth += linearResolution {
 		for gridSpeakerHeight := -0.5 * heightSpeaker; gridSpeakerHeight <= 0.5*heightSpeaker; gridSpeakerHeight += linearResolution {
+			select {
+			case <-simulationsCancelled:
+				return nil, errSimulationCancelled
+			case <-options.done():
+				return nil, options.ctx.Err()
+			default:
+			}
+
 			for i := 0; i < 3; i++ {
-				locationSpeaker[i] = initialPhononLocation[i] + gridSpeakerWidth*vecPhoneWidth[i] + gridSpeakerHeight*vecPhoneHeight[i]
+				locationSpeaker[i] = initialPhononLocation[i] + gridSpeakerWidth*speaker.Across[i] + gridSpeakerHeight*speaker.Up[i]
 			}
 
 			for gridAzimuthal := 0.0; gridAzimuthal <= spanAzimuthal; gridAzimuthal += angularResolution {
 				for gridPolar := 0.0; gridPolar <= spanPolar; gridPolar += angularResolution {
 					linalg.Equivalent(locationPhonon, locationSpeaker, 3)
 
-					linalg.Rotation(rotationPolar, vecPhoneLength, gridPolar)
-					linalg.Rotation(rotationAzimuthal, vecPhoneHeight, gridAzimuthal)
+					linalg.Rotation(rotationPolar, axisPolar, gridPolar)
+					linalg.Rotation(rotationAzimuthal, speaker.Up, gridAzimuthal)
 
 					linalg.MatrixMatrixVecMultiply(projectionPhonon, rotationPolar, rotationAzimuthal, initialPhononProjection, 3)
 
+					rayId = metrics.raysEmitted
+					if rays != nil {
+						rays.Origins = append(rays.Origins, locationPhonon[0], locationPhonon[1], locationPhonon[2])
+					}
+
 					atUser = false
-					for !atUser {
+					bounces = 0
+					hitParaboloid = false
+					hitPhone = false
+					for !atUser && bounces < maxRayBounces {
 						aParaboloid = coefficientsParaboloidX*math.Pow(projectionPhonon[0], 2) + coefficientsParaboloidY*math.Pow(projectionPhonon[1], 2)*sqCosAngleParaboloid + coefficientsParaboloidY*math.Pow(projectionPhonon[2], 2)*sqSinAngleParaboloid + 2.0*coefficientsParaboloidY*projectionPhonon[1]*projectionPhonon[2]*cosAngleParaboloid*sinAngleParaboloid
 						bParaboloid = 2*coefficientsParaboloidX*projectionPhonon[0]*locationPhonon[0] + 2.0*coefficientsParaboloidY*projectionPhonon[1]*locationPhonon[1]*sqCosAngleParaboloid + 2.0*coefficientsParaboloidY*projectionPhonon[2]*locationPhonon[2]*sqSinAngleParaboloid + 2.0*coefficientsParaboloidY*projectionPhonon[1]*locationPhonon[2]*cosAngleParaboloid*sinAngleParaboloid + 2.0*coefficientsParaboloidY*projectionPhonon[2]*locationPhonon[1]*cosAngleParaboloid*sinAngleParaboloid + coefficientsParaboloidZ*projectionPhonon[1]*sinAngleParaboloid - coefficientsParaboloidZ*projectionPhonon[2]*cosAngleParaboloid
 						cParaboloid = coefficientsParaboloidX*math.Pow(locationPhonon[0], 2) + coefficientsParaboloidY*math.Pow(locationPhonon[1], 2)*sqCosAngleParaboloid + coefficientsParaboloidY*math.Pow(locationPhonon[2], 2)*sqSinAngleParaboloid + 2.0*coefficientsParaboloidY*locationPhonon[1]*locationPhonon[2]*cosAngleParaboloid*sinAngleParaboloid + coefficientsParaboloidZ*locationPhonon[1]*sinAngleParaboloid - coefficientsParaboloidZ*locationPhonon[2]*cosAngleParaboloid
@@ -339,7 +785,7 @@ func generateSimulation(phoneConfig *PhoneConfig, phoneInput *PhoneInput, parabo
 
 						tSlicingPlane = (heightSlicingPlane*cosAngleSlicingPlane + locationPhonon[1]*cosAngleParaboloid*sinAngleSlicingPlane + locationPhonon[1]*sinAngleParaboloid*cosAngleSlicingPlane - locationPhonon[2]*cosAngleParaboloid*cosAngleSlicingPlane + locationPhonon[2]*sinAngleParaboloid*sinAngleSlicingPlane) / (-projectionPhonon[1]*cosAngleParaboloid*sinAngleSlicingPlane - projectionPhonon[1]*sinAngleParaboloid*cosAngleSlicingPlane + projectionPhonon[2]*cosAngleParaboloid*cosAngleSlicingPlane - projectionPhonon[2]*sinAngleParaboloid*sinAngleSlicingPlane)
 
-						tPhone = (linalg.DotProduct(vecPhoneHeight, cornerPhone, 3) - linalg.DotProduct(vecPhoneHeight, locationPhonon, 3)) / (linalg.DotProduct(vecPhoneHeight, projectionPhonon, 3))
+						tPhone = intersectPhoneBody(normalPhone, phoneConfig, placement, locationPhonon, projectionPhonon, thresholdVal)
 						linalg.Intersection(intersectPhone, locationPhonon, projectionPhonon, tPhone)
 
 						aUser = linalg.DotProduct(projectionPhonon, projectionPhonon, 3)
@@ -348,12 +794,24 @@ func generateSimulation(phoneConfig *PhoneConfig, phoneInput *PhoneInput, parabo
 						tUser = (-bUser + math.Sqrt(math.Pow(bUser, 2)-4*aUser*cUser)) / (2 * aUser)
 						linalg.Intersection(intersectUser, locationPhonon, projectionPhonon, tUser)
 
-						if tPhone > thresholdVal && (tPhone < tParaboloid || tParaboloid <= thresholdVal) && (tPhone < tSlicingPlane || tSlicingPlane <= thresholdVal) && ((xPhone-widthPhone) <= intersectPhone[0] && intersectPhone[0] <= xPhone && yPhone <= intersectPhone[1] && intersectPhone[1] <= (yPhone+lengthPhone*sinAnglePhone)) {
+						if !options.withParaboloid {
+							// without the capsule the ray leaves straight towards the listener sphere
+							tParaboloid = -1
+							tSlicingPlane = tUser
+						}
+
+						if tPhone > thresholdVal && (tPhone < tParaboloid || tParaboloid <= thresholdVal) && (tPhone < tSlicingPlane || tSlicingPlane <= thresholdVal) {
 							linalg.Equivalent(locationPhonon, intersectPhone, 3)
 
-							linalg.Reflect(projectionPhonon, vecPhoneHeight)
+							linalg.Reflect(projectionPhonon, normalPhone)
 
-							phoneVerticies = append(phoneVerticies, locationPhonon[0]/1000, locationPhonon[1]/1000, locationPhonon[2]/1000)
+							phoneVerticies = append(phoneVerticies, locationPhonon[0], locationPhonon[1], locationPhonon[2])
+							bounces++
+							hitPhone = true
+							if rays != nil {
+								rays.PhoneRays = append(rays.PhoneRays, rayId)
+								rays.PhoneBounces = append(rays.PhoneBounces, bounces)
+							}
 						} else if tParaboloid > thresholdVal && (tParaboloid < tSlicingPlane || tSlicingPlane <= thresholdVal) {
 							linalg.Equivalent(locationPhonon, intersectParaboloid, 3)
 
@@ -366,16 +824,27 @@ func generateSimulation(phoneConfig *PhoneConfig, phoneInput *PhoneInput, parabo
 
 							linalg.Reflect(projectionPhonon, vecNormal)
 
-							paraboloidVerticies = append(paraboloidVerticies, locationPhonon[0]/1000, locationPhonon[1]/1000, locationPhonon[2]/1000)
+							paraboloidVerticies = append(paraboloidVerticies, locationPhonon[0], locationPhonon[1], locationPhonon[2])
+							bounces++
+							hitParaboloid = true
+							if rays != nil {
+								rays.ParaboloidRays = append(rays.ParaboloidRays, rayId)
+								rays.ParaboloidBounces = append(rays.ParaboloidBounces, bounces)
+							}
 						} else if tSlicingPlane > thresholdVal {
 							atUser = true
 
 							linalg.Equivalent(locationPhonon, intersectUser, 3)
-							userVerticies = append(userVerticies, locationPhonon[0]/1000, locationPhonon[1]/1000, locationPhonon[2]/1000)
+							userVerticies = append(userVerticies, locationPhonon[0], locationPhonon[1], locationPhonon[2])
+							if rays != nil {
+								rays.UserRays = append(rays.UserRays, rayId)
+								rays.UserBounces = append(rays.UserBounces, bounces+1)
+							}
 						} else {
 							break
 						}
 					}
+					metrics.addRay(bounces, hitParaboloid, hitPhone, atUser)
 
 					if gridAzimuthal == 0 {
 						break
@@ -384,18 +853,72 @@ func generateSimulation(phoneConfig *PhoneConfig, phoneInput *PhoneInput, parabo
 			}
 		}
 	}
-	return phoneVerticies, paraboloidVerticies, userVerticies, nil
+	var simulationOutput SimulationOutput
+	simulationOutput.Phone = phoneVerticies
+	simulationOutput.Paraboloid = paraboloidVerticies
+	simulationOutput.User = userVerticies
+	simulationOutput.Metrics = metrics.finish(userVerticies)
+	simulationOutput.Rays = rays
+
+	return &simulationOutput, nil
+}
+
+// phoneOptionLabel is what the dropdown shows for a phone
+func phoneOptionLabel(option *PhoneOption) string {
+	label := option.Name
+	if option.DisplayName != "" {
+		label = option.DisplayName
+	}
+	if option.Year > 0 {
+		label += fmt.Sprintf(" (%d)", option.Year)
+	}
+	return label
 }
 
+// HandleHtmxGetPhones renders the phone dropdown, grouped by manufacturer.
+// Phones without one, and invalid definitions, go last under "Other".
 func HandleHtmxGetPhones(c *gin.Context) {
-	phoneOptions, err := GetPhones()
+	phoneOptions, err := phoneStore.List()
 	if err != nil {
 		c.String(http.StatusInternalServerError, "")
+		return
 	}
 
-	htmlOptions := ""
+	groups := map[string][]PhoneOption{}
+	manufacturers := []string{}
 	for i := 0; i < len(phoneOptions); i++ {
-		htmlOptions += fmt.Sprintf("<option id='%s' value='%s'>%s</option>", phoneOptions[i].Name, phoneOptions[i].Filename, phoneOptions[i].Name)
+		manufacturer := phoneOptions[i].Manufacturer
+		if _, ok := groups[manufacturer]; !ok && manufacturer != "" {
+			manufacturers = append(manufacturers, manufacturer)
+		}
+		groups[manufacturer] = append(groups[manufacturer], phoneOptions[i])
+	}
+	sort.Strings(manufacturers)
+	if len(groups[""]) > 0 {
+		manufacturers = append(manufacturers, "")
+	}
+
+	htmlOptions := ""
+	for i := 0; i < len(manufacturers); i++ {
+		group := groups[manufacturers[i]]
+		sort.SliceStable(group, func(a, b int) bool {
+			return phoneOptionLabel(&group[a]) < phoneOptionLabel(&group[b])
+		})
+
+		label := manufacturers[i]
+		if label == "" {
+			label = "Other"
+		}
+		htmlOptions += fmt.Sprintf("<optgroup label='%s'>", html.EscapeString(label))
+		for j := 0; j < len(group); j++ {
+			if len(group[j].Errors) > 0 {
+				// broken definitions stay visible so their errors can be read from the tooltip
+				htmlOptions += fmt.Sprintf("<option value='%s' title='%s' disabled>%s (invalid)</option>", html.EscapeString(group[j].Filename), html.EscapeString(group[j].Errors.Error()), html.EscapeString(group[j].Filename))
+				continue
+			}
+			htmlOptions += fmt.Sprintf("<option id='%s' value='%s'>%s</option>", html.EscapeString(group[j].Name), html.EscapeString(group[j].Filename), html.EscapeString(phoneOptionLabel(&group[j])))
+		}
+		htmlOptions += "</optgroup>"
 	}
 
 	c.String(http.StatusOK, htmlOptions)
@@ -403,11 +926,10 @@ func HandleHtmxGetPhones(c *gin.Context) {
 
 func HandleApiPhone(c *gin.Context) {
 	model := c.Query("model")
-	var filepath = model + ".xml"
 
-	phone, err := getPhoneDimensions(filepath)
+	phone, err := phoneStore.Get(model)
 	if err != nil {
-		c.AbortWithStatus(http.StatusTeapot)
+		abortWithPhoneStoreError(c, err)
 		return
 	}
 
@@ -415,47 +937,14 @@ func HandleApiPhone(c *gin.Context) {
 }
 
 func HandleApiGetPhones(c *gin.Context) {
-	phoneOptions, err := GetPhones()
+	phoneOptions, err := phoneStore.List()
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
+		return
 	}
 	c.JSON(http.StatusOK, phoneOptions)
 }
 
-func GetPhones() ([]PhoneOption, error) {
-	dir, err := os.ReadDir("phones")
-
-	if err != nil {
-		return nil, err
-	}
-
-	var phoneOptions []PhoneOption
-	for i := 0; i < len(dir); i++ {
-		if dir[i].IsDir() {
-			// WHY?
-			continue
-		}
-
-		if strings.Contains(dir[i].Name(), ".xml") {
-			var option PhoneOption
-			option.Filename = dir[i].Name()
-			option.Name = strings.Replace(dir[i].Name(), ".xml", "", -1)
-			phoneOptions = append(phoneOptions, option)
-		}
-	}
-	return phoneOptions, nil
-}
-
-func getPhoneDimensions(filepath string) (*PhoneConfig, error) {
-	phone, err := parseXml("phones/" + filepath)
-	if err != nil {
-		return nil, err
-	}
-
-	return phone, nil
-
-}
-
 func GetVerticies(c *gin.Context) {
 	numVerticies, err := strconv.Atoi(c.Query("numVerticies"))
 	if err != nil {
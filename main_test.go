@@ -0,0 +1,206 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// testPhoneConfig returns the dimensions of phones/iPhone5.xml
+func testPhoneConfig() *PhoneConfig {
+	return &PhoneConfig{
+		DisplayName:  "iPhone 5",
+		Manufacturer: "Apple",
+		Year:         2012,
+		Width:        58.57,
+		Length:       123.83,
+		Height:       7.12,
+		Speaker:      SpeakerConfig{Width: 11.96, Height: 3.36, Center: 44.495},
+	}
+}
+
+// testSimulationInput returns the default input of the UI
+func testSimulationInput() SimulationInput {
+	var simulationInput SimulationInput
+	simulationInput.Phone = PhoneInput{Filename: "iPhone5.xml", Angle: 5, AngleUnits: "deg"}
+	simulationInput.Paraboloid = ParaboloidInput{X: 0.0170794, Y: 0.0170794, Z: 1, Angle: 45, AngleUnits: "deg"}
+	simulationInput.SlicingPlane = SlicingPlaneInput{Height: 15, HeightUnits: "cm", Angle: 30, AngleUnits: "deg"}
+	simulationInput.UserRadius = UserRadiusInput{Radius: 1, RadiusUnits: "m"}
+	simulationInput.Resolution = ResolutionInput{Linear: 0.5, Angular: 0.1}
+	return simulationInput
+}
+
+func TestPhoneBody(t *testing.T) {
+	phoneConfig := testPhoneConfig()
+	body := phoneConfig.body()
+	if *body != *phoneConfig {
+		t.Errorf("without a case got %+v", *body)
+	}
+
+	phoneConfig.CaseOffset = 1.5
+	phoneConfig.CornerRadius = 8
+	body = phoneConfig.body()
+	if body.Width != 61.57 || body.Length != 126.83 || math.Abs(body.Height-10.12) > 1e-12 || body.CornerRadius != 9.5 || body.CaseOffset != 0 {
+		t.Errorf("got body %+v", *body)
+	}
+	if body.Speaker.Center != 45.995 || body.Speaker.Offset != 0 {
+		t.Errorf("got speaker %+v on the bottom edge", body.Speaker)
+	}
+
+	phoneConfig.Speaker.Face = speakerFaceBack
+	phoneConfig.Speaker.Offset = 20
+	body = phoneConfig.body()
+	if body.Speaker.Offset != 21.5 {
+		t.Errorf("got speaker offset %g on the back face, want 21.5", body.Speaker.Offset)
+	}
+}
+
+func TestInsideRoundedRect(t *testing.T) {
+	tests := []struct {
+		s, t float64
+		want bool
+	}{
+		{s: 5, t: 5, want: true},
+		{s: 0, t: 5, want: true},
+		{s: -0.1, t: 5, want: false},
+		{s: 10.1, t: 5, want: false},
+		// the corner is cut off by the radius 2 arc centred on (2, 2)
+		{s: 0.2, t: 0.2, want: false},
+		{s: 0.6, t: 0.6, want: true},
+		{s: 9.8, t: 19.8, want: false},
+		{s: 2, t: 0, want: true},
+	}
+	for i := 0; i < len(tests); i++ {
+		got := insideRoundedRect(tests[i].s, tests[i].t, 10, 20, 2)
+		if got != tests[i].want {
+			t.Errorf("insideRoundedRect(%g, %g) = %v, want %v", tests[i].s, tests[i].t, got, tests[i].want)
+		}
+	}
+}
+
+func TestPlaceSpeaker(t *testing.T) {
+	simulationInput := testSimulationInput()
+	phoneConfig := testPhoneConfig()
+	phoneConfig.Speaker.Offset = 30
+	placement := placePhone(phoneConfig, normalizedInput(&simulationInput))
+
+	// each face's normal as a multiple of the placement axes, and the centre
+	// as offsets along them from the corner
+	tests := []struct {
+		face   string
+		normal [3]float64
+		center [3]float64
+	}{
+		{face: "", normal: [3]float64{0, -1, 0}, center: [3]float64{-44.495, 0, 3.56}},
+		{face: speakerFaceTop, normal: [3]float64{0, 1, 0}, center: [3]float64{-44.495, 123.83, 3.56}},
+		{face: speakerFaceRight, normal: [3]float64{1, 0, 0}, center: [3]float64{0, 44.495, 3.56}},
+		{face: speakerFaceLeft, normal: [3]float64{-1, 0, 0}, center: [3]float64{-58.57, 44.495, 3.56}},
+		{face: speakerFaceFront, normal: [3]float64{0, 0, 1}, center: [3]float64{-44.495, 30, 7.12}},
+		{face: speakerFaceBack, normal: [3]float64{0, 0, -1}, center: [3]float64{-44.495, 30, 0}},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.face, func(t *testing.T) {
+			phoneConfig.Speaker.Face = test.face
+			frame := placeSpeaker(phoneConfig, placement)
+			for j := 0; j < 3; j++ {
+				normal := test.normal[0]*placement.Width[j] + test.normal[1]*placement.Length[j] + test.normal[2]*placement.Height[j]
+				center := placement.Corner[j] + test.center[0]*placement.Width[j] + test.center[1]*placement.Length[j] + test.center[2]*placement.Height[j]
+				if math.Abs(frame.Normal[j]-normal) > 1e-12 || math.Abs(frame.Center[j]-center) > 1e-9 {
+					t.Fatalf("got normal %v and centre %v, want %v and %v", frame.Normal, frame.Center, test.normal, test.center)
+				}
+			}
+		})
+	}
+}
+
+func TestIntersectPhoneBody(t *testing.T) {
+	// the body spans 0..10 in x, 0..20 in y and 0..2 in z, so normals come
+	// out in world coordinates
+	phoneConfig := &PhoneConfig{Width: 10, Length: 20, Height: 2, CornerRadius: 2}
+	placement := phonePlacement{
+		Corner: []float64{0, 0, 0},
+		Width:  []float64{-1, 0, 0},
+		Length: []float64{0, 1, 0},
+		Height: []float64{0, 0, 1},
+	}
+	diagonal := 1 / math.Sqrt2
+
+	tests := []struct {
+		name      string
+		location  []float64
+		direction []float64
+		distance  float64
+		normal    []float64
+	}{
+		{name: "front", location: []float64{5, 10, 5}, direction: []float64{0, 0, -1}, distance: 3, normal: []float64{0, 0, 1}},
+		{name: "front at an angle", location: []float64{5, 10, 5}, direction: []float64{0.6, 0, -0.8}, distance: 3.75, normal: []float64{0, 0, 1}},
+		{name: "back", location: []float64{5, 10, -4}, direction: []float64{0, 0, 1}, distance: 4, normal: []float64{0, 0, -1}},
+		{name: "left edge", location: []float64{-3, 10, 1}, direction: []float64{1, 0, 0}, distance: 3, normal: []float64{-1, 0, 0}},
+		{name: "right edge", location: []float64{13, 10, 1}, direction: []float64{-1, 0, 0}, distance: 3, normal: []float64{1, 0, 0}},
+		{name: "speaker end", location: []float64{5, -2, 1}, direction: []float64{0, 1, 0}, distance: 2, normal: []float64{0, -1, 0}},
+		{name: "far end", location: []float64{5, 25, 1}, direction: []float64{0, -1, 0}, distance: 5, normal: []float64{0, 1, 0}},
+		{name: "rounded corner", location: []float64{2 - 10*diagonal, 2 - 10*diagonal, 1}, direction: []float64{diagonal, diagonal, 0}, distance: 8, normal: []float64{-diagonal, -diagonal, 0}},
+		{name: "far rounded corner", location: []float64{8 + 10*diagonal, 18 + 10*diagonal, 1}, direction: []float64{-diagonal, -diagonal, 0}, distance: 8, normal: []float64{diagonal, diagonal, 0}},
+		{name: "through the cut corner", location: []float64{0.2, 0.2, 5}, direction: []float64{0, 0, -1}, distance: -1},
+		{name: "grazing past the front", location: []float64{5, -5, 2.001}, direction: []float64{0, 1, 0}, distance: -1},
+		{name: "leaving the front", location: []float64{5, 10, 2}, direction: []float64{0, 0, 1}, distance: -1},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			normal := []float64{0, 0, 0}
+			distance := intersectPhoneBody(normal, phoneConfig, placement, test.location, test.direction, 1e-9)
+			if math.Abs(distance-test.distance) > 1e-9 {
+				t.Fatalf("got distance %g, want %g", distance, test.distance)
+			}
+			if test.distance < 0 {
+				return
+			}
+			for j := 0; j < 3; j++ {
+				if math.Abs(normal[j]-test.normal[j]) > 1e-9 {
+					t.Fatalf("got normal %v, want %v", normal, test.normal)
+				}
+			}
+		})
+	}
+}
+
+// TestGenerateSimulationGolden guards the tracer's results for the default
+// iPhone input against unintended changes. Run with -update after a change
+// that is meant to move them.
+func TestGenerateSimulationGolden(t *testing.T) {
+	simulationInput := testSimulationInput()
+	output, err := generateSimulation(testPhoneConfig(), normalizedInput(&simulationInput), simulationOptions{withParaboloid: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := output.Metrics
+	var got strings.Builder
+	fmt.Fprintf(&got, "phone hits %d\nparaboloid hits %d\nuser hits %d\n", len(output.Phone)/3, len(output.Paraboloid)/3, len(output.User)/3)
+	fmt.Fprintf(&got, "rays emitted %d\nrays at user %d\nrays lost %d\n", metrics.RaysEmitted, metrics.RaysAtUser, metrics.RaysLost)
+	fmt.Fprintf(&got, "mean bounces %.9g\nmax bounces %d\nbounce histogram %v\n", metrics.MeanBounces, metrics.MaxBounces, metrics.BounceHistogram)
+	fmt.Fprintf(&got, "paraboloid fraction %.9g\nphone rehit fraction %.9g\n", metrics.ParaboloidFraction, metrics.PhoneRehitFraction)
+	fmt.Fprintf(&got, "angular spread %.9g\ncentroid direction %.9g\n", metrics.AngularSpread, metrics.CentroidDirection)
+
+	goldenPath := filepath.Join("testdata", "iPhone5.golden")
+	if *updateGolden {
+		err = os.WriteFile(goldenPath, []byte(got.String()), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != string(want) {
+		t.Errorf("got\n%s\nwant\n%s", got.String(), want)
+	}
+}
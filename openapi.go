@@ -0,0 +1,253 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type openApiBuilder struct {
+	schemas map[string]any
+}
+
+var openApiDocument map[string]any
+var openApiOnce sync.Once
+
+// schemaRef returns the schema for t, registering named struct types under
+// components/schemas and referring to them by name
+func (builder *openApiBuilder) schemaRef(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": builder.schemaRef(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": builder.schemaRef(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return builder.structSchema(t)
+		}
+		_, ok := builder.schemas[t.Name()]
+		if !ok {
+			// reserve the name first so recursive types terminate
+			builder.schemas[t.Name()] = nil
+			builder.schemas[t.Name()] = builder.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+
+	return map[string]any{}
+}
+
+func (builder *openApiBuilder) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := field.Name
+		omitEmpty := false
+		tag, ok := field.Tag.Lookup("json")
+		if ok {
+			parts := strings.Split(tag, ",")
+			if parts[0] == "-" {
+				continue
+			}
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for j := 1; j < len(parts); j++ {
+				if parts[j] == "omitempty" || parts[j] == "omitzero" {
+					omitEmpty = true
+				}
+			}
+		}
+
+		properties[name] = builder.schemaRef(field.Type)
+		if _, ok := parseQuantityTag(field); ok {
+			properties[name] = quantitySchema(field)
+		}
+		if !omitEmpty && field.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]any{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// openApiPath converts gin's :param syntax into OpenAPI's {param}
+func openApiPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i := 0; i < len(segments); i++ {
+		if strings.HasPrefix(segments[i], ":") || strings.HasPrefix(segments[i], "*") {
+			segments[i] = "{" + segments[i][1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// openApiOperationId derives ids such as getRunsById from the method and path
+func openApiOperationId(method string, path string) string {
+	operationId := strings.ToLower(method)
+	segments := strings.Split(path, "/")
+	for i := 0; i < len(segments); i++ {
+		segment := segments[i]
+		if segment == "" {
+			continue
+		}
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			operationId += "By"
+			segment = segment[1:]
+		}
+		operationId += strings.ToUpper(segment[:1]) + segment[1:]
+	}
+	return operationId
+}
+
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{
+		"application/json": map[string]any{"schema": schema},
+	}
+}
+
+func buildOpenApiDocument(routes []ApiRoute) map[string]any {
+	builder := &openApiBuilder{schemas: map[string]any{}}
+	validationErrorSchema := builder.schemaRef(reflect.TypeOf(ValidationError{}))
+
+	paths := map[string]any{}
+	for i := 0; i < len(routes); i++ {
+		route := routes[i]
+
+		parameters := []any{}
+		for j := 0; j < len(route.Parameters); j++ {
+			parameter := map[string]any{
+				"name":     route.Parameters[j].Name,
+				"in":       route.Parameters[j].In,
+				"required": route.Parameters[j].Required || route.Parameters[j].In == "path",
+				"schema":   map[string]any{"type": route.Parameters[j].Type},
+			}
+			if route.Parameters[j].Description != "" {
+				parameter["description"] = route.Parameters[j].Description
+			}
+			parameters = append(parameters, parameter)
+		}
+
+		contentType := route.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+
+		var okContent map[string]any
+		if route.Response != nil {
+			okContent = map[string]any{
+				contentType: map[string]any{"schema": builder.schemaRef(reflect.TypeOf(route.Response))},
+			}
+		} else {
+			okContent = map[string]any{
+				contentType: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+			}
+		}
+
+		status := route.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]any{"description": http.StatusText(status)}
+		if status != http.StatusNoContent {
+			success["content"] = okContent
+		}
+		responses := map[string]any{
+			strconv.Itoa(status): success,
+			"400":                map[string]any{"description": "Invalid request", "content": jsonContent(validationErrorSchema)},
+		}
+		for j := 0; j < len(route.Errors); j++ {
+			response := map[string]any{"description": route.Errors[j].Description}
+			if route.Errors[j].Response != nil {
+				response["content"] = jsonContent(builder.schemaRef(reflect.TypeOf(route.Errors[j].Response)))
+			}
+			responses[strconv.Itoa(route.Errors[j].Status)] = response
+		}
+
+		operation := map[string]any{
+			"summary":     route.Summary,
+			"operationId": openApiOperationId(route.Method, route.Path),
+			"parameters":  parameters,
+			"responses":   responses,
+		}
+		if route.Request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content":  jsonContent(builder.schemaRef(reflect.TypeOf(route.Request))),
+			}
+		} else if route.RequestContentType != "" {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					route.RequestContentType: map[string]any{"schema": map[string]any{"type": "string"}},
+				},
+			}
+		}
+
+		path := openApiPath(apiVersionPath + route.Path)
+		item, ok := paths[path].(map[string]any)
+		if !ok {
+			item = map[string]any{}
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = operation
+	}
+
+	document := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Amphora",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": builder.schemas,
+		},
+	}
+	// paths are relative to the server, which sits under the base path
+	if basePath != "" {
+		document["servers"] = []any{map[string]any{"url": basePath}}
+	}
+	return document
+}
+
+func HandleApiOpenApi(c *gin.Context) {
+	openApiOnce.Do(func() {
+		openApiDocument = buildOpenApiDocument(apiRoutes)
+	})
+	c.JSON(http.StatusOK, openApiDocument)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestBuildOpenApiDocumentResponses(t *testing.T) {
+	document := buildOpenApiDocument(apiRoutes)
+	paths := document["paths"].(map[string]any)
+
+	tests := []struct {
+		path   string
+		method string
+		// every documented status, and those that come with a body
+		statuses []string
+		bodies   []string
+	}{
+		{path: "/api/v1/phone", method: "get", statuses: []string{"200", "400", "404"}, bodies: []string{"200", "400"}},
+		{path: "/api/v1/phones/{id}", method: "post", statuses: []string{"201", "400", "403", "409"}, bodies: []string{"201", "400", "409"}},
+		{path: "/api/v1/phones/{id}", method: "delete", statuses: []string{"204", "400", "403", "404"}, bodies: []string{"400"}},
+		{path: "/api/v1/simulation", method: "post", statuses: []string{"200", "400", "503"}, bodies: []string{"200", "400"}},
+		{path: "/api/v1/runs/{id}/export/{format}", method: "get", statuses: []string{"200", "400", "404", "503"}, bodies: []string{"200", "400"}},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			operation := paths[test.path].(map[string]any)[test.method].(map[string]any)
+			responses := operation["responses"].(map[string]any)
+			statuses := []string{}
+			bodies := []string{}
+			for status, response := range responses {
+				statuses = append(statuses, status)
+				if _, ok := response.(map[string]any)["content"]; ok {
+					bodies = append(bodies, status)
+				}
+			}
+			sort.Strings(statuses)
+			sort.Strings(bodies)
+			if strings.Join(statuses, ",") != strings.Join(test.statuses, ",") {
+				t.Errorf("got statuses %v, want %v", statuses, test.statuses)
+			}
+			if strings.Join(bodies, ",") != strings.Join(test.bodies, ",") {
+				t.Errorf("got bodies for %v, want %v", bodies, test.bodies)
+			}
+		})
+	}
+}
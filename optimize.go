@@ -0,0 +1,341 @@
+package main
+
+import (
+	"amphora/pkg/optimize"
+	"amphora/pkg/units"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var maxOptimizeEvaluations = 500
+
+// only the reflector geometry is searched, the phone and listener stay fixed
+var optimizeParameters = []string{
+	"paraboloid.x",
+	"paraboloid.y",
+	"paraboloid.z",
+	"paraboloid.angle",
+	"slicingPlane.height",
+	"slicingPlane.angle",
+}
+
+// optimizeObjective scores a simulation, higher is better
+type optimizeObjective func(optimizeInput *OptimizeInput, simulationInput *SimulationInput, output *SimulationOutput) float64
+
+var optimizeObjectives = map[string]optimizeObjective{
+	// fraction of emitted rays that reach the listener sphere
+	"userEnergy": func(optimizeInput *OptimizeInput, simulationInput *SimulationInput, output *SimulationOutput) float64 {
+		if output.Metrics.RaysEmitted == 0 {
+			return 0
+		}
+		return float64(output.Metrics.RaysAtUser) / float64(output.Metrics.RaysEmitted)
+	},
+	// fraction of emitted rays that reach the listener sphere within the cone
+	// around the paraboloid axis
+	"onAxis": func(optimizeInput *OptimizeInput, simulationInput *SimulationInput, output *SimulationOutput) float64 {
+		if output.Metrics.RaysEmitted == 0 {
+			return 0
+		}
+
+		axis := paraboloidFrame(normalizedInput(simulationInput).ParaboloidAngle)[2]
+		coneAngle, _ := units.NewAngle(optimizeInput.ConeAngle, optimizeInput.ConeAngleUnits)
+		cosCone := math.Cos(coneAngle.Radians())
+		return float64(countWithinCone(output.User, axis, cosCone)) / float64(output.Metrics.RaysEmitted)
+	},
+}
+
+type OptimizeBound struct {
+	Path  string  `json:"path"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Units string  `json:"units,omitempty"`
+}
+
+type OptimizeInput struct {
+	Base           SimulationInput `json:"base"`
+	Bounds         []OptimizeBound `json:"bounds"`
+	Objective      string          `json:"objective"`
+	ConeAngle      float64         `json:"coneAngle,omitempty" quantity:"angle,ConeAngleUnits"`
+	ConeAngleUnits string          `json:"coneAngleUnits,omitempty"`
+	MaxEvaluations int             `json:"maxEvaluations,omitempty"`
+	Tolerance      float64         `json:"tolerance,omitempty"`
+}
+
+type OptimizeEvaluation struct {
+	Evaluation int                `json:"evaluation"`
+	Values     map[string]float64 `json:"values"`
+	Valid      bool               `json:"valid"`
+	Score      float64            `json:"score"`
+}
+
+type OptimizeOutput struct {
+	Best        SimulationInput      `json:"best"`
+	BestValues  map[string]float64   `json:"bestValues"`
+	BestScore   float64              `json:"bestScore"`
+	Evaluations int                  `json:"evaluations"`
+	Iterations  int                  `json:"iterations"`
+	Trace       []OptimizeEvaluation `json:"trace"`
+}
+
+func (optimizeInput *OptimizeInput) UnmarshalJSON(data []byte) error {
+	type plain OptimizeInput
+	return decodeQuantities(data, (*plain)(optimizeInput))
+}
+
+func optimizeObjectiveNames() []string {
+	names := make([]string, 0, len(optimizeObjectives))
+	for name := range optimizeObjectives {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func applyOptimizeDefaults(optimizeInput *OptimizeInput) {
+	if optimizeInput.ConeAngle == 0 {
+		optimizeInput.ConeAngle = 15
+		optimizeInput.ConeAngleUnits = "deg"
+	}
+	if optimizeInput.MaxEvaluations == 0 {
+		optimizeInput.MaxEvaluations = 100
+	}
+	if optimizeInput.Tolerance == 0 {
+		optimizeInput.Tolerance = 1e-4
+	}
+}
+
+func validateOptimizeInput(optimizeInput *OptimizeInput) *ValidationError {
+	validationError := &ValidationError{Errors: []FieldError{}}
+
+	_, ok := optimizeObjectives[optimizeInput.Objective]
+	if !ok {
+		validationError.Add("objective", "unknown objective %q, expected one of %s", optimizeInput.Objective, strings.Join(optimizeObjectiveNames(), ", "))
+	}
+
+	if validateUnit(validationError, "coneAngleUnits", optimizeInput.ConeAngleUnits, units.KindAngle) {
+		validatePositive(validationError, "coneAngle", optimizeInput.ConeAngle)
+	}
+
+	if optimizeInput.MaxEvaluations < 1 || optimizeInput.MaxEvaluations > maxOptimizeEvaluations {
+		validationError.Add("maxEvaluations", "must be between 1 and %d", maxOptimizeEvaluations)
+	}
+	validatePositive(validationError, "tolerance", optimizeInput.Tolerance)
+
+	if len(optimizeInput.Bounds) == 0 {
+		validationError.Add("bounds", "at least one bound is required")
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < len(optimizeInput.Bounds); i++ {
+		field := fmt.Sprintf("bounds[%d]", i)
+		bound := optimizeInput.Bounds[i]
+
+		if !isOneOf(bound.Path, optimizeParameters) {
+			validationError.Add(field+".path", "unknown parameter %q, expected one of %s", bound.Path, strings.Join(optimizeParameters, ", "))
+			continue
+		}
+		if seen[bound.Path] {
+			validationError.Add(field+".path", "parameter %q is bounded more than once", bound.Path)
+			continue
+		}
+		seen[bound.Path] = true
+		validateParameterUnits(validationError, field+".units", bound.Path, bound.Units)
+
+		if !(bound.Min < bound.Max) {
+			validationError.Add(field+".max", "must be greater than min")
+		}
+	}
+
+	return validationError
+}
+
+// convertParameter re-expresses val, given in unit from, in unit to
+func convertParameter(val float64, from string, to string, kind units.Kind) (float64, error) {
+	if kind == units.KindAngle {
+		angle, err := units.NewAngle(val, from)
+		if err != nil {
+			return 0, err
+		}
+		return angle.In(to)
+	}
+	length, err := units.NewLength(val, from)
+	if err != nil {
+		return 0, err
+	}
+	return length.In(to)
+}
+
+// optimizeStart returns the base input's position in the unit cube spanned by
+// the bounds, converting it into a bound's units when they differ, and
+// falling back to the centre for values outside the bounds
+func optimizeStart(optimizeInput *OptimizeInput) []float64 {
+	base := map[string]float64{
+		"paraboloid.x":        optimizeInput.Base.Paraboloid.X,
+		"paraboloid.y":        optimizeInput.Base.Paraboloid.Y,
+		"paraboloid.z":        optimizeInput.Base.Paraboloid.Z,
+		"paraboloid.angle":    optimizeInput.Base.Paraboloid.Angle,
+		"slicingPlane.height": optimizeInput.Base.SlicingPlane.Height,
+		"slicingPlane.angle":  optimizeInput.Base.SlicingPlane.Angle,
+	}
+	baseUnits := map[string]string{
+		"paraboloid.angle":    optimizeInput.Base.Paraboloid.AngleUnits,
+		"slicingPlane.height": optimizeInput.Base.SlicingPlane.HeightUnits,
+		"slicingPlane.angle":  optimizeInput.Base.SlicingPlane.AngleUnits,
+	}
+
+	x0 := make([]float64, len(optimizeInput.Bounds))
+	for i := 0; i < len(optimizeInput.Bounds); i++ {
+		bound := optimizeInput.Bounds[i]
+		val := base[bound.Path]
+		var err error
+		if bound.Units != "" && bound.Units != baseUnits[bound.Path] {
+			val, err = convertParameter(val, baseUnits[bound.Path], bound.Units, simulationParameterKinds[bound.Path])
+		}
+		t := (val - bound.Min) / (bound.Max - bound.Min)
+		if err != nil || t < 0 || t > 1 {
+			t = 0.5
+		}
+		x0[i] = t
+	}
+	return x0
+}
+
+// optimizeCandidate maps a point of the unit cube onto the bounds
+func optimizeCandidate(optimizeInput *OptimizeInput, x []float64) (SimulationInput, map[string]float64) {
+	candidate := optimizeInput.Base
+	values := make(map[string]float64, len(optimizeInput.Bounds))
+	for i := 0; i < len(optimizeInput.Bounds); i++ {
+		bound := optimizeInput.Bounds[i]
+		t := math.Min(math.Max(x[i], 0), 1)
+		val := bound.Min + t*(bound.Max-bound.Min)
+		simulationParameters[bound.Path](&candidate, val, bound.Units)
+		values[bound.Path] = val
+	}
+	return candidate, values
+}
+
+// runOptimizer searches the bounds for the best scoring design. It stops
+// early once a simulation fails or ctx is done. A non-nil ValidationError
+// means no point within the bounds could be simulated.
+func runOptimizer(ctx context.Context, phoneConfig *PhoneConfig, optimizeInput *OptimizeInput) (*OptimizeOutput, *ValidationError, error) {
+	objective := optimizeObjectives[optimizeInput.Objective]
+	trace := make([]OptimizeEvaluation, 0, optimizeInput.MaxEvaluations)
+
+	var simulationErr error
+	var infeasible *ValidationError
+	f := func(x []float64) float64 {
+		candidate, values := optimizeCandidate(optimizeInput, x)
+		evaluation := OptimizeEvaluation{Evaluation: len(trace) + 1, Values: values}
+
+		if simulationErr == nil {
+			simulationErr = ctx.Err()
+		}
+		if simulationErr != nil {
+			trace = append(trace, evaluation)
+			return math.Inf(1)
+		}
+
+		// infeasible geometry is scored as the worst possible value rather
+		// than aborting the search
+		candidateError := validateSimulationInput(&candidate, phoneConfig)
+		if !candidateError.Empty() {
+			if infeasible == nil {
+				infeasible = candidateError
+			}
+			trace = append(trace, evaluation)
+			return math.Inf(1)
+		}
+
+		output, _, err := runWorldSimulation(ctx, phoneConfig, &candidate)
+		if err != nil {
+			simulationErr = err
+			trace = append(trace, evaluation)
+			return math.Inf(1)
+		}
+
+		evaluation.Valid = true
+		evaluation.Score = objective(optimizeInput, &candidate, output)
+		trace = append(trace, evaluation)
+		return -evaluation.Score
+	}
+
+	settings := optimize.Settings{
+		MaxEvaluations: optimizeInput.MaxEvaluations,
+		Tolerance:      optimizeInput.Tolerance,
+		InitialStep:    0.25,
+	}
+	result := optimize.NelderMead(f, optimizeStart(optimizeInput), settings)
+	if simulationErr != nil {
+		return nil, nil, simulationErr
+	}
+	if math.IsInf(result.F, 1) {
+		validationError := &ValidationError{Errors: []FieldError{}}
+		validationError.Add("bounds", "none of the %d points evaluated could be simulated", result.Evaluations)
+		for i := 0; infeasible != nil && i < len(infeasible.Errors); i++ {
+			validationError.Add("base."+infeasible.Errors[i].Field, "first point: %s", infeasible.Errors[i].Message)
+		}
+		return nil, validationError, nil
+	}
+
+	best, bestValues := optimizeCandidate(optimizeInput, result.X)
+
+	var optimizeOutput OptimizeOutput
+	optimizeOutput.Best = best
+	optimizeOutput.BestValues = bestValues
+	optimizeOutput.BestScore = -result.F
+	optimizeOutput.Evaluations = result.Evaluations
+	optimizeOutput.Iterations = result.Iterations
+	optimizeOutput.Trace = trace
+
+	return &optimizeOutput, nil, nil
+}
+
+func HandleApiOptimize(c *gin.Context) {
+	var optimizeInput OptimizeInput
+	err := c.ShouldBindJSON(&optimizeInput)
+	if err != nil {
+		validationError := &ValidationError{}
+		validationError.Add("body", "malformed request body: %v", err)
+		abortWithValidationError(c, validationError)
+		return
+	}
+
+	applyOptimizeDefaults(&optimizeInput)
+	validationError := validateOptimizeInput(&optimizeInput)
+	if !validationError.Empty() {
+		abortWithValidationError(c, validationError)
+		return
+	}
+
+	phoneConfig := lookupPhoneConfig(optimizeInput.Base.Phone.Filename)
+
+	// the starting point has to be simulatable, otherwise the search has
+	// nothing to move away from
+	start, _ := optimizeCandidate(&optimizeInput, optimizeStart(&optimizeInput))
+	baseError := validateSimulationInput(&start, phoneConfig)
+	for i := 0; i < len(baseError.Errors); i++ {
+		validationError.Add("base."+baseError.Errors[i].Field, "%s", baseError.Errors[i].Message)
+	}
+	if !validationError.Empty() {
+		abortWithValidationError(c, validationError)
+		return
+	}
+
+	optimizeOutput, validationError, err := runOptimizer(c.Request.Context(), phoneConfig, &optimizeInput)
+	if validationError != nil {
+		abortWithValidationError(c, validationError)
+		return
+	}
+	if err != nil {
+		abortWithSimulationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, optimizeOutput)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+)
+
+// testOptimizeInput returns a valid search over two reflector parameters
+func testOptimizeInput() OptimizeInput {
+	optimizeInput := OptimizeInput{Base: testSimulationInput(), Objective: "userEnergy"}
+	optimizeInput.Bounds = []OptimizeBound{
+		{Path: "paraboloid.angle", Min: 30, Max: 60},
+		{Path: "slicingPlane.height", Min: 10, Max: 20},
+	}
+	applyOptimizeDefaults(&optimizeInput)
+	return optimizeInput
+}
+
+func TestValidateOptimizeInput(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(optimizeInput *OptimizeInput)
+		// fields expected to be reported, in order
+		fields []string
+	}{
+		{name: "valid", change: func(*OptimizeInput) {}},
+		{name: "unknown objective", fields: []string{"objective"}, change: func(optimizeInput *OptimizeInput) {
+			optimizeInput.Objective = "loudness"
+		}},
+		{name: "too many evaluations", fields: []string{"maxEvaluations"}, change: func(optimizeInput *OptimizeInput) {
+			optimizeInput.MaxEvaluations = maxOptimizeEvaluations + 1
+		}},
+		{name: "no bounds", fields: []string{"bounds"}, change: func(optimizeInput *OptimizeInput) {
+			optimizeInput.Bounds = nil
+		}},
+		{name: "phone is not searched", fields: []string{"bounds[0].path"}, change: func(optimizeInput *OptimizeInput) {
+			optimizeInput.Bounds[0].Path = "phone.angle"
+		}},
+		{name: "duplicate bound", fields: []string{"bounds[1].path"}, change: func(optimizeInput *OptimizeInput) {
+			optimizeInput.Bounds[1].Path = optimizeInput.Bounds[0].Path
+		}},
+		{name: "empty bound", fields: []string{"bounds[1].max"}, change: func(optimizeInput *OptimizeInput) {
+			optimizeInput.Bounds[1].Max = optimizeInput.Bounds[1].Min
+		}},
+		{name: "units on a coefficient", fields: []string{"bounds[0].units"}, change: func(optimizeInput *OptimizeInput) {
+			optimizeInput.Bounds[0] = OptimizeBound{Path: "paraboloid.x", Min: 0.01, Max: 0.02, Units: "mm"}
+		}},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			optimizeInput := testOptimizeInput()
+			test.change(&optimizeInput)
+			validationError := validateOptimizeInput(&optimizeInput)
+			fields := []string{}
+			for j := 0; j < len(validationError.Errors); j++ {
+				fields = append(fields, validationError.Errors[j].Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(test.fields, ",") {
+				t.Errorf("got errors %+v, want them on %v", validationError.Errors, test.fields)
+			}
+		})
+	}
+}
+
+func TestOptimizeStartAndCandidate(t *testing.T) {
+	optimizeInput := testOptimizeInput()
+	x0 := optimizeStart(&optimizeInput)
+	// the base design sits at 45° and 15 cm, half way through both bounds
+	if len(x0) != 2 || math.Abs(x0[0]-0.5) > 1e-12 || math.Abs(x0[1]-0.5) > 1e-12 {
+		t.Errorf("got start %v, want [0.5 0.5]", x0)
+	}
+
+	// points outside the unit cube are clamped onto the bounds
+	candidate, values := optimizeCandidate(&optimizeInput, []float64{-1, 0.25})
+	if candidate.Paraboloid.Angle != 30 || values["paraboloid.angle"] != 30 {
+		t.Errorf("got paraboloid angle %g, want 30", candidate.Paraboloid.Angle)
+	}
+	if candidate.SlicingPlane.Height != 12.5 || values["slicingPlane.height"] != 12.5 {
+		t.Errorf("got slicing plane height %g, want 12.5", candidate.SlicingPlane.Height)
+	}
+	if optimizeInput.Base.Paraboloid.Angle != 45 {
+		t.Error("optimizeCandidate changed the base input")
+	}
+
+	// bounds in other units start from the converted base value
+	optimizeInput.Bounds[0] = OptimizeBound{Path: "paraboloid.angle", Min: 0, Max: math.Pi / 2, Units: "rad"}
+	optimizeInput.Bounds[1] = OptimizeBound{Path: "slicingPlane.height", Min: 100, Max: 250, Units: "mm"}
+	x0 = optimizeStart(&optimizeInput)
+	if math.Abs(x0[0]-0.5) > 1e-12 || math.Abs(x0[1]-1.0/3) > 1e-12 {
+		t.Errorf("got start %v, want [0.5 0.333]", x0)
+	}
+}
+
+func TestRunOptimizerInfeasible(t *testing.T) {
+	optimizeInput := testOptimizeInput()
+	optimizeInput.MaxEvaluations = 5
+	optimizeInput.Bounds = []OptimizeBound{{Path: "paraboloid.x", Min: -2, Max: -1}}
+	optimizeOutput, validationError, err := runOptimizer(context.Background(), testPhoneConfig(), &optimizeInput)
+	if err != nil || optimizeOutput != nil {
+		t.Fatalf("got %+v, %v", optimizeOutput, err)
+	}
+	if validationError == nil || validationError.Errors[0].Field != "bounds" || len(validationError.Errors) < 2 || validationError.Errors[1].Field != "base.paraboloid.x" {
+		t.Errorf("got %+v, want the bounds and the first point's errors", validationError)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = runOptimizer(ctx, testPhoneConfig(), &optimizeInput)
+	if err != context.Canceled {
+		t.Errorf("got %v once the client went away, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"amphora/pkg/linalg"
+	"amphora/pkg/units"
+	"fmt"
+	"strings"
+)
+
+const (
+	outputFrameWorld      = "world"
+	outputFrameParaboloid = "paraboloid"
+	outputFramePhone      = "phone"
+)
+
+var outputFrames = []string{outputFrameWorld, outputFrameParaboloid, outputFramePhone}
+
+// OutputInput chooses the coordinates vertices are reported in. The world
+// frame has its origin at the paraboloid apex. The paraboloid frame shares
+// that origin with z along the axis the paraboloid opens along. The phone
+// frame has its origin at the corner of the phone body (case included) at the
+// speaker end of the back face, x across its width, y along its length
+// and z up through its thickness, so the body fills the positive octant.
+type OutputInput struct {
+	Units string `json:"units,omitempty"`
+	Frame string `json:"frame,omitempty"`
+}
+
+// OutputCoordinates declares the units and frame a response was written in
+type OutputCoordinates struct {
+	Units string `json:"units"`
+	Frame string `json:"frame"`
+}
+
+// without an OutputInput vertices are in world metres, as they always were
+var defaultOutputInput = OutputInput{Units: "m", Frame: outputFrameWorld}
+
+func resolveOutputInput(outputInput *OutputInput) OutputInput {
+	resolved := defaultOutputInput
+	if outputInput == nil {
+		return resolved
+	}
+	if outputInput.Units != "" {
+		resolved.Units = outputInput.Units
+	}
+	if outputInput.Frame != "" {
+		resolved.Frame = outputInput.Frame
+	}
+	return resolved
+}
+
+func validateOutputInput(validationError *ValidationError, outputInput *OutputInput) {
+	if outputInput.Units != "" {
+		validateUnit(validationError, "output.units", outputInput.Units, units.KindLength)
+	}
+	if outputInput.Frame != "" && !isOneOf(outputInput.Frame, outputFrames) {
+		validationError.Add("output.frame", "unknown frame %q, expected one of %s", outputInput.Frame, strings.Join(outputFrames, ", "))
+	}
+}
+
+// outputTransform maps world millimetres onto the requested coordinates.
+// Origin and Axes are nil for the world frame.
+type outputTransform struct {
+	Origin    []float64
+	Axes      *[3][]float64
+	converter units.Converter
+}
+
+func newOutputTransform(phoneConfig *PhoneConfig, simulationInput *SimulationInput) (outputTransform, error) {
+	var transform outputTransform
+	outputInput := resolveOutputInput(simulationInput.Output)
+
+	var err error
+	transform.converter, err = units.NewConverter(outputInput.Units, units.KindLength)
+	if err != nil {
+		return transform, err
+	}
+
+	transform.Origin, transform.Axes, err = frameAxes(phoneConfig, normalizedInput(simulationInput), outputInput.Frame)
+	return transform, err
+}
+
+// frameAxes returns the origin and axes of a frame in world mm, both nil for
+// the world frame
+func frameAxes(phoneConfig *PhoneConfig, normalized NormalizedSimulationInput, frame string) ([]float64, *[3][]float64, error) {
+	switch frame {
+	case outputFrameWorld:
+		return nil, nil, nil
+	case outputFrameParaboloid:
+		axes := paraboloidFrame(normalized.ParaboloidAngle)
+		return []float64{0, 0, 0}, &axes, nil
+	case outputFramePhone:
+		// the body spans corner - s·Width + t·Length + r·Height, see placeSpeaker
+		placement := placePhone(phoneConfig.body(), normalized)
+		across := []float64{-placement.Width[0], -placement.Width[1], -placement.Width[2]}
+		return placement.Corner, &[3][]float64{across, placement.Length, placement.Height}, nil
+	}
+	return nil, nil, fmt.Errorf("unknown frame %q", frame)
+}
+
+// apply returns a transformed copy of a flat list of world mm vertices
+func (transform outputTransform) apply(verticies []float64) []float64 {
+	if verticies == nil {
+		return nil
+	}
+	transformed := make([]float64, len(verticies))
+	relative := []float64{0, 0, 0}
+	for i := 0; i+2 < len(verticies); i += 3 {
+		point := verticies[i : i+3]
+		if transform.Axes != nil {
+			for j := 0; j < 3; j++ {
+				relative[j] = point[j] - transform.Origin[j]
+			}
+			for j := 0; j < 3; j++ {
+				transformed[i+j] = linalg.DotProduct(relative, transform.Axes[j], 3)
+			}
+			point = transformed[i : i+3]
+		}
+		for j := 0; j < 3; j++ {
+			transformed[i+j] = transform.converter.Convert(point[j])
+		}
+	}
+	return transformed
+}
+
+// rotate returns a copy of a world direction along the frame's axes.
+// Directions have no origin or length unit.
+func (transform outputTransform) rotate(direction []float64) []float64 {
+	rotated := make([]float64, len(direction))
+	copy(rotated, direction)
+	if transform.Axes == nil || len(direction) != 3 {
+		return rotated
+	}
+	for j := 0; j < 3; j++ {
+		rotated[j] = linalg.DotProduct(direction, transform.Axes[j], 3)
+	}
+	return rotated
+}
+
+// frameSimulationOutput rewrites the vertices and centroid directions of a
+// world mm output in the coordinates simulationInput asks for and records
+// which they are. Hit maps are binned in the output frame already. The
+// output passed in may be shared with the cache and is left untouched.
+func frameSimulationOutput(phoneConfig *PhoneConfig, simulationInput *SimulationInput, simulationOutput *SimulationOutput) (*SimulationOutput, error) {
+	transform, err := newOutputTransform(phoneConfig, simulationInput)
+	if err != nil {
+		return nil, err
+	}
+	outputInput := resolveOutputInput(simulationInput.Output)
+
+	framed := *simulationOutput
+	framed.Phone = transform.apply(simulationOutput.Phone)
+	framed.Paraboloid = transform.apply(simulationOutput.Paraboloid)
+	framed.User = transform.apply(simulationOutput.User)
+	if simulationOutput.Rays != nil {
+		rays := *simulationOutput.Rays
+		rays.Origins = transform.apply(simulationOutput.Rays.Origins)
+		framed.Rays = &rays
+	}
+	framed.Metrics.CentroidDirection = transform.rotate(simulationOutput.Metrics.CentroidDirection)
+	if simulationOutput.Baseline != nil {
+		baseline := *simulationOutput.Baseline
+		baseline.Metrics.CentroidDirection = transform.rotate(simulationOutput.Baseline.Metrics.CentroidDirection)
+		framed.Baseline = &baseline
+	}
+	framed.Coordinates = &OutputCoordinates{Units: outputInput.Units, Frame: outputInput.Frame}
+	return &framed, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestValidateOutputInput(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  OutputInput
+		fields []string
+	}{
+		{name: "empty", input: OutputInput{}},
+		{name: "all set", input: OutputInput{Units: "in", Frame: outputFramePhone}},
+		{name: "angle units", input: OutputInput{Units: "deg"}, fields: []string{"output.units"}},
+		{name: "unknown frame", input: OutputInput{Frame: "speaker"}, fields: []string{"output.frame"}},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			validationError := &ValidationError{Errors: []FieldError{}}
+			validateOutputInput(validationError, &test.input)
+			fields := []string{}
+			for j := 0; j < len(validationError.Errors); j++ {
+				fields = append(fields, validationError.Errors[j].Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(test.fields, ",") {
+				t.Errorf("got errors %+v, want them on %v", validationError.Errors, test.fields)
+			}
+		})
+	}
+
+	resolved := resolveOutputInput(&OutputInput{Units: "mm"})
+	if resolved.Units != "mm" || resolved.Frame != outputFrameWorld {
+		t.Errorf("got %+v, want mm in the world frame", resolved)
+	}
+	if resolveOutputInput(nil) != defaultOutputInput {
+		t.Errorf("got %+v without an output section", resolveOutputInput(nil))
+	}
+}
+
+func TestOutputTransform(t *testing.T) {
+	phoneConfig := testPhoneConfig()
+	simulationInput := testSimulationInput()
+	placement := placePhone(phoneConfig, normalizedInput(&simulationInput))
+	// corner, the far end of its length and the top of its thickness
+	var verticies []float64
+	for j := 0; j < 3; j++ {
+		verticies = append(verticies, placement.Corner[j])
+	}
+	for j := 0; j < 3; j++ {
+		verticies = append(verticies, placement.Corner[j]+phoneConfig.Length*placement.Length[j])
+	}
+	for j := 0; j < 3; j++ {
+		verticies = append(verticies, placement.Corner[j]+phoneConfig.Height*placement.Height[j])
+	}
+
+	tests := []struct {
+		name   string
+		output OutputInput
+		want   []float64
+	}{
+		{name: "phone frame", output: OutputInput{Units: "mm", Frame: outputFramePhone}, want: []float64{0, 0, 0, 0, 123.83, 0, 0, 0, 7.12}},
+		{name: "phone frame in cm", output: OutputInput{Units: "cm", Frame: outputFramePhone}, want: []float64{0, 0, 0, 0, 12.383, 0, 0, 0, 0.712}},
+		{name: "world metres", output: OutputInput{}, want: []float64{
+			placement.Corner[0] / 1000, placement.Corner[1] / 1000, placement.Corner[2] / 1000,
+			verticies[3] / 1000, verticies[4] / 1000, verticies[5] / 1000,
+			verticies[6] / 1000, verticies[7] / 1000, verticies[8] / 1000,
+		}},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			simulationInput.Output = &test.output
+			transform, err := newOutputTransform(phoneConfig, &simulationInput)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := transform.apply(verticies)
+			for j := 0; j < len(got); j++ {
+				if math.Abs(got[j]-test.want[j]) > 1e-9 {
+					t.Fatalf("got %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+
+	// the paraboloid axis maps onto z
+	simulationInput.Output = &OutputInput{Units: "mm", Frame: outputFrameParaboloid}
+	transform, err := newOutputTransform(phoneConfig, &simulationInput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	axes := paraboloidFrame(normalizedInput(&simulationInput).ParaboloidAngle)
+	got := transform.apply([]float64{2 * axes[2][0], 2 * axes[2][1], 2 * axes[2][2]})
+	if math.Abs(got[0]) > 1e-12 || math.Abs(got[1]) > 1e-12 || math.Abs(got[2]-2) > 1e-12 {
+		t.Errorf("got %v, want the axis along z", got)
+	}
+}
+
+func TestFrameSimulationOutput(t *testing.T) {
+	simulationInput := testSimulationInput()
+	simulationInput.Output = &OutputInput{Units: "cm"}
+	output := testExportOutput()
+	framed, err := frameSimulationOutput(testPhoneConfig(), &simulationInput, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if framed.Coordinates == nil || *framed.Coordinates != (OutputCoordinates{Units: "cm", Frame: outputFrameWorld}) {
+		t.Errorf("got coordinates %+v", framed.Coordinates)
+	}
+	if framed.User[1] != 0.1 || framed.Rays.Origins[3] != 0.1 {
+		t.Errorf("got user hits %v and origins %v in cm", framed.User, framed.Rays.Origins)
+	}
+	// the output may be cached, so it is left in mm
+	if output.User[1] != 1 || output.Rays.Origins[3] != 1 || output.Coordinates != nil {
+		t.Error("framing changed the output passed in")
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// phoneCatalogSignature is implemented by stores backed by files that can
+// change underneath the server. The signature changes whenever a definition
+// file is added, removed or rewritten.
+type phoneCatalogSignature interface {
+	Signature() (string, error)
+}
+
+// Signature summarises the name, size and modification time of every
+// definition file. A missing directory is an empty catalog.
+func (store *DirPhoneStore) Signature() (string, error) {
+	entries, err := os.ReadDir(store.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var signature strings.Builder
+	for i := 0; i < len(entries); i++ {
+		if !isPhoneDefinition(entries[i].Name()) {
+			continue
+		}
+		info, err := entries[i].Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&signature, "%s %d %d\n", entries[i].Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return signature.String(), nil
+}
+
+// the catalog underneath is built into the binary and never changes
+func (store *OverlayPhoneStore) Signature() (string, error) {
+	return store.primary.Signature()
+}
+
+type cachedPhone struct {
+	phoneConfig *PhoneConfig
+	err         error
+}
+
+// CachedPhoneStore keeps a whole catalog in memory so lookups never touch
+// the disk. Writes go through to the store underneath and reload the cache;
+// Watch picks up changes made to the files by anyone else.
+type CachedPhoneStore struct {
+	store PhoneStore
+	// serialises reloads so an older snapshot never replaces a newer one
+	reloading sync.Mutex
+	lock      sync.RWMutex
+	options   []PhoneOption
+	phones    map[string]cachedPhone
+	signature string
+	// closed and replaced on every change, see Changed
+	changed chan struct{}
+}
+
+func NewCachedPhoneStore(store PhoneStore) (*CachedPhoneStore, error) {
+	cached := &CachedPhoneStore{store: store, changed: make(chan struct{})}
+	_, err := cached.reload()
+	if err != nil {
+		return nil, err
+	}
+	return cached, nil
+}
+
+// reload reads the whole catalog again and reports whether the dropdown it
+// produces differs from the one cached
+func (cached *CachedPhoneStore) reload() (bool, error) {
+	cached.reloading.Lock()
+	defer cached.reloading.Unlock()
+
+	signature := ""
+	watched, ok := cached.store.(phoneCatalogSignature)
+	if ok {
+		var err error
+		signature, err = watched.Signature()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	options, err := cached.store.List()
+	if err != nil {
+		return false, err
+	}
+	phones := map[string]cachedPhone{}
+	for i := 0; i < len(options); i++ {
+		id := phoneIdFromFilename(options[i].Filename)
+		if _, ok := phones[id]; ok {
+			continue
+		}
+		phoneConfig, err := cached.store.Get(id)
+		phones[id] = cachedPhone{phoneConfig: phoneConfig, err: err}
+	}
+
+	cached.lock.Lock()
+	defer cached.lock.Unlock()
+	changed := !equalPhoneOptions(cached.options, options) || !equalCachedPhones(cached.phones, phones)
+	cached.options = options
+	cached.phones = phones
+	cached.signature = signature
+	if changed {
+		close(cached.changed)
+		cached.changed = make(chan struct{})
+	}
+	return changed, nil
+}
+
+func equalPhoneOptions(a []PhoneOption, b []PhoneOption) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if a[i].Name != b[i].Name || a[i].Filename != b[i].Filename || a[i].DisplayName != b[i].DisplayName || a[i].Manufacturer != b[i].Manufacturer || a[i].Year != b[i].Year || len(a[i].Errors) != len(b[i].Errors) {
+			return false
+		}
+		if len(a[i].Errors) > 0 && a[i].Errors.Error() != b[i].Errors.Error() {
+			return false
+		}
+	}
+	return true
+}
+
+func equalCachedPhones(a map[string]cachedPhone, b map[string]cachedPhone) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id, phoneA := range a {
+		phoneB, ok := b[id]
+		if !ok || (phoneA.err == nil) != (phoneB.err == nil) {
+			return false
+		}
+		if phoneA.err != nil && phoneA.err.Error() != phoneB.err.Error() {
+			return false
+		}
+		if phoneA.phoneConfig != nil && phoneB.phoneConfig != nil && *phoneA.phoneConfig != *phoneB.phoneConfig {
+			return false
+		}
+	}
+	return true
+}
+
+// Changed returns a channel that is closed the next time the catalog changes
+func (cached *CachedPhoneStore) Changed() <-chan struct{} {
+	cached.lock.RLock()
+	defer cached.lock.RUnlock()
+	return cached.changed
+}
+
+// Watch polls the store underneath for changes to its files until stop is
+// closed. Stores without files are never polled.
+func (cached *CachedPhoneStore) Watch(interval time.Duration, stop <-chan struct{}) {
+	watched, ok := cached.store.(phoneCatalogSignature)
+	if !ok || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		signature, err := watched.Signature()
+		if err != nil {
+			log.Printf("phone catalog: %v", err)
+			continue
+		}
+		cached.lock.RLock()
+		unchanged := signature == cached.signature
+		cached.lock.RUnlock()
+		if unchanged {
+			continue
+		}
+
+		changed, err := cached.reload()
+		if err != nil {
+			log.Printf("phone catalog: %v", err)
+		} else if changed {
+			log.Printf("phone catalog: reloaded")
+		}
+	}
+}
+
+func (cached *CachedPhoneStore) List() ([]PhoneOption, error) {
+	cached.lock.RLock()
+	defer cached.lock.RUnlock()
+	options := make([]PhoneOption, len(cached.options))
+	copy(options, cached.options)
+	return options, nil
+}
+
+func (cached *CachedPhoneStore) Get(id string) (*PhoneConfig, error) {
+	if !phoneIdPattern.MatchString(id) {
+		return nil, ErrInvalidPhoneId
+	}
+
+	cached.lock.RLock()
+	defer cached.lock.RUnlock()
+	phone, ok := cached.phones[id]
+	if !ok {
+		return nil, ErrPhoneNotFound
+	}
+	if phone.err != nil {
+		return nil, phone.err
+	}
+	phoneConfig := *phone.phoneConfig
+	return &phoneConfig, nil
+}
+
+// write applies a change to the store underneath and reloads straight away,
+// so the writer sees its own change without waiting for the next poll
+func (cached *CachedPhoneStore) write(apply func() error) error {
+	err := apply()
+	if err != nil {
+		return err
+	}
+	_, err = cached.reload()
+	return err
+}
+
+// writeBatch hands the store underneath to apply and reloads once when it
+// returns, so many writes cost a single reload. It reloads after a failure
+// too, since the writes before it still happened.
+func (cached *CachedPhoneStore) writeBatch(apply func(store PhoneStore) error) error {
+	err := apply(cached.store)
+	_, reloadErr := cached.reload()
+	if err != nil {
+		return err
+	}
+	return reloadErr
+}
+
+func (cached *CachedPhoneStore) Create(id string, phoneConfig *PhoneConfig) error {
+	return cached.write(func() error { return cached.store.Create(id, phoneConfig) })
+}
+
+func (cached *CachedPhoneStore) Update(id string, phoneConfig *PhoneConfig) error {
+	return cached.write(func() error { return cached.store.Update(id, phoneConfig) })
+}
+
+func (cached *CachedPhoneStore) Delete(id string) error {
+	return cached.write(func() error { return cached.store.Delete(id) })
+}
+
+// phoneEventsKeepAlive keeps idle connections from being closed by proxies
+const phoneEventsKeepAlive = 30 * time.Second
+
+// HandleHtmxPhoneEvents streams a phonesChanged event each time the catalog
+// changes, which the phone dropdown listens for to reload itself
+func HandleHtmxPhoneEvents(c *gin.Context) {
+	cached, ok := phoneStore.(*CachedPhoneStore)
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	changed := cached.Changed()
+	keepAlive := time.NewTicker(phoneEventsKeepAlive)
+	defer keepAlive.Stop()
+
+	// send headers now, so the browser knows the stream is open
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.WriteHeader(http.StatusOK)
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-serverStopping:
+			return false
+		case <-changed:
+			changed = cached.Changed()
+			c.SSEvent("phonesChanged", "")
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		return true
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// waitForChange fails the test unless changed is closed within a second
+func waitForChange(t *testing.T, changed <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatalf("no change seen after %s", what)
+	}
+}
+
+func TestCachedPhoneStoreWrites(t *testing.T) {
+	dir := t.TempDir()
+	cached, err := NewCachedPhoneStore(NewDirPhoneStore(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := cached.Changed()
+	err = cached.Create("pixel", testPhoneConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForChange(t, changed, "a create")
+	phoneConfig, err := cached.Get("pixel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *phoneConfig != *testPhoneConfig() {
+		t.Errorf("got %+v", *phoneConfig)
+	}
+
+	// lookups hand out copies
+	phoneConfig.Width = 1
+	phoneConfig, err = cached.Get("pixel")
+	if err != nil || phoneConfig.Width != testPhoneConfig().Width {
+		t.Errorf("a caller's change reached the cache: %+v, %v", phoneConfig, err)
+	}
+
+	// reloading an unchanged catalog is not a change
+	changed = cached.Changed()
+	same, err := cached.reload()
+	if err != nil || same {
+		t.Errorf("reloading an unchanged catalog reported %v, %v", same, err)
+	}
+
+	err = cached.Delete("pixel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForChange(t, changed, "a delete")
+	_, err = cached.Get("pixel")
+	if err != ErrPhoneNotFound {
+		t.Errorf("got %v after the delete, want ErrPhoneNotFound", err)
+	}
+}
+
+func TestCachedPhoneStoreWatch(t *testing.T) {
+	dir := t.TempDir()
+	cached, err := NewCachedPhoneStore(NewDirPhoneStore(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	changed := cached.Changed()
+	go cached.Watch(10*time.Millisecond, stop)
+
+	// a file written behind the store's back is picked up by the next poll
+	byteValue, err := marshalPhoneDefinition("pixel.yaml", testPhoneConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "pixel.yaml"), byteValue, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForChange(t, changed, "adding a file")
+	phoneOptions, err := cached.List()
+	if err != nil || len(phoneOptions) != 1 || phoneOptions[0].Filename != "pixel.yaml" {
+		t.Fatalf("got %+v, %v", phoneOptions, err)
+	}
+
+	changed = cached.Changed()
+	err = os.WriteFile(filepath.Join(dir, "pixel.yaml"), []byte("width: -1\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForChange(t, changed, "breaking the file")
+	_, err = cached.Get("pixel")
+	if err == nil {
+		t.Error("a broken definition was served from the cache")
+	}
+}
+
+func TestEqualCachedPhones(t *testing.T) {
+	pixel := cachedPhone{phoneConfig: testPhoneConfig()}
+	wider := cachedPhone{phoneConfig: testPhoneConfig()}
+	wider.phoneConfig.Width = 60
+	broken := cachedPhone{err: errors.New("width must be positive")}
+	brokenElsewhere := cachedPhone{err: errors.New("depth must be positive")}
+
+	tests := []struct {
+		name string
+		a    cachedPhone
+		b    cachedPhone
+		want bool
+	}{
+		{name: "same phone", a: pixel, b: cachedPhone{phoneConfig: testPhoneConfig()}, want: true},
+		{name: "different dimensions", a: pixel, b: wider, want: false},
+		{name: "broken by the same error", a: broken, b: cachedPhone{err: errors.New("width must be positive")}, want: true},
+		{name: "broken by another error", a: broken, b: brokenElsewhere, want: false},
+		{name: "fixed", a: broken, b: pixel, want: false},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			got := equalCachedPhones(map[string]cachedPhone{"pixel": test.a}, map[string]cachedPhone{"pixel": test.b})
+			if got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHandleHtmxPhoneEvents(t *testing.T) {
+	cached, err := NewCachedPhoneStore(NewDirPhoneStore(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	phoneStore = cached
+	defer func() { phoneStore = nil }()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/htmx/phones/events", HandleHtmxPhoneEvents)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/htmx/phones/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK || !strings.HasPrefix(response.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("got status %d and content type %q", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+
+	err = cached.Create("pixel", testPhoneConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "event:") {
+				events <- strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "event:"))
+				return
+			}
+		}
+		close(events)
+	}()
+	select {
+	case event := <-events:
+		if event != "phonesChanged" {
+			t.Errorf("got event %q, want phonesChanged", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event after a create")
+	}
+
+	// without a cache there is nothing to stream
+	phoneStore = NewMemoryPhoneStore(nil)
+	recorder := serveTestRequest(r, http.MethodGet, "/htmx/phones/events", "")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("got status %d without a cache, want 404", recorder.Code)
+	}
+}
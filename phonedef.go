@@ -0,0 +1,539 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// phone definitions are read in any of these formats, chosen by extension.
+// New phones are written as XML like the original catalog.
+var phoneDefinitionExtensions = []string{".xml", ".json", ".yaml", ".yml"}
+
+// PhoneDefinitionError locates a problem in a phone definition file. Line is
+// 0 when the problem cannot be tied to a line.
+type PhoneDefinitionError struct {
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *PhoneDefinitionError) Error() string {
+	location := e.File
+	if e.Line > 0 {
+		location += ":" + strconv.Itoa(e.Line)
+	}
+	if e.Field != "" {
+		return location + ": " + e.Field + ": " + e.Message
+	}
+	return location + ": " + e.Message
+}
+
+type PhoneDefinitionErrors []*PhoneDefinitionError
+
+func (errs PhoneDefinitionErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i := 0; i < len(errs); i++ {
+		messages[i] = errs[i].Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// phoneNumber is the text of a scalar that the format marks as numeric, or
+// that XML leaves untyped
+type phoneNumber string
+
+// phoneText is a scalar the format marks as a string
+type phoneText string
+
+func (text phoneText) String() string {
+	return strconv.Quote(string(text))
+}
+
+// phoneField is one value read from a definition. Value is a phoneNumber,
+// phoneText, json.Number or, for anything the schema cannot use, a
+// description of it.
+type phoneField struct {
+	Value     any
+	Line      int
+	Container bool
+}
+
+// phoneSchemaField is a number when Number is set and a string otherwise
+type phoneSchemaField struct {
+	Path     string
+	Optional bool
+	Integer  bool
+	Number   func(phoneConfig *PhoneConfig, val float64)
+	Text     func(phoneConfig *PhoneConfig, val string)
+}
+
+// phoneSchema lists the fields of a definition, lengths in mm, keyed by the
+// same dotted paths used in validation errors
+var phoneSchema = []phoneSchemaField{
+	{Path: "displayName", Optional: true, Text: func(phoneConfig *PhoneConfig, val string) { phoneConfig.DisplayName = val }},
+	{Path: "manufacturer", Optional: true, Text: func(phoneConfig *PhoneConfig, val string) { phoneConfig.Manufacturer = val }},
+	{Path: "year", Optional: true, Integer: true, Number: func(phoneConfig *PhoneConfig, val float64) { phoneConfig.Year = int(val) }},
+	{Path: "width", Number: func(phoneConfig *PhoneConfig, val float64) { phoneConfig.Width = val }},
+	{Path: "length", Number: func(phoneConfig *PhoneConfig, val float64) { phoneConfig.Length = val }},
+	{Path: "height", Number: func(phoneConfig *PhoneConfig, val float64) { phoneConfig.Height = val }},
+	{Path: "cornerRadius", Optional: true, Number: func(phoneConfig *PhoneConfig, val float64) { phoneConfig.CornerRadius = val }},
+	{Path: "caseOffset", Optional: true, Number: func(phoneConfig *PhoneConfig, val float64) { phoneConfig.CaseOffset = val }},
+	{Path: "speaker.face", Optional: true, Text: func(phoneConfig *PhoneConfig, val string) { phoneConfig.Speaker.Face = val }},
+	{Path: "speaker.width", Number: func(phoneConfig *PhoneConfig, val float64) { phoneConfig.Speaker.Width = val }},
+	{Path: "speaker.height", Number: func(phoneConfig *PhoneConfig, val float64) { phoneConfig.Speaker.Height = val }},
+	{Path: "speaker.center", Number: func(phoneConfig *PhoneConfig, val float64) { phoneConfig.Speaker.Center = val }},
+	{Path: "speaker.offset", Optional: true, Number: func(phoneConfig *PhoneConfig, val float64) { phoneConfig.Speaker.Offset = val }},
+}
+
+var phoneSchemaContainers = []string{"speaker"}
+
+// phoneSchemaPaths maps lower cased paths to the schema spelling. Every
+// format matches field names case-insensitively, see phoneFieldPath.
+var phoneSchemaPaths = func() map[string]string {
+	paths := map[string]string{}
+	for i := 0; i < len(phoneSchema); i++ {
+		paths[strings.ToLower(phoneSchema[i].Path)] = phoneSchema[i].Path
+	}
+	for i := 0; i < len(phoneSchemaContainers); i++ {
+		paths[strings.ToLower(phoneSchemaContainers[i])] = phoneSchemaContainers[i]
+	}
+	return paths
+}()
+
+func isPhoneDefinition(name string) bool {
+	extension := path.Ext(name)
+	for i := 0; i < len(phoneDefinitionExtensions); i++ {
+		if extension == phoneDefinitionExtensions[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePhoneDefinition reads a definition in the format given by the
+// extension of file and checks it against phoneSchema. All problems found are
+// returned together as PhoneDefinitionErrors.
+func parsePhoneDefinition(file string, byteValue []byte) (*PhoneConfig, error) {
+	var fields map[string]phoneField
+	var err error
+	switch path.Ext(file) {
+	case ".xml":
+		fields, err = readXmlFields(byteValue)
+	case ".json":
+		fields, err = readJsonFields(byteValue)
+	case ".yaml", ".yml":
+		fields, err = readYamlFields(byteValue)
+	default:
+		return nil, PhoneDefinitionErrors{{File: file, Message: "unsupported file extension"}}
+	}
+
+	var definitionError *PhoneDefinitionError
+	if errors.As(err, &definitionError) {
+		definitionError.File = file
+		return nil, PhoneDefinitionErrors{definitionError}
+	}
+	if err != nil {
+		return nil, PhoneDefinitionErrors{{File: file, Message: err.Error()}}
+	}
+
+	return decodePhoneFields(file, fields)
+}
+
+func decodePhoneFields(file string, fields map[string]phoneField) (*PhoneConfig, error) {
+	var phoneConfig PhoneConfig
+	errs := PhoneDefinitionErrors{}
+	add := func(field string, format string, args ...any) {
+		errs = append(errs, &PhoneDefinitionError{File: file, Line: fields[field].Line, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	known := map[string]bool{}
+	for i := 0; i < len(phoneSchemaContainers); i++ {
+		container := phoneSchemaContainers[i]
+		known[container] = true
+		field, ok := fields[container]
+		if ok && !field.Container {
+			add(container, "must contain the %s fields", container)
+		}
+	}
+
+	for i := 0; i < len(phoneSchema); i++ {
+		known[phoneSchema[i].Path] = true
+		field, ok := fields[phoneSchema[i].Path]
+		if !ok && phoneSchema[i].Optional {
+			continue
+		}
+		if !ok {
+			// point missing fields at the section they belong in
+			parent := ""
+			if dot := strings.LastIndex(phoneSchema[i].Path, "."); dot >= 0 {
+				parent = phoneSchema[i].Path[:dot]
+			}
+			if _, ok := fields[parent]; !ok {
+				parent = ""
+			}
+			errs = append(errs, &PhoneDefinitionError{File: file, Line: fields[parent].Line, Field: phoneSchema[i].Path, Message: "is required"})
+			continue
+		}
+
+		if phoneSchema[i].Number == nil {
+			text, ok := field.text()
+			if !ok {
+				add(phoneSchema[i].Path, "must be a string, got %v", field.Value)
+				continue
+			}
+			phoneSchema[i].Text(&phoneConfig, text)
+			continue
+		}
+
+		val, ok := field.number()
+		if !ok {
+			add(phoneSchema[i].Path, "must be a number, got %v", field.Value)
+			continue
+		}
+		if phoneSchema[i].Integer && val != math.Trunc(val) {
+			add(phoneSchema[i].Path, "must be a whole number, got %v", field.Value)
+			continue
+		}
+		phoneSchema[i].Number(&phoneConfig, val)
+	}
+
+	for fieldPath := range fields {
+		if !known[fieldPath] && fieldPath != "" {
+			add(fieldPath, "unknown field")
+		}
+	}
+
+	if len(errs) == 0 {
+		validationError := &ValidationError{}
+		validatePhoneConfig(validationError, &phoneConfig)
+		for i := 0; i < len(validationError.Errors); i++ {
+			add(validationError.Errors[i].Field, "%s", validationError.Errors[i].Message)
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool {
+			return errs[i].Line < errs[j].Line
+		})
+		return nil, errs
+	}
+	return &phoneConfig, nil
+}
+
+func (field phoneField) number() (float64, bool) {
+	switch val := field.Value.(type) {
+	case phoneNumber:
+		number, err := strconv.ParseFloat(strings.TrimSpace(string(val)), 64)
+		return number, err == nil
+	case json.Number:
+		number, err := val.Float64()
+		return number, err == nil
+	}
+	return 0, false
+}
+
+func (field phoneField) text() (string, bool) {
+	switch val := field.Value.(type) {
+	case phoneText:
+		return string(val), true
+	case phoneNumber:
+		// untyped XML text, or a YAML scalar that happens to look numeric
+		return strings.TrimSpace(string(val)), true
+	}
+	return "", false
+}
+
+func joinFieldPath(parent string, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// phoneFieldPath joins name onto parent, giving names the schema knows in
+// any case its spelling, so <Speaker>, "Speaker" and speaker: are all the
+// speaker section
+func phoneFieldPath(parent string, name string) string {
+	fieldPath := joinFieldPath(parent, name)
+	canonical, ok := phoneSchemaPaths[strings.ToLower(fieldPath)]
+	if ok {
+		return canonical
+	}
+	return fieldPath
+}
+
+type xmlElement struct {
+	path     string
+	line     int
+	text     strings.Builder
+	children bool
+}
+
+// readXmlFields flattens the children of the root element, whose name is
+// matched case-insensitively like those of the fields
+func readXmlFields(byteValue []byte) (map[string]phoneField, error) {
+	fields := map[string]phoneField{}
+	decoder := xml.NewDecoder(bytes.NewReader(byteValue))
+
+	var stack []*xmlElement
+	rootSeen := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		var syntaxError *xml.SyntaxError
+		if errors.As(err, &syntaxError) {
+			return nil, &PhoneDefinitionError{Line: syntaxError.Line, Message: syntaxError.Msg}
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := decoder.InputPos()
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if len(stack) == 0 {
+				if rootSeen {
+					return nil, &PhoneDefinitionError{Line: line, Message: "more than one root element"}
+				}
+				if !strings.EqualFold(t.Name.Local, "Phone") {
+					return nil, &PhoneDefinitionError{Line: line, Message: fmt.Sprintf("root element must be <Phone>, got <%s>", t.Name.Local)}
+				}
+				rootSeen = true
+				fields[""] = phoneField{Value: "an element", Line: line, Container: true}
+				stack = append(stack, &xmlElement{line: line})
+				continue
+			}
+			parent := stack[len(stack)-1]
+			parent.children = true
+			elementPath := phoneFieldPath(parent.path, t.Name.Local)
+			stack = append(stack, &xmlElement{path: elementPath, line: line})
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		case xml.EndElement:
+			element := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if element.path == "" {
+				continue
+			}
+			if _, ok := fields[element.path]; ok {
+				return nil, &PhoneDefinitionError{Line: element.line, Field: element.path, Message: "appears more than once"}
+			}
+			if element.children {
+				fields[element.path] = phoneField{Value: "an element", Line: element.line, Container: true}
+			} else {
+				fields[element.path] = phoneField{Value: phoneNumber(element.text.String()), Line: element.line}
+			}
+		}
+	}
+
+	if !rootSeen {
+		return nil, &PhoneDefinitionError{Message: "no root element"}
+	}
+	return fields, nil
+}
+
+// lineAt converts a byte offset to a 1 based line number
+func lineAt(byteValue []byte, offset int64) int {
+	if offset > int64(len(byteValue)) {
+		offset = int64(len(byteValue))
+	}
+	return bytes.Count(byteValue[:offset], []byte("\n")) + 1
+}
+
+func readJsonFields(byteValue []byte) (map[string]phoneField, error) {
+	fields := map[string]phoneField{}
+	decoder := json.NewDecoder(bytes.NewReader(byteValue))
+	decoder.UseNumber()
+
+	err := readJsonValue(decoder, byteValue, fields, "", 1)
+	if err == nil {
+		_, err = decoder.Token()
+		if err == io.EOF {
+			return fields, nil
+		}
+		if err == nil {
+			return nil, &PhoneDefinitionError{Line: lineAt(byteValue, decoder.InputOffset()), Message: "unexpected data after the phone object"}
+		}
+	}
+
+	var definitionError *PhoneDefinitionError
+	if errors.As(err, &definitionError) {
+		return nil, err
+	}
+	var syntaxError *json.SyntaxError
+	if errors.As(err, &syntaxError) {
+		return nil, &PhoneDefinitionError{Line: lineAt(byteValue, syntaxError.Offset), Message: syntaxError.Error()}
+	}
+	return nil, &PhoneDefinitionError{Line: lineAt(byteValue, decoder.InputOffset()), Message: err.Error()}
+}
+
+func readJsonValue(decoder *json.Decoder, byteValue []byte, fields map[string]phoneField, fieldPath string, line int) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	delim, isDelim := token.(json.Delim)
+	if fieldPath == "" && delim != '{' {
+		return &PhoneDefinitionError{Line: line, Message: "must be a JSON object"}
+	}
+	if !isDelim {
+		switch val := token.(type) {
+		case json.Number:
+			fields[fieldPath] = phoneField{Value: val, Line: line}
+		case string:
+			fields[fieldPath] = phoneField{Value: phoneText(val), Line: line}
+		case nil:
+			fields[fieldPath] = phoneField{Value: "null", Line: line}
+		default:
+			fields[fieldPath] = phoneField{Value: val, Line: line}
+		}
+		return nil
+	}
+
+	if delim == '[' {
+		fields[fieldPath] = phoneField{Value: "an array", Line: line}
+		for depth := 1; depth > 0; {
+			token, err = decoder.Token()
+			if err != nil {
+				return err
+			}
+			switch token {
+			case json.Delim('['), json.Delim('{'):
+				depth++
+			case json.Delim(']'), json.Delim('}'):
+				depth--
+			}
+		}
+		return nil
+	}
+
+	fields[fieldPath] = phoneField{Value: "an object", Line: line, Container: true}
+	for decoder.More() {
+		token, err = decoder.Token()
+		if err != nil {
+			return err
+		}
+		childPath := phoneFieldPath(fieldPath, token.(string))
+		childLine := lineAt(byteValue, decoder.InputOffset())
+		if _, ok := fields[childPath]; ok {
+			return &PhoneDefinitionError{Line: childLine, Field: childPath, Message: "appears more than once"}
+		}
+		err = readJsonValue(decoder, byteValue, fields, childPath, childLine)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = decoder.Token()
+	return err
+}
+
+var yamlErrorLine = regexp.MustCompile(`^yaml: line ([0-9]+): `)
+
+func readYamlFields(byteValue []byte) (map[string]phoneField, error) {
+	var document yaml.Node
+	err := yaml.Unmarshal(byteValue, &document)
+	if err != nil {
+		definitionError := &PhoneDefinitionError{Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+		match := yamlErrorLine.FindStringSubmatch(err.Error())
+		if match != nil {
+			definitionError.Line, _ = strconv.Atoi(match[1])
+			definitionError.Message = err.Error()[len(match[0]):]
+		}
+		return nil, definitionError
+	}
+
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return nil, &PhoneDefinitionError{Line: max(document.Line, 1), Message: "must be a YAML mapping"}
+	}
+
+	fields := map[string]phoneField{}
+	fields[""] = phoneField{Value: "a mapping", Line: document.Content[0].Line, Container: true}
+	err = readYamlMapping(document.Content[0], fields, "")
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func readYamlMapping(node *yaml.Node, fields map[string]phoneField, fieldPath string) error {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		value := node.Content[i+1]
+		childPath := phoneFieldPath(fieldPath, key.Value)
+		if _, ok := fields[childPath]; ok {
+			return &PhoneDefinitionError{Line: key.Line, Field: childPath, Message: "appears more than once"}
+		}
+
+		switch {
+		case value.Kind == yaml.MappingNode:
+			fields[childPath] = phoneField{Value: "a mapping", Line: key.Line, Container: true}
+			err := readYamlMapping(value, fields, childPath)
+			if err != nil {
+				return err
+			}
+		case value.Kind == yaml.ScalarNode && (value.Tag == "!!int" || value.Tag == "!!float"):
+			fields[childPath] = phoneField{Value: phoneNumber(value.Value), Line: key.Line}
+		case value.Kind == yaml.ScalarNode && value.Tag == "!!str":
+			fields[childPath] = phoneField{Value: phoneText(value.Value), Line: key.Line}
+		case value.Kind == yaml.ScalarNode:
+			fields[childPath] = phoneField{Value: fmt.Sprintf("%q", value.Value), Line: key.Line}
+		default:
+			fields[childPath] = phoneField{Value: "a list", Line: key.Line}
+		}
+	}
+	return nil
+}
+
+// phoneXml gives the catalog files their <Phone> root element
+type phoneXml struct {
+	XMLName xml.Name `xml:"Phone"`
+	PhoneConfig
+}
+
+// marshalPhoneDefinition writes phoneConfig in the format given by the
+// extension of file, so that updates keep a definition in its own format
+func marshalPhoneDefinition(file string, phoneConfig *PhoneConfig) ([]byte, error) {
+	switch path.Ext(file) {
+	case ".json":
+		byteValue, err := json.MarshalIndent(phoneConfig, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(byteValue, '\n'), nil
+	case ".yaml", ".yml":
+		var buffer bytes.Buffer
+		encoder := yaml.NewEncoder(&buffer)
+		encoder.SetIndent(2)
+		err := encoder.Encode(phoneConfig)
+		if err != nil {
+			return nil, err
+		}
+		err = encoder.Close()
+		return buffer.Bytes(), err
+	}
+
+	byteValue, err := xml.MarshalIndent(phoneXml{PhoneConfig: *phoneConfig}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	byteValue = append([]byte(xml.Header), byteValue...)
+	return append(byteValue, '\n'), nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testPhoneJson = `{
+  "width": 58.57,
+  "length": 123.83,
+  "height": 7.12,
+  "speaker": {
+    "width": 11.96,
+    "height": 3.36,
+    "center": 44.495
+  },
+  "displayName": "iPhone 5",
+  "manufacturer": "Apple",
+  "year": 2012
+}
+`
+
+const testPhoneYaml = `width: 58.57
+length: 123.83
+height: 7.12
+speaker:
+  width: 11.96
+  height: 3.36
+  center: 44.495
+displayName: iPhone 5
+manufacturer: Apple
+year: 2012
+`
+
+func TestParsePhoneDefinition(t *testing.T) {
+	xmlValue, err := os.ReadFile("phones/iPhone5.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	definitions := map[string]string{
+		"iPhone5.xml":  string(xmlValue),
+		"iPhone5.json": testPhoneJson,
+		"iPhone5.yaml": testPhoneYaml,
+		"iPhone5.yml":  testPhoneYaml,
+		// names match in any case
+		"upper.json": strings.Replace(strings.Replace(testPhoneJson, `"speaker"`, `"Speaker"`, 1), `"displayName"`, `"DisplayName"`, 1),
+		"upper.yaml": strings.Replace(strings.Replace(testPhoneYaml, "speaker:", "SPEAKER:", 1), "  center:", "  Center:", 1),
+	}
+	for file, definition := range definitions {
+		phoneConfig, err := parsePhoneDefinition(file, []byte(definition))
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		if *phoneConfig != *testPhoneConfig() {
+			t.Errorf("%s: got %+v, want %+v", file, *phoneConfig, *testPhoneConfig())
+		}
+	}
+}
+
+func TestParsePhoneDefinitionErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string
+		definition string
+		// "line field" per expected error, in order
+		want []string
+	}{
+		{
+			name:       "xml syntax",
+			file:       "a.xml",
+			definition: "<Phone>\n  <width>1</width>\n  <length>2</height>\n</Phone>\n",
+			want:       []string{"3 "},
+		},
+		{
+			name:       "xml root",
+			file:       "a.xml",
+			definition: "<?xml version=\"1.0\"?>\n<Tablet></Tablet>\n",
+			want:       []string{"2 "},
+		},
+		{
+			name:       "json missing fields point at their section",
+			file:       "a.json",
+			definition: strings.Replace(testPhoneJson, "3.36,\n    \"center\": 44.495", "3.36", 1),
+			want:       []string{"5 speaker.center"},
+		},
+		{
+			name:       "json syntax",
+			file:       "a.json",
+			definition: strings.Replace(testPhoneJson, "7.12,", "7.12", 1),
+			want:       []string{"5 "},
+		},
+		{
+			name:       "json string and unknown field",
+			file:       "a.json",
+			definition: strings.Replace(testPhoneJson, `"length": 123.83,`, `"length": "123.83", "depth": 1,`, 1),
+			want:       []string{"3 length", "3 depth"},
+		},
+		{
+			name:       "yaml string",
+			file:       "a.yaml",
+			definition: strings.Replace(testPhoneYaml, "height: 3.36", "height: 3.36 mm", 1),
+			want:       []string{"6 speaker.height"},
+		},
+		{
+			name:       "yaml duplicate",
+			file:       "a.yaml",
+			definition: testPhoneYaml + "width: 1\n",
+			want:       []string{"11 width"},
+		},
+		{
+			name:       "json keys differing only in case",
+			file:       "a.json",
+			definition: strings.Replace(testPhoneJson, `"height": 7.12,`, `"height": 7.12, "Height": 7.12,`, 1),
+			want:       []string{"4 height"},
+		},
+		{
+			name:       "yaml keys differing only in case",
+			file:       "a.yaml",
+			definition: testPhoneYaml + "Width: 1\n",
+			want:       []string{"11 width"},
+		},
+		{
+			name:       "yaml speaker is not a section",
+			file:       "a.yaml",
+			definition: "width: 58.57\nlength: 123.83\nheight: 7.12\nspeaker: 1\n",
+			want:       []string{"4 speaker", "4 speaker.width", "4 speaker.height", "4 speaker.center"},
+		},
+		{
+			name:       "dimensions checked after parsing",
+			file:       "a.yaml",
+			definition: strings.Replace(testPhoneYaml, "center: 44.495", "center: 58", 1),
+			want:       []string{"7 speaker.center"},
+		},
+		{
+			name:       "unsupported extension",
+			file:       "a.toml",
+			definition: "",
+			want:       []string{"0 "},
+		},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parsePhoneDefinition(test.file, []byte(test.definition))
+			var errs PhoneDefinitionErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("got %v, want PhoneDefinitionErrors", err)
+			}
+			got := []string{}
+			for j := 0; j < len(errs); j++ {
+				if errs[j].File != test.file {
+					t.Errorf("error %q names file %q", errs[j].Error(), errs[j].File)
+				}
+				got = append(got, fmt.Sprintf("%d %s", errs[j].Line, errs[j].Field))
+			}
+			if strings.Join(got, ",") != strings.Join(test.want, ",") {
+				t.Errorf("got %q, want %q\n%v", got, test.want, err)
+			}
+		})
+	}
+}
+
+func TestMarshalPhoneDefinition(t *testing.T) {
+	files := []string{"a.xml", "a.json", "a.yaml", "a.yml"}
+	for i := 0; i < len(files); i++ {
+		byteValue, err := marshalPhoneDefinition(files[i], testPhoneConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		phoneConfig, err := parsePhoneDefinition(files[i], byteValue)
+		if err != nil {
+			t.Errorf("%s: reading back: %v\n%s", files[i], err, byteValue)
+			continue
+		}
+		if *phoneConfig != *testPhoneConfig() {
+			t.Errorf("%s: read back %+v", files[i], *phoneConfig)
+		}
+	}
+}
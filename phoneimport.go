@@ -0,0 +1,362 @@
+package main
+
+import (
+	"amphora/pkg/units"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// phoneImportHeader matches a column name with an optional unit, as in
+// "width", "Speaker Width (in)" or "speaker.width [cm]"
+var phoneImportHeader = regexp.MustCompile(`^\s*([^()\[\]]*?)\s*(?:[(\[]\s*([A-Za-z]+)\s*[)\]])?\s*$`)
+
+type phoneImportColumn struct {
+	// Field is nil for the id and unit columns
+	Field *phoneSchemaField
+	Name  string
+	Unit  string
+}
+
+type PhoneImportRejection struct {
+	Line   int          `json:"line"`
+	Id     string       `json:"id,omitempty"`
+	Errors []FieldError `json:"errors"`
+}
+
+type PhoneImportReport struct {
+	Imported []string               `json:"imported"`
+	Updated  []string               `json:"updated"`
+	Rejected []PhoneImportRejection `json:"rejected"`
+	// why the import stopped part way, the rows above were still written
+	Error string `json:"error,omitempty"`
+}
+
+type phoneImportOptions struct {
+	// replace phones that already exist instead of rejecting their rows
+	update bool
+	// validate and report without writing anything
+	dryRun bool
+}
+
+// phoneImportKey reduces a column name to letters and digits so that
+// "speaker.width", "Speaker Width" and "speaker_width" all match
+func phoneImportKey(name string) string {
+	var key strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if ('a' <= r && r <= 'z') || ('0' <= r && r <= '9') {
+			key.WriteRune(r)
+		}
+	}
+	return key.String()
+}
+
+// parsePhoneImportHeader maps each column to a schema field. Problems with
+// the header make the whole file unusable, so they are all reported at once.
+func parsePhoneImportHeader(header []string) ([]phoneImportColumn, *ValidationError) {
+	fields := map[string]*phoneSchemaField{}
+	for i := 0; i < len(phoneSchema); i++ {
+		fields[phoneImportKey(phoneSchema[i].Path)] = &phoneSchema[i]
+	}
+
+	validationError := &ValidationError{}
+	columns := make([]phoneImportColumn, len(header))
+	seen := map[string]bool{}
+	for i := 0; i < len(header); i++ {
+		field := fmt.Sprintf("header[%d]", i)
+		match := phoneImportHeader.FindStringSubmatch(header[i])
+		if match == nil || match[1] == "" {
+			validationError.Add(field, "cannot read column name %q", header[i])
+			continue
+		}
+
+		var column phoneImportColumn
+		column.Unit = strings.ToLower(match[2])
+		key := phoneImportKey(match[1])
+		switch key {
+		case "id", "model":
+			column.Name = "id"
+		case "unit", "units":
+			column.Name = "unit"
+		default:
+			column.Field = fields[key]
+			if column.Field == nil {
+				validationError.Add(field, "unknown column %q", match[1])
+				continue
+			}
+			column.Name = column.Field.Path
+		}
+
+		if seen[column.Name] {
+			validationError.Add(field, "%s appears in more than one column", column.Name)
+		}
+		seen[column.Name] = true
+		if column.Unit != "" && !isPhoneImportLength(column.Field) {
+			validationError.Add(field, "%s does not take a unit", column.Name)
+		} else if column.Unit != "" {
+			validateUnit(validationError, field, column.Unit, units.KindLength)
+		}
+		columns[i] = column
+	}
+
+	if !seen["id"] {
+		validationError.Add("header", "missing the id column")
+	}
+	for i := 0; i < len(phoneSchema); i++ {
+		if !phoneSchema[i].Optional && !seen[phoneSchema[i].Path] {
+			validationError.Add("header", "missing the %s column", phoneSchema[i].Path)
+		}
+	}
+	if !validationError.Empty() {
+		return nil, validationError
+	}
+	return columns, nil
+}
+
+// every number in the schema except whole numbers such as the year is a
+// length in mm
+func isPhoneImportLength(field *phoneSchemaField) bool {
+	return field != nil && field.Number != nil && !field.Integer
+}
+
+// parsePhoneImportRow builds the phone described by one row. Empty cells
+// leave optional fields unset.
+func parsePhoneImportRow(columns []phoneImportColumn, record []string) (string, *PhoneConfig, *ValidationError) {
+	validationError := &ValidationError{}
+	var phoneConfig PhoneConfig
+	if len(record) != len(columns) {
+		validationError.Add("row", "has %d cells, the header has %d", len(record), len(columns))
+		return "", nil, validationError
+	}
+
+	id := ""
+	rowUnit := "mm"
+	for i := 0; i < len(columns); i++ {
+		switch columns[i].Name {
+		case "id":
+			id = strings.TrimSpace(record[i])
+		case "unit":
+			unit := strings.ToLower(strings.TrimSpace(record[i]))
+			if unit == "" {
+				continue
+			}
+			if !validateUnit(validationError, "unit", unit, units.KindLength) {
+				continue
+			}
+			rowUnit = unit
+		}
+	}
+	validatePhoneId(validationError, id)
+
+	for i := 0; i < len(columns); i++ {
+		field := columns[i].Field
+		if field == nil {
+			continue
+		}
+		cell := strings.TrimSpace(record[i])
+		if cell == "" {
+			if !field.Optional {
+				validationError.Add(field.Path, "is required")
+			}
+			continue
+		}
+
+		if field.Number == nil {
+			field.Text(&phoneConfig, cell)
+			continue
+		}
+		val, err := strconv.ParseFloat(cell, 64)
+		if err != nil {
+			validationError.Add(field.Path, "must be a number, got %q", cell)
+			continue
+		}
+		if field.Integer && val != math.Trunc(val) {
+			validationError.Add(field.Path, "must be a whole number, got %q", cell)
+			continue
+		}
+		if isPhoneImportLength(field) {
+			unit := columns[i].Unit
+			if unit == "" {
+				unit = rowUnit
+			}
+			length, _ := units.NewLength(val, unit)
+			val = length.Millimetres()
+		}
+		field.Number(&phoneConfig, val)
+	}
+
+	if validationError.Empty() {
+		validatePhoneConfig(validationError, &phoneConfig)
+	}
+	return id, &phoneConfig, validationError
+}
+
+// importPhonesCsv adds every valid row of a spec sheet to store. Rows that
+// fail validation or name a phone that already exists are reported and
+// skipped; an unusable header or a failing store stops the import, and the
+// report then lists the rows written before it stopped.
+func importPhonesCsv(r io.Reader, store PhoneStore, options phoneImportOptions) (*PhoneImportReport, *ValidationError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		validationError := &ValidationError{}
+		validationError.Add("header", "the file is empty")
+		return nil, validationError, nil
+	}
+	if err != nil {
+		validationError := &ValidationError{}
+		validationError.Add("header", "%v", err)
+		return nil, validationError, nil
+	}
+	columns, validationError := parsePhoneImportHeader(header)
+	if validationError != nil {
+		return nil, validationError, nil
+	}
+
+	report := &PhoneImportReport{Imported: []string{}, Updated: []string{}, Rejected: []PhoneImportRejection{}}
+	reject := func(line int, id string, validationError *ValidationError) {
+		report.Rejected = append(report.Rejected, PhoneImportRejection{Line: line, Id: id, Errors: validationError.Errors})
+	}
+	lines := map[string]int{}
+	importRows := func(store PhoneStore) error {
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				return nil
+			}
+			var parseError *csv.ParseError
+			if errors.As(err, &parseError) {
+				validationError := &ValidationError{}
+				validationError.Add("row", "%v", parseError.Err)
+				reject(parseError.StartLine, "", validationError)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+				continue
+			}
+			line, _ := reader.FieldPos(0)
+
+			id, phoneConfig, validationError := parsePhoneImportRow(columns, record)
+			if other, ok := lines[id]; ok && id != "" {
+				validationError.Add("id", "phone %q is already defined on line %d", id, other)
+			}
+			if !validationError.Empty() {
+				reject(line, id, validationError)
+				continue
+			}
+			lines[id] = line
+
+			_, err = store.Get(id)
+			var definitionErrors PhoneDefinitionErrors
+			exists := err == nil || errors.As(err, &definitionErrors)
+			if err != nil && !exists && !errors.Is(err, ErrPhoneNotFound) {
+				return err
+			}
+			if exists && !options.update {
+				validationError.Add("id", "phone %q already exists", id)
+				reject(line, id, validationError)
+				continue
+			}
+
+			if !options.dryRun {
+				if exists {
+					err = store.Update(id, phoneConfig)
+				} else {
+					err = store.Create(id, phoneConfig)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			if exists {
+				report.Updated = append(report.Updated, id)
+			} else {
+				report.Imported = append(report.Imported, id)
+			}
+		}
+	}
+
+	// a cached store would reread the whole catalog after every row, so the
+	// rows go to the store underneath and the cache reloads once at the end
+	cached, ok := store.(*CachedPhoneStore)
+	if ok {
+		err = cached.writeBatch(importRows)
+	} else {
+		err = importRows(store)
+	}
+	if err != nil {
+		report.Error = err.Error()
+		return report, nil, err
+	}
+	return report, nil, nil
+}
+
+// HandleApiImportPhones imports a CSV spec sheet sent as the request body or
+// as the file field of a multipart form
+func HandleApiImportPhones(c *gin.Context) {
+	var options phoneImportOptions
+	validationError := &ValidationError{}
+	options.update = parseQueryBool(c, validationError, "update")
+	options.dryRun = parseQueryBool(c, validationError, "dryRun")
+	if !validationError.Empty() {
+		abortWithValidationError(c, validationError)
+		return
+	}
+
+	body := c.Request.Body
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			validationError.Add("file", "expected a CSV file: %v", err)
+			abortWithValidationError(c, validationError)
+			return
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	report, validationError, err := importPhonesCsv(body, phoneStore, options)
+	if validationError != nil {
+		abortWithValidationError(c, validationError)
+		return
+	}
+	if err != nil {
+		c.Error(err)
+		c.AbortWithStatusJSON(phoneStoreErrorStatus(err), report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func parseQueryBool(c *gin.Context, validationError *ValidationError, name string) bool {
+	query := c.Query(name)
+	if query == "" {
+		return false
+	}
+	val, err := strconv.ParseBool(query)
+	if err != nil {
+		validationError.Add(name, "must be true or false")
+	}
+	return val
+}
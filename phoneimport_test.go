@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParsePhoneImportHeader(t *testing.T) {
+	required := "width,length,height,speaker width,speaker height,speaker center"
+	tests := []struct {
+		name   string
+		header string
+		// the column names and units expected, as name or name:unit
+		want []string
+		// fields with errors, when the header is rejected
+		errors []string
+	}{
+		{
+			name:   "schema paths",
+			header: "id,width,length,height,speaker.width,speaker.height,speaker.center",
+			want:   []string{"id", "width", "length", "height", "speaker.width", "speaker.height", "speaker.center"},
+		},
+		{
+			name:   "spaces, underscores and case",
+			header: "Model,Display Name,Corner_Radius,WIDTH,Length,height,Speaker Width,speaker_height,SpeakerCenter",
+			want:   []string{"id", "displayName", "cornerRadius", "width", "length", "height", "speaker.width", "speaker.height", "speaker.center"},
+		},
+		{
+			name:   "units on columns",
+			header: "id,Width (in),length [CM],height( mm ),speaker width,speaker height,speaker center,Units",
+			want:   []string{"id", "width:in", "length:cm", "height:mm", "speaker.width", "speaker.height", "speaker.center", "unit"},
+		},
+		{
+			name:   "unknown column",
+			header: "id,colour," + required,
+			errors: []string{"header[1]"},
+		},
+		{
+			name:   "column given twice",
+			header: "id,model," + required,
+			errors: []string{"header[1]"},
+		},
+		{
+			name:   "unit on a column that takes none",
+			header: "id,year (mm),manufacturer (cm)," + required,
+			errors: []string{"header[1]", "header[2]"},
+		},
+		{
+			name:   "unknown or angle unit",
+			header: "id,width (furlong),length (deg),height,speaker width,speaker height,speaker center",
+			errors: []string{"header[1]", "header[2]"},
+		},
+		{
+			name:   "unreadable column name",
+			header: "id,(mm)," + required,
+			errors: []string{"header[1]"},
+		},
+		{
+			name:   "missing columns",
+			header: "width,length,height,speaker width",
+			errors: []string{"header", "header", "header"},
+		},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			columns, validationError := parsePhoneImportHeader(strings.Split(test.header, ","))
+			if len(test.errors) > 0 {
+				if validationError == nil {
+					t.Fatalf("got columns %+v, want errors on %v", columns, test.errors)
+				}
+				fields := []string{}
+				for j := 0; j < len(validationError.Errors); j++ {
+					fields = append(fields, validationError.Errors[j].Field)
+				}
+				if strings.Join(fields, ",") != strings.Join(test.errors, ",") {
+					t.Errorf("got errors %+v, want them on %v", validationError.Errors, test.errors)
+				}
+				return
+			}
+			if validationError != nil {
+				t.Fatalf("got errors %+v", validationError.Errors)
+			}
+			names := []string{}
+			for j := 0; j < len(columns); j++ {
+				name := columns[j].Name
+				if columns[j].Unit != "" {
+					name += ":" + columns[j].Unit
+				}
+				names = append(names, name)
+			}
+			if strings.Join(names, ",") != strings.Join(test.want, ",") {
+				t.Errorf("got %v, want %v", names, test.want)
+			}
+		})
+	}
+}
+
+func TestImportPhonesCsvUnits(t *testing.T) {
+	sheet := strings.Join([]string{
+		"id,unit,width,length (cm),height,speaker width,speaker height,speaker center",
+		"mm,,60,12,8,10,3,30",
+		"rowUnit,cm,6,12,0.8,1,0.3,3",
+		"inches,in,2.5,12.7,0.25,0.5,0.125,1",
+		"badUnit,furlong,6,12,0.8,1,0.3,3",
+		"angleUnit,deg,6,12,0.8,1,0.3,3",
+	}, "\n")
+	store := NewMemoryPhoneStore(nil)
+	report, validationError, err := importPhonesCsv(strings.NewReader(sheet), store, phoneImportOptions{})
+	if validationError != nil || err != nil {
+		t.Fatalf("got %v, %v", validationError, err)
+	}
+
+	// length carries its own unit, the other lengths take the row's
+	tests := []struct {
+		id     string
+		width  float64
+		length float64
+		height float64
+		center float64
+	}{
+		{id: "mm", width: 60, length: 120, height: 8, center: 30},
+		{id: "rowUnit", width: 60, length: 120, height: 8, center: 30},
+		{id: "inches", width: 63.5, length: 127, height: 6.35, center: 25.4},
+	}
+	if len(report.Imported) != len(tests) {
+		t.Errorf("imported %v, want %d phones", report.Imported, len(tests))
+	}
+	for i := 0; i < len(tests); i++ {
+		phoneConfig, err := store.Get(tests[i].id)
+		if err != nil {
+			t.Errorf("%s: %v", tests[i].id, err)
+			continue
+		}
+		got := []float64{phoneConfig.Width, phoneConfig.Length, phoneConfig.Height, phoneConfig.Speaker.Center}
+		want := []float64{tests[i].width, tests[i].length, tests[i].height, tests[i].center}
+		for j := 0; j < len(got); j++ {
+			if math.Abs(got[j]-want[j]) > 1e-9 {
+				t.Errorf("%s: got %v, want %v", tests[i].id, got, want)
+				break
+			}
+		}
+	}
+
+	rejected := map[string]string{}
+	for i := 0; i < len(report.Rejected); i++ {
+		rejected[report.Rejected[i].Id] = report.Rejected[i].Errors[0].Field
+	}
+	if len(rejected) != 2 || rejected["badUnit"] != "unit" || rejected["angleUnit"] != "unit" {
+		t.Errorf("got rejections %+v, want badUnit and angleUnit on unit", report.Rejected)
+	}
+}
+
+func TestImportPhonesCsvExisting(t *testing.T) {
+	sheet := "id,width,length,height,speaker width,speaker height,speaker center\n" +
+		"old,60,120,8,10,3,30\n" +
+		"new,60,120,8,10,3,30\n"
+	existing := *testPhoneConfig()
+
+	tests := []struct {
+		name     string
+		options  phoneImportOptions
+		imported []string
+		updated  []string
+		rejected int
+		oldWidth float64
+		hasNew   bool
+	}{
+		{name: "existing rows rejected", imported: []string{"new"}, rejected: 1, oldWidth: existing.Width, hasNew: true},
+		{name: "update", options: phoneImportOptions{update: true}, imported: []string{"new"}, updated: []string{"old"}, oldWidth: 60, hasNew: true},
+		{name: "dry run", options: phoneImportOptions{update: true, dryRun: true}, imported: []string{"new"}, updated: []string{"old"}, oldWidth: existing.Width},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			store := NewMemoryPhoneStore(map[string]PhoneConfig{"old": existing})
+			report, validationError, err := importPhonesCsv(strings.NewReader(sheet), store, test.options)
+			if validationError != nil || err != nil {
+				t.Fatalf("got %v, %v", validationError, err)
+			}
+			if strings.Join(report.Imported, ",") != strings.Join(test.imported, ",") || strings.Join(report.Updated, ",") != strings.Join(test.updated, ",") || len(report.Rejected) != test.rejected {
+				t.Errorf("got %+v", report)
+			}
+
+			phoneConfig, err := store.Get("old")
+			if err != nil || phoneConfig.Width != test.oldWidth {
+				t.Errorf("old: got %v, %v, want width %v", phoneConfig, err, test.oldWidth)
+			}
+			_, err = store.Get("new")
+			if (err == nil) != test.hasNew {
+				t.Errorf("new: got %v, want it stored %v", err, test.hasNew)
+			}
+		})
+	}
+}
+
+var errTestStoreFull = errors.New("store is full")
+
+// limitedPhoneStore counts the catalog reads a cache makes and refuses to
+// create the phone with id full
+type limitedPhoneStore struct {
+	*MemoryPhoneStore
+	lists int
+}
+
+func (store *limitedPhoneStore) List() ([]PhoneOption, error) {
+	store.lists++
+	return store.MemoryPhoneStore.List()
+}
+
+func (store *limitedPhoneStore) Create(id string, phoneConfig *PhoneConfig) error {
+	if id == "full" {
+		return errTestStoreFull
+	}
+	return store.MemoryPhoneStore.Create(id, phoneConfig)
+}
+
+func TestImportPhonesCsvCachedStore(t *testing.T) {
+	sheet := "id,width,length,height,speaker width,speaker height,speaker center\n" +
+		"a,60,120,8,10,3,30\n" +
+		"b,60,120,8,10,3,30\n" +
+		"full,60,120,8,10,3,30\n" +
+		"c,60,120,8,10,3,30\n"
+	store := &limitedPhoneStore{MemoryPhoneStore: NewMemoryPhoneStore(nil)}
+	cached, err := NewCachedPhoneStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.lists = 0
+
+	report, validationError, err := importPhonesCsv(strings.NewReader(sheet), cached, phoneImportOptions{})
+	if validationError != nil {
+		t.Fatal(validationError)
+	}
+	if !errors.Is(err, errTestStoreFull) {
+		t.Fatalf("got %v, want %v", err, errTestStoreFull)
+	}
+	// the rows before the failure were written and are reported
+	if report == nil || strings.Join(report.Imported, ",") != "a,b" || report.Error != errTestStoreFull.Error() {
+		t.Fatalf("got report %+v", report)
+	}
+	if store.lists != 1 {
+		t.Errorf("the cache reloaded %d times, want once", store.lists)
+	}
+	phoneOptions, _ := cached.List()
+	if len(phoneOptions) != 2 {
+		t.Errorf("the cache lists %d phones, want 2", len(phoneOptions))
+	}
+	_, err = cached.Get("c")
+	if !errors.Is(err, ErrPhoneNotFound) {
+		t.Errorf("c: got %v, want %v", err, ErrPhoneNotFound)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func validatePhoneId(validationError *ValidationError, id string) bool {
+	if !phoneIdPattern.MatchString(id) {
+		validationError.Add("id", "must be 1 to 64 letters, digits, '.', '_' or '-', starting with a letter or digit")
+		return false
+	}
+	return true
+}
+
+// validatePhoneConfig checks the dimensions, in mm, and that the speaker
+// opening fits on the face of the phone it sits on
+func validatePhoneConfig(validationError *ValidationError, phoneConfig *PhoneConfig) {
+	if phoneConfig.Year < 0 {
+		validationError.Add("year", "must be a positive year, got %d", phoneConfig.Year)
+	}
+
+	widthOk := validatePositive(validationError, "width", phoneConfig.Width)
+	lengthOk := validatePositive(validationError, "length", phoneConfig.Length)
+	heightOk := validatePositive(validationError, "height", phoneConfig.Height)
+	cornerRadiusOk := validateNonNegative(validationError, "cornerRadius", phoneConfig.CornerRadius)
+	validateNonNegative(validationError, "caseOffset", phoneConfig.CaseOffset)
+	speakerWidthOk := validatePositive(validationError, "speaker.width", phoneConfig.Speaker.Width)
+	speakerHeightOk := validatePositive(validationError, "speaker.height", phoneConfig.Speaker.Height)
+	speakerCenterOk := validatePositive(validationError, "speaker.center", phoneConfig.Speaker.Center)
+
+	if widthOk && lengthOk && cornerRadiusOk && 2*phoneConfig.CornerRadius > math.Min(phoneConfig.Width, phoneConfig.Length) {
+		validationError.Add("cornerRadius", "must not exceed half the phone width and length")
+	}
+
+	face := phoneConfig.Speaker.face()
+	if !isSpeakerFace(face) {
+		validationError.Add("speaker.face", "must be one of %s, got %q", strings.Join(speakerFaces, ", "), face)
+		return
+	}
+
+	// the extents of the face the opening sits on, across its width and height
+	across, acrossOk, acrossName := phoneConfig.Width, widthOk, "width"
+	up, upOk, upName := phoneConfig.Height, heightOk, "height"
+	switch face {
+	case speakerFaceLeft, speakerFaceRight:
+		across, acrossOk, acrossName = phoneConfig.Length, lengthOk, "length"
+	case speakerFaceFront, speakerFaceBack:
+		up, upOk, upName = phoneConfig.Length, lengthOk, "length"
+	}
+
+	if face == speakerFaceFront || face == speakerFaceBack {
+		speakerOffsetOk := validatePositive(validationError, "speaker.offset", phoneConfig.Speaker.Offset)
+		if upOk && speakerHeightOk && speakerOffsetOk {
+			if phoneConfig.Speaker.Offset-0.5*phoneConfig.Speaker.Height < 0 || phoneConfig.Speaker.Offset+0.5*phoneConfig.Speaker.Height > up {
+				validationError.Add("speaker.offset", "speaker opening must lie within the phone %s %g", upName, up)
+			}
+		}
+	} else {
+		if phoneConfig.Speaker.Offset != 0 {
+			validationError.Add("speaker.offset", "only applies to speakers on the front or back face")
+		}
+		if upOk && speakerHeightOk && phoneConfig.Speaker.Height > up {
+			validationError.Add("speaker.height", "must not exceed the phone %s %g", upName, up)
+		}
+	}
+	if acrossOk && speakerWidthOk && speakerCenterOk {
+		if phoneConfig.Speaker.Center-0.5*phoneConfig.Speaker.Width < 0 || phoneConfig.Speaker.Center+0.5*phoneConfig.Speaker.Width > across {
+			validationError.Add("speaker.center", "speaker opening must lie within the phone %s %g", acrossName, across)
+		}
+	}
+}
+
+func isSpeakerFace(face string) bool {
+	for i := 0; i < len(speakerFaces); i++ {
+		if face == speakerFaces[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func HandleApiGetPhone(c *gin.Context) {
+	id := c.Param("id")
+	validationError := &ValidationError{}
+	if !validatePhoneId(validationError, id) {
+		abortWithValidationError(c, validationError)
+		return
+	}
+
+	phoneConfig, err := phoneStore.Get(id)
+	if err != nil {
+		abortWithPhoneStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, phoneConfig)
+}
+
+func handleApiSavePhone(c *gin.Context, create bool) {
+	id := c.Param("id")
+	validationError := &ValidationError{}
+	validatePhoneId(validationError, id)
+
+	var phoneConfig PhoneConfig
+	err := c.ShouldBindJSON(&phoneConfig)
+	if err != nil {
+		validationError.Add("body", "malformed request body: %v", err)
+	} else {
+		validatePhoneConfig(validationError, &phoneConfig)
+	}
+	if !validationError.Empty() {
+		abortWithValidationError(c, validationError)
+		return
+	}
+
+	if create {
+		err = phoneStore.Create(id, &phoneConfig)
+	} else {
+		err = phoneStore.Update(id, &phoneConfig)
+	}
+	if errors.Is(err, ErrPhoneExists) {
+		validationError.Add("id", "phone %q already exists", id)
+		c.AbortWithStatusJSON(http.StatusConflict, validationError)
+		return
+	}
+	if err != nil {
+		abortWithPhoneStoreError(c, err)
+		return
+	}
+
+	if create {
+		c.Header("Location", basePath+apiVersionPath+"/phones/"+id)
+		c.JSON(http.StatusCreated, phoneConfig)
+		return
+	}
+	c.JSON(http.StatusOK, phoneConfig)
+}
+
+// abortWithPhoneStoreError maps PhoneStore errors to HTTP statuses
+func abortWithPhoneStoreError(c *gin.Context, err error) {
+	if errors.Is(err, ErrInvalidPhoneId) {
+		validationError := &ValidationError{}
+		validationError.Add("id", "%s", err.Error())
+		abortWithValidationError(c, validationError)
+		return
+	}
+	c.AbortWithError(phoneStoreErrorStatus(err), err)
+}
+
+func phoneStoreErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrPhoneNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrPhoneStoreReadOnly):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func HandleApiCreatePhone(c *gin.Context) {
+	handleApiSavePhone(c, true)
+}
+
+func HandleApiUpdatePhone(c *gin.Context) {
+	handleApiSavePhone(c, false)
+}
+
+func HandleApiDeletePhone(c *gin.Context) {
+	id := c.Param("id")
+	validationError := &ValidationError{}
+	if !validatePhoneId(validationError, id) {
+		abortWithValidationError(c, validationError)
+		return
+	}
+
+	err := phoneStore.Delete(id)
+	if err != nil {
+		abortWithPhoneStoreError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// parseFormFloat reads a numeric form field, recording a validation error
+// when it is missing or malformed
+func parseFormFloat(c *gin.Context, validationError *ValidationError, field string, formField string) float64 {
+	val, err := strconv.ParseFloat(c.PostForm(formField), 64)
+	if err != nil {
+		validationError.Add(field, "must be a number")
+		return 0
+	}
+	return val
+}
+
+// parseOptionalFormFloat is parseFormFloat for fields that default to zero
+// when left empty
+func parseOptionalFormFloat(c *gin.Context, validationError *ValidationError, field string, formField string) float64 {
+	if c.PostForm(formField) == "" {
+		return 0
+	}
+	return parseFormFloat(c, validationError, field, formField)
+}
+
+// HandleHtmxCreatePhone adds a phone from the spec sheet form. Errors are
+// rendered in place; on success the phone dropdown is told to reload.
+func HandleHtmxCreatePhone(c *gin.Context) {
+	id := c.PostForm("id")
+	validationError := &ValidationError{}
+	validatePhoneId(validationError, id)
+
+	var phoneConfig PhoneConfig
+	phoneConfig.DisplayName = strings.TrimSpace(c.PostForm("displayName"))
+	phoneConfig.Manufacturer = strings.TrimSpace(c.PostForm("manufacturer"))
+	year := parseOptionalFormFloat(c, validationError, "year", "year")
+	if year != math.Trunc(year) {
+		validationError.Add("year", "must be a whole number")
+	}
+	phoneConfig.Year = int(year)
+	phoneConfig.Width = parseFormFloat(c, validationError, "width", "width")
+	phoneConfig.Length = parseFormFloat(c, validationError, "length", "length")
+	phoneConfig.Height = parseFormFloat(c, validationError, "height", "height")
+	phoneConfig.CornerRadius = parseOptionalFormFloat(c, validationError, "cornerRadius", "cornerRadius")
+	phoneConfig.CaseOffset = parseOptionalFormFloat(c, validationError, "caseOffset", "caseOffset")
+	phoneConfig.Speaker.Face = c.PostForm("speakerFace")
+	phoneConfig.Speaker.Width = parseFormFloat(c, validationError, "speaker.width", "speakerWidth")
+	phoneConfig.Speaker.Height = parseFormFloat(c, validationError, "speaker.height", "speakerHeight")
+	phoneConfig.Speaker.Center = parseFormFloat(c, validationError, "speaker.center", "speakerCenter")
+	phoneConfig.Speaker.Offset = parseOptionalFormFloat(c, validationError, "speaker.offset", "speakerOffset")
+	if validationError.Empty() {
+		validatePhoneConfig(validationError, &phoneConfig)
+	}
+
+	if validationError.Empty() {
+		err := phoneStore.Create(id, &phoneConfig)
+		if errors.Is(err, ErrPhoneExists) {
+			validationError.Add("id", "phone %q already exists", id)
+		} else if errors.Is(err, ErrPhoneStoreReadOnly) {
+			validationError.Add("id", "%s", err.Error())
+		} else if err != nil {
+			c.String(http.StatusInternalServerError, "")
+			return
+		}
+	}
+
+	if !validationError.Empty() {
+		htmlErrors := "<ul>"
+		for i := 0; i < len(validationError.Errors); i++ {
+			htmlErrors += fmt.Sprintf("<li>%s: %s</li>", html.EscapeString(validationError.Errors[i].Field), html.EscapeString(validationError.Errors[i].Message))
+		}
+		htmlErrors += "</ul>"
+		c.String(http.StatusOK, htmlErrors)
+		return
+	}
+
+	c.Header("HX-Trigger", "phonesChanged")
+	c.String(http.StatusOK, fmt.Sprintf("Added %s", html.EscapeString(id)))
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestRouter mounts the JSON API the way runServe does
+func newTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerApiRoutes(r.Group(apiVersionPath))
+	return r
+}
+
+func serveTestRequest(r http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestPhoneCatalogEndpoints(t *testing.T) {
+	dir := t.TempDir()
+	phoneStore = NewDirPhoneStore(dir)
+	defer func() { phoneStore = nil }()
+	r := newTestRouter()
+
+	byteValue, err := json.Marshal(testPhoneConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := string(byteValue)
+	wider := strings.Replace(valid, `"width":58.57`, `"width":60`, 1)
+
+	// each step runs against the state the ones before it left
+	steps := []struct {
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{method: http.MethodGet, path: "/phones/pixel", want: http.StatusNotFound},
+		{method: http.MethodGet, path: "/phone?model=pixel", want: http.StatusNotFound},
+		{method: http.MethodGet, path: "/phone?model=..pixel", want: http.StatusBadRequest},
+		{method: http.MethodPut, path: "/phones/pixel", body: valid, want: http.StatusNotFound},
+		{method: http.MethodPost, path: "/phones/pixel", body: valid, want: http.StatusCreated},
+		{method: http.MethodPost, path: "/phones/pixel", body: valid, want: http.StatusConflict},
+		{method: http.MethodGet, path: "/phones/pixel", want: http.StatusOK},
+		{method: http.MethodGet, path: "/phone?model=pixel", want: http.StatusOK},
+		{method: http.MethodPut, path: "/phones/pixel", body: wider, want: http.StatusOK},
+		{method: http.MethodPut, path: "/phones/pixel", body: `{"width": -1}`, want: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/phones/..pixel", body: valid, want: http.StatusBadRequest},
+		{method: http.MethodDelete, path: "/phones/pixel", want: http.StatusNoContent},
+		{method: http.MethodDelete, path: "/phones/pixel", want: http.StatusNotFound},
+	}
+	for i := 0; i < len(steps); i++ {
+		step := steps[i]
+		recorder := serveTestRequest(r, step.method, apiVersionPath+step.path, step.body)
+		if recorder.Code != step.want {
+			t.Fatalf("%s %s: got status %d, want %d: %s", step.method, step.path, recorder.Code, step.want, recorder.Body.String())
+		}
+
+		if step.method == http.MethodPost && step.want == http.StatusCreated && recorder.Header().Get("Location") != apiVersionPath+step.path {
+			t.Errorf("got Location %q", recorder.Header().Get("Location"))
+		}
+		if step.method == http.MethodPut && step.want == http.StatusOK {
+			phoneConfig, err := phoneStore.Get("pixel")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if phoneConfig.Width != 60 {
+				t.Errorf("after the update the catalog holds width %g, want 60", phoneConfig.Width)
+			}
+		}
+	}
+
+	// writes go through a temporary file that is renamed into place
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("catalog directory still holds %v", entries)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "phone.xml")
+	contents := []string{"first", "second"}
+	for i := 0; i < len(contents); i++ {
+		err := writeFileAtomic(path, []byte(contents[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		byteValue, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(byteValue) != contents[i] {
+			t.Errorf("got %q, want %q", byteValue, contents[i])
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d files, want only phone.xml", len(entries))
+	}
+
+	err = writeFileAtomic(filepath.Join(dir, "missing", "phone.xml"), []byte("x"))
+	if err == nil {
+		t.Error("writing into a missing directory succeeded")
+	}
+}
+
+func TestValidatePhoneConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(phoneConfig *PhoneConfig)
+		fields []string
+	}{
+		{name: "valid", change: func(*PhoneConfig) {}},
+		{name: "negative year", fields: []string{"year"}, change: func(phoneConfig *PhoneConfig) {
+			phoneConfig.Year = -1
+		}},
+		{name: "corner radius wider than the phone", fields: []string{"cornerRadius"}, change: func(phoneConfig *PhoneConfig) {
+			phoneConfig.CornerRadius = 30
+		}},
+		{name: "negative case", fields: []string{"caseOffset"}, change: func(phoneConfig *PhoneConfig) {
+			phoneConfig.CaseOffset = -1
+		}},
+		{name: "unknown face", fields: []string{"speaker.face"}, change: func(phoneConfig *PhoneConfig) {
+			phoneConfig.Speaker.Face = "side"
+		}},
+		{name: "offset on an edge", fields: []string{"speaker.offset"}, change: func(phoneConfig *PhoneConfig) {
+			phoneConfig.Speaker.Offset = 10
+		}},
+		{name: "back face needs an offset", fields: []string{"speaker.offset"}, change: func(phoneConfig *PhoneConfig) {
+			phoneConfig.Speaker.Face = speakerFaceBack
+		}},
+		{name: "back face opening past the top", fields: []string{"speaker.offset"}, change: func(phoneConfig *PhoneConfig) {
+			phoneConfig.Speaker.Face = speakerFaceBack
+			phoneConfig.Speaker.Offset = 123
+		}},
+		{name: "side face measured along the length", change: func(phoneConfig *PhoneConfig) {
+			phoneConfig.Speaker.Face = speakerFaceLeft
+			phoneConfig.Speaker.Center = 100
+		}},
+		{name: "speaker taller than the edge", fields: []string{"speaker.height"}, change: func(phoneConfig *PhoneConfig) {
+			phoneConfig.Speaker.Height = 8
+		}},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			phoneConfig := testPhoneConfig()
+			test.change(phoneConfig)
+			validationError := &ValidationError{Errors: []FieldError{}}
+			validatePhoneConfig(validationError, phoneConfig)
+			fields := []string{}
+			for j := 0; j < len(validationError.Errors); j++ {
+				fields = append(fields, validationError.Errors[j].Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(test.fields, ",") {
+				t.Errorf("got errors %+v, want them on %v", validationError.Errors, test.fields)
+			}
+		})
+	}
+}
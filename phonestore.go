@@ -0,0 +1,496 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// phone ids become file names, so they are restricted to a safe alphabet
+var phoneIdPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+var ErrInvalidPhoneId = errors.New("invalid phone id")
+var ErrPhoneNotFound = errors.New("phone not found")
+var ErrPhoneExists = errors.New("phone already exists")
+var ErrPhoneStoreReadOnly = errors.New("phone catalog is read-only")
+
+//go:embed phones
+var embeddedPhones embed.FS
+
+// PhoneStore is the phone catalog. Every handler goes through it rather than
+// the file system, and ids are the model names without a file extension.
+type PhoneStore interface {
+	List() ([]PhoneOption, error)
+	Get(id string) (*PhoneConfig, error)
+	// Create fails with ErrPhoneExists when the id is taken
+	Create(id string, phoneConfig *PhoneConfig) error
+	// Update fails with ErrPhoneNotFound unless the id exists
+	Update(id string, phoneConfig *PhoneConfig) error
+	Delete(id string) error
+}
+
+var phoneStore PhoneStore
+
+func phoneOption(id string) PhoneOption {
+	var option PhoneOption
+	option.Name = id
+	option.Filename = id + ".xml"
+	return option
+}
+
+// describePhoneOption copies the catalog metadata of a phone into its option
+func describePhoneOption(option *PhoneOption, phoneConfig *PhoneConfig) {
+	option.DisplayName = phoneConfig.DisplayName
+	option.Manufacturer = phoneConfig.Manufacturer
+	option.Year = phoneConfig.Year
+}
+
+// phoneIdFromFilename maps the filename used in SimulationInput to a store id
+func phoneIdFromFilename(filename string) string {
+	if isPhoneDefinition(filename) {
+		return strings.TrimSuffix(filename, path.Ext(filename))
+	}
+	return filename
+}
+
+// DirPhoneStore keeps one definition file per phone in a directory
+type DirPhoneStore struct {
+	dir string
+	// serialises writes so create and update can check for the file first
+	lock sync.Mutex
+}
+
+func NewDirPhoneStore(dir string) *DirPhoneStore {
+	return &DirPhoneStore{dir: dir}
+}
+
+// path resolves the file for id and extension inside the catalog directory.
+// Ids are validated before they reach the file system and the result is
+// checked to stay within the directory.
+func (store *DirPhoneStore) path(id string, extension string) (string, error) {
+	if !phoneIdPattern.MatchString(id) {
+		return "", ErrInvalidPhoneId
+	}
+
+	name := id + extension
+	if !filepath.IsLocal(name) {
+		return "", ErrInvalidPhoneId
+	}
+	path := filepath.Join(store.dir, name)
+	relative, err := filepath.Rel(store.dir, path)
+	if err != nil || relative != name {
+		return "", ErrInvalidPhoneId
+	}
+	return path, nil
+}
+
+// find returns the definition file for id, trying the formats in the order
+// of phoneDefinitionExtensions
+func (store *DirPhoneStore) find(id string) (string, error) {
+	for i := 0; i < len(phoneDefinitionExtensions); i++ {
+		path, err := store.path(id, phoneDefinitionExtensions[i])
+		if err != nil {
+			return "", err
+		}
+
+		info, err := os.Lstat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		// symlinks could point anywhere, the catalog only serves regular files
+		if info.Mode().IsRegular() {
+			return path, nil
+		}
+	}
+	return "", ErrPhoneNotFound
+}
+
+func (store *DirPhoneStore) List() ([]PhoneOption, error) {
+	return listPhoneFiles(os.DirFS(store.dir), ".")
+}
+
+func (store *DirPhoneStore) Get(id string) (*PhoneConfig, error) {
+	path, err := store.find(id)
+	if err != nil {
+		return nil, err
+	}
+
+	byteValue, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parsePhoneDefinition(filepath.Base(path), byteValue)
+}
+
+func (store *DirPhoneStore) Create(id string, phoneConfig *PhoneConfig) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	_, err := store.find(id)
+	if err == nil {
+		return ErrPhoneExists
+	}
+	if !errors.Is(err, ErrPhoneNotFound) {
+		return err
+	}
+
+	path, err := store.path(id, ".xml")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(store.dir, 0o755)
+	if err != nil {
+		return err
+	}
+	return store.write(path, phoneConfig)
+}
+
+// Update rewrites the definition in the format it already has
+func (store *DirPhoneStore) Update(id string, phoneConfig *PhoneConfig) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	path, err := store.find(id)
+	if err != nil {
+		return err
+	}
+	return store.write(path, phoneConfig)
+}
+
+func (store *DirPhoneStore) write(path string, phoneConfig *PhoneConfig) error {
+	byteValue, err := marshalPhoneDefinition(path, phoneConfig)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, byteValue)
+}
+
+func (store *DirPhoneStore) Delete(id string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	path, err := store.find(id)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// FSPhoneStore serves a read-only catalog, such as the one embedded in the
+// binary, from the definition files at the root of dir within fsys
+type FSPhoneStore struct {
+	fsys fs.FS
+	dir  string
+}
+
+func NewFSPhoneStore(fsys fs.FS, dir string) *FSPhoneStore {
+	return &FSPhoneStore{fsys: fsys, dir: dir}
+}
+
+// NewEmbeddedPhoneStore serves the catalog compiled into the binary
+func NewEmbeddedPhoneStore() *FSPhoneStore {
+	return NewFSPhoneStore(embeddedPhones, "phones")
+}
+
+func (store *FSPhoneStore) List() ([]PhoneOption, error) {
+	return listPhoneFiles(store.fsys, store.dir)
+}
+
+func (store *FSPhoneStore) Get(id string) (*PhoneConfig, error) {
+	if !phoneIdPattern.MatchString(id) {
+		return nil, ErrInvalidPhoneId
+	}
+
+	for i := 0; i < len(phoneDefinitionExtensions); i++ {
+		name := id + phoneDefinitionExtensions[i]
+		byteValue, err := fs.ReadFile(store.fsys, path.Join(store.dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return parsePhoneDefinition(name, byteValue)
+	}
+	return nil, ErrPhoneNotFound
+}
+
+func (store *FSPhoneStore) Create(id string, phoneConfig *PhoneConfig) error {
+	return ErrPhoneStoreReadOnly
+}
+
+func (store *FSPhoneStore) Update(id string, phoneConfig *PhoneConfig) error {
+	return ErrPhoneStoreReadOnly
+}
+
+func (store *FSPhoneStore) Delete(id string) error {
+	return ErrPhoneStoreReadOnly
+}
+
+// OverlayPhoneStore layers a writable directory over a read-only catalog,
+// normally the one built into the binary. Phones in the directory shadow
+// those of the same id underneath, and a missing directory is an empty one.
+type OverlayPhoneStore struct {
+	primary  *DirPhoneStore
+	fallback PhoneStore
+}
+
+func NewOverlayPhoneStore(primary *DirPhoneStore, fallback PhoneStore) *OverlayPhoneStore {
+	return &OverlayPhoneStore{primary: primary, fallback: fallback}
+}
+
+func (store *OverlayPhoneStore) List() ([]PhoneOption, error) {
+	phoneOptions, err := store.primary.List()
+	if errors.Is(err, fs.ErrNotExist) {
+		phoneOptions = []PhoneOption{}
+	} else if err != nil {
+		return nil, err
+	}
+
+	fallbackOptions, err := store.fallback.List()
+	if err != nil {
+		return nil, err
+	}
+	shadowed := map[string]bool{}
+	for i := 0; i < len(phoneOptions); i++ {
+		shadowed[phoneIdFromFilename(phoneOptions[i].Filename)] = true
+	}
+	for i := 0; i < len(fallbackOptions); i++ {
+		if !shadowed[phoneIdFromFilename(fallbackOptions[i].Filename)] {
+			phoneOptions = append(phoneOptions, fallbackOptions[i])
+		}
+	}
+
+	sort.SliceStable(phoneOptions, func(i, j int) bool {
+		return phoneOptions[i].Filename < phoneOptions[j].Filename
+	})
+	return phoneOptions, nil
+}
+
+func (store *OverlayPhoneStore) Get(id string) (*PhoneConfig, error) {
+	phoneConfig, err := store.primary.Get(id)
+	if errors.Is(err, ErrPhoneNotFound) {
+		return store.fallback.Get(id)
+	}
+	return phoneConfig, err
+}
+
+func (store *OverlayPhoneStore) Create(id string, phoneConfig *PhoneConfig) error {
+	_, err := store.fallback.Get(id)
+	if err == nil {
+		return ErrPhoneExists
+	}
+	if !errors.Is(err, ErrPhoneNotFound) {
+		var definitionErrors PhoneDefinitionErrors
+		if errors.As(err, &definitionErrors) {
+			return ErrPhoneExists
+		}
+		return err
+	}
+	return store.primary.Create(id, phoneConfig)
+}
+
+// Update edits the directory copy, copying a phone from the catalog
+// underneath into the directory on its first edit
+func (store *OverlayPhoneStore) Update(id string, phoneConfig *PhoneConfig) error {
+	err := store.primary.Update(id, phoneConfig)
+	if !errors.Is(err, ErrPhoneNotFound) {
+		return err
+	}
+
+	_, err = store.fallback.Get(id)
+	var definitionErrors PhoneDefinitionErrors
+	if err != nil && !errors.As(err, &definitionErrors) {
+		return err
+	}
+	return store.primary.Create(id, phoneConfig)
+}
+
+// Delete removes the directory copy. Phones only in the catalog underneath
+// cannot be deleted; deleting a shadowing copy reveals the original again.
+func (store *OverlayPhoneStore) Delete(id string) error {
+	err := store.primary.Delete(id)
+	if !errors.Is(err, ErrPhoneNotFound) {
+		return err
+	}
+
+	_, err = store.fallback.Get(id)
+	var definitionErrors PhoneDefinitionErrors
+	if err == nil || errors.As(err, &definitionErrors) {
+		return ErrPhoneStoreReadOnly
+	}
+	return err
+}
+
+// listPhoneFiles lists every definition in dir. Invalid definitions are
+// listed with their errors rather than failing the whole catalog, and when an
+// id has definitions in several formats the ones Get ignores are flagged.
+func listPhoneFiles(fsys fs.FS, dir string) ([]PhoneOption, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// visit formats in lookup order so the file Get would pick comes first
+	sort.SliceStable(entries, func(i, j int) bool {
+		return phoneExtensionRank(entries[i].Name()) < phoneExtensionRank(entries[j].Name())
+	})
+
+	phoneOptions := []PhoneOption{}
+	seen := map[string]string{}
+	for i := 0; i < len(entries); i++ {
+		name := entries[i].Name()
+		if !entries[i].Type().IsRegular() || !isPhoneDefinition(name) {
+			continue
+		}
+		id := strings.TrimSuffix(name, path.Ext(name))
+		if !phoneIdPattern.MatchString(id) {
+			continue
+		}
+
+		option := phoneOption(id)
+		option.Filename = name
+		if other, ok := seen[id]; ok {
+			option.Errors = PhoneDefinitionErrors{{File: name, Message: fmt.Sprintf("ignored, %s defines the same phone", other)}}
+			phoneOptions = append(phoneOptions, option)
+			continue
+		}
+		seen[id] = name
+
+		byteValue, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		phoneConfig, err := parsePhoneDefinition(name, byteValue)
+		var definitionErrors PhoneDefinitionErrors
+		if errors.As(err, &definitionErrors) {
+			option.Errors = definitionErrors
+		} else if err != nil {
+			return nil, err
+		} else {
+			describePhoneOption(&option, phoneConfig)
+		}
+		phoneOptions = append(phoneOptions, option)
+	}
+
+	sort.SliceStable(phoneOptions, func(i, j int) bool {
+		return phoneOptions[i].Filename < phoneOptions[j].Filename
+	})
+	return phoneOptions, nil
+}
+
+func phoneExtensionRank(name string) int {
+	for i := 0; i < len(phoneDefinitionExtensions); i++ {
+		if path.Ext(name) == phoneDefinitionExtensions[i] {
+			return i
+		}
+	}
+	return len(phoneDefinitionExtensions)
+}
+
+// MemoryPhoneStore keeps the catalog in a map, for tests and scratch sessions
+type MemoryPhoneStore struct {
+	phones map[string]PhoneConfig
+	lock   sync.RWMutex
+}
+
+func NewMemoryPhoneStore(phones map[string]PhoneConfig) *MemoryPhoneStore {
+	store := &MemoryPhoneStore{phones: map[string]PhoneConfig{}}
+	for id, phoneConfig := range phones {
+		store.phones[id] = phoneConfig
+	}
+	return store
+}
+
+func (store *MemoryPhoneStore) List() ([]PhoneOption, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	ids := make([]string, 0, len(store.phones))
+	for id := range store.phones {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	phoneOptions := make([]PhoneOption, len(ids))
+	for i := 0; i < len(ids); i++ {
+		phoneOptions[i] = phoneOption(ids[i])
+		phoneConfig := store.phones[ids[i]]
+		describePhoneOption(&phoneOptions[i], &phoneConfig)
+	}
+	return phoneOptions, nil
+}
+
+func (store *MemoryPhoneStore) Get(id string) (*PhoneConfig, error) {
+	if !phoneIdPattern.MatchString(id) {
+		return nil, ErrInvalidPhoneId
+	}
+
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	phoneConfig, ok := store.phones[id]
+	if !ok {
+		return nil, ErrPhoneNotFound
+	}
+	return &phoneConfig, nil
+}
+
+func (store *MemoryPhoneStore) Create(id string, phoneConfig *PhoneConfig) error {
+	if !phoneIdPattern.MatchString(id) {
+		return ErrInvalidPhoneId
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	_, ok := store.phones[id]
+	if ok {
+		return ErrPhoneExists
+	}
+	store.phones[id] = *phoneConfig
+	return nil
+}
+
+func (store *MemoryPhoneStore) Update(id string, phoneConfig *PhoneConfig) error {
+	if !phoneIdPattern.MatchString(id) {
+		return ErrInvalidPhoneId
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	_, ok := store.phones[id]
+	if !ok {
+		return ErrPhoneNotFound
+	}
+	store.phones[id] = *phoneConfig
+	return nil
+}
+
+func (store *MemoryPhoneStore) Delete(id string) error {
+	if !phoneIdPattern.MatchString(id) {
+		return ErrInvalidPhoneId
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	_, ok := store.phones[id]
+	if !ok {
+		return ErrPhoneNotFound
+	}
+	delete(store.phones, id)
+	return nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDirPhoneStoreRejectsIds(t *testing.T) {
+	store := NewDirPhoneStore(t.TempDir())
+	phoneConfig := testPhoneConfig()
+
+	ids := []string{
+		"",
+		".",
+		"..",
+		"a/b",
+		"../a",
+		`a\b`,
+		".hidden",
+		"-leading",
+		"a b",
+		strings.Repeat("a", 65),
+	}
+	for i := 0; i < len(ids); i++ {
+		id := ids[i]
+		t.Run(id, func(t *testing.T) {
+			_, err := store.Get(id)
+			if !errors.Is(err, ErrInvalidPhoneId) {
+				t.Errorf("Get: got %v, want %v", err, ErrInvalidPhoneId)
+			}
+			err = store.Create(id, phoneConfig)
+			if !errors.Is(err, ErrInvalidPhoneId) {
+				t.Errorf("Create: got %v, want %v", err, ErrInvalidPhoneId)
+			}
+			err = store.Update(id, phoneConfig)
+			if !errors.Is(err, ErrInvalidPhoneId) {
+				t.Errorf("Update: got %v, want %v", err, ErrInvalidPhoneId)
+			}
+			err = store.Delete(id)
+			if !errors.Is(err, ErrInvalidPhoneId) {
+				t.Errorf("Delete: got %v, want %v", err, ErrInvalidPhoneId)
+			}
+		})
+	}
+
+	// the longest id allowed still works
+	id := strings.Repeat("a", 64)
+	err := store.Create(id, phoneConfig)
+	if err != nil {
+		t.Fatalf("Create %d characters: %v", len(id), err)
+	}
+	_, err = store.Get(id)
+	if err != nil {
+		t.Errorf("Get %d characters: %v", len(id), err)
+	}
+}
+
+func TestDirPhoneStoreIgnoresSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	dir := t.TempDir()
+	target := filepath.Join(outside, "secret.xml")
+	byteValue, err := marshalPhoneDefinition(target, testPhoneConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(target, byteValue, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink(target, filepath.Join(dir, "linked.xml"))
+	if err != nil {
+		t.Skipf("cannot create symlinks: %v", err)
+	}
+
+	store := NewDirPhoneStore(dir)
+	_, err = store.Get("linked")
+	if !errors.Is(err, ErrPhoneNotFound) {
+		t.Errorf("Get: got %v, want %v", err, ErrPhoneNotFound)
+	}
+	phoneOptions, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(phoneOptions); i++ {
+		if phoneOptions[i].Name == "linked" {
+			t.Errorf("List includes the symlink %s", phoneOptions[i].Filename)
+		}
+	}
+}
+
+func TestPhoneStores(t *testing.T) {
+	stores := map[string]PhoneStore{
+		"dir":    NewDirPhoneStore(t.TempDir()),
+		"memory": NewMemoryPhoneStore(nil),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			phoneConfig := *testPhoneConfig()
+			edited := phoneConfig
+			edited.Width += 1
+
+			// each step runs against the state the ones before it left
+			steps := []struct {
+				op      string
+				want    error
+				wantGet *PhoneConfig
+			}{
+				{op: "update", want: ErrPhoneNotFound},
+				{op: "delete", want: ErrPhoneNotFound},
+				{op: "create", wantGet: &phoneConfig},
+				{op: "create", want: ErrPhoneExists, wantGet: &phoneConfig},
+				{op: "update", wantGet: &edited},
+				{op: "delete"},
+			}
+			for i := 0; i < len(steps); i++ {
+				step := steps[i]
+				var err error
+				switch step.op {
+				case "create":
+					err = store.Create("pixel", &phoneConfig)
+				case "update":
+					err = store.Update("pixel", &edited)
+				case "delete":
+					err = store.Delete("pixel")
+				}
+				if !errors.Is(err, step.want) {
+					t.Fatalf("step %d %s: got %v, want %v", i, step.op, err, step.want)
+				}
+
+				got, err := store.Get("pixel")
+				if step.wantGet == nil {
+					if !errors.Is(err, ErrPhoneNotFound) {
+						t.Errorf("step %d %s: Get got %v, want %v", i, step.op, err, ErrPhoneNotFound)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("step %d %s: Get: %v", i, step.op, err)
+				}
+				if *got != *step.wantGet {
+					t.Errorf("step %d %s: Get got %+v, want %+v", i, step.op, *got, *step.wantGet)
+				}
+
+				phoneOptions, err := store.List()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(phoneOptions) != 1 || phoneOptions[0].Name != "pixel" || phoneOptions[0].Filename != "pixel.xml" {
+					t.Errorf("step %d %s: List got %+v", i, step.op, phoneOptions)
+				}
+			}
+		})
+	}
+}
+
+func TestEmbeddedPhoneStore(t *testing.T) {
+	store := NewEmbeddedPhoneStore()
+	phoneConfig, err := store.Get("iPhone5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *phoneConfig != *testPhoneConfig() {
+		t.Errorf("got %+v, want %+v", *phoneConfig, *testPhoneConfig())
+	}
+	_, err = store.Get("missing")
+	if !errors.Is(err, ErrPhoneNotFound) {
+		t.Errorf("Get: got %v, want %v", err, ErrPhoneNotFound)
+	}
+	err = store.Create("pixel", phoneConfig)
+	if !errors.Is(err, ErrPhoneStoreReadOnly) {
+		t.Errorf("Create: got %v, want %v", err, ErrPhoneStoreReadOnly)
+	}
+}
+
+func TestOverlayPhoneStore(t *testing.T) {
+	dir := t.TempDir()
+	builtin := *testPhoneConfig()
+	edited := builtin
+	edited.Width += 1
+	store := NewOverlayPhoneStore(NewDirPhoneStore(dir), NewMemoryPhoneStore(map[string]PhoneConfig{"builtin": builtin}))
+
+	// each step runs against the state the ones before it left
+	steps := []struct {
+		name    string
+		op      string
+		id      string
+		want    error
+		inDir   bool
+		wantGet *PhoneConfig
+	}{
+		{name: "create over a built in phone", op: "create", id: "builtin", want: ErrPhoneExists, wantGet: &builtin},
+		{name: "create a new phone", op: "create", id: "added", inDir: true, wantGet: &edited},
+		{name: "create it again", op: "create", id: "added", want: ErrPhoneExists, inDir: true, wantGet: &edited},
+		{name: "update a built in phone copies it", op: "update", id: "builtin", inDir: true, wantGet: &edited},
+		{name: "update the copy", op: "update", id: "builtin", inDir: true, wantGet: &edited},
+		{name: "delete the copy reveals the original", op: "delete", id: "builtin", wantGet: &builtin},
+		{name: "delete a built in phone", op: "delete", id: "builtin", want: ErrPhoneStoreReadOnly, wantGet: &builtin},
+		{name: "delete a new phone", op: "delete", id: "added"},
+		{name: "update a missing phone", op: "update", id: "missing", want: ErrPhoneNotFound},
+		{name: "delete a missing phone", op: "delete", id: "missing", want: ErrPhoneNotFound},
+	}
+	for i := 0; i < len(steps); i++ {
+		step := steps[i]
+		var err error
+		switch step.op {
+		case "create":
+			err = store.Create(step.id, &edited)
+		case "update":
+			err = store.Update(step.id, &edited)
+		case "delete":
+			err = store.Delete(step.id)
+		}
+		if !errors.Is(err, step.want) {
+			t.Fatalf("%s: got %v, want %v", step.name, err, step.want)
+		}
+
+		_, err = os.Stat(filepath.Join(dir, step.id+".xml"))
+		if step.inDir != (err == nil) {
+			t.Errorf("%s: file in the directory is %v, want %v", step.name, err == nil, step.inDir)
+		}
+
+		phoneConfig, err := store.Get(step.id)
+		if step.wantGet == nil {
+			if !errors.Is(err, ErrPhoneNotFound) {
+				t.Errorf("%s: Get got %v, want %v", step.name, err, ErrPhoneNotFound)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Get: %v", step.name, err)
+		} else if *phoneConfig != *step.wantGet {
+			t.Errorf("%s: Get got %+v, want %+v", step.name, *phoneConfig, *step.wantGet)
+		}
+	}
+}
+
+func TestOverlayPhoneStoreList(t *testing.T) {
+	dir := t.TempDir()
+	builtin := *testPhoneConfig()
+	store := NewOverlayPhoneStore(NewDirPhoneStore(filepath.Join(dir, "missing")), NewMemoryPhoneStore(map[string]PhoneConfig{"a": builtin, "c": builtin}))
+
+	// a directory that does not exist yet is an empty one
+	phoneOptions, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(phoneOptions) != 2 {
+		t.Fatalf("got %d phones, want 2", len(phoneOptions))
+	}
+
+	// a shadowing copy is listed once, in order with the others
+	err = store.Update("c", &builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Create("b", &builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	phoneOptions, err = store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for i := 0; i < len(phoneOptions); i++ {
+		names = append(names, phoneOptions[i].Name)
+	}
+	if strings.Join(names, ",") != "a,b,c" {
+		t.Errorf("got %s, want a,b,c", strings.Join(names, ","))
+	}
+}
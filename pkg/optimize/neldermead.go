@@ -0,0 +1,139 @@
+package optimize
+
+import (
+	"math"
+	"sort"
+)
+
+type Settings struct {
+	MaxEvaluations int
+	// stop once the objective values across the simplex differ by less than this
+	Tolerance float64
+	// edge length of the initial simplex around the starting point
+	InitialStep float64
+}
+
+type Result struct {
+	X           []float64
+	F           float64
+	Evaluations int
+	Iterations  int
+}
+
+type vertex struct {
+	x []float64
+	f float64
+}
+
+func combine(out []float64, a []float64, b []float64, t float64) {
+	for i := 0; i < len(out); i++ {
+		out[i] = a[i] + t*(b[i]-a[i])
+	}
+}
+
+// NelderMead minimizes f starting from x0 using the downhill simplex method
+// with the standard reflection, expansion, contraction and shrink steps. f is
+// called at most MaxEvaluations times; points past that budget count as +Inf
+// and the search stops.
+func NelderMead(f func([]float64) float64, x0 []float64, settings Settings) Result {
+	n := len(x0)
+	evaluations := 0
+	evaluate := func(x []float64) float64 {
+		if evaluations >= settings.MaxEvaluations {
+			return math.Inf(1)
+		}
+		evaluations++
+		fx := f(x)
+		if math.IsNaN(fx) {
+			return math.Inf(1)
+		}
+		return fx
+	}
+
+	simplex := make([]vertex, n+1)
+	for i := 0; i <= n; i++ {
+		x := make([]float64, n)
+		copy(x, x0)
+		if i > 0 {
+			x[i-1] += settings.InitialStep
+		}
+		simplex[i] = vertex{x: x, f: evaluate(x)}
+	}
+
+	centroid := make([]float64, n)
+	reflected := make([]float64, n)
+	expanded := make([]float64, n)
+	contracted := make([]float64, n)
+
+	iterations := 0
+	for evaluations < settings.MaxEvaluations {
+		sort.SliceStable(simplex, func(i, j int) bool {
+			return simplex[i].f < simplex[j].f
+		})
+
+		if math.Abs(simplex[n].f-simplex[0].f) <= settings.Tolerance {
+			break
+		}
+		iterations++
+
+		for i := 0; i < n; i++ {
+			centroid[i] = 0
+			for j := 0; j < n; j++ {
+				centroid[i] += simplex[j].x[i] / float64(n)
+			}
+		}
+
+		worst := &simplex[n]
+
+		combine(reflected, centroid, worst.x, -1)
+		fReflected := evaluate(reflected)
+
+		if fReflected < simplex[0].f {
+			combine(expanded, centroid, worst.x, -2)
+			fExpanded := evaluate(expanded)
+			if fExpanded < fReflected {
+				copy(worst.x, expanded)
+				worst.f = fExpanded
+			} else {
+				copy(worst.x, reflected)
+				worst.f = fReflected
+			}
+			continue
+		}
+
+		if fReflected < simplex[n-1].f {
+			copy(worst.x, reflected)
+			worst.f = fReflected
+			continue
+		}
+
+		// contract towards the better of the reflected and worst points
+		if fReflected < worst.f {
+			combine(contracted, centroid, reflected, 0.5)
+		} else {
+			combine(contracted, centroid, worst.x, 0.5)
+		}
+		fContracted := evaluate(contracted)
+		if fContracted < math.Min(fReflected, worst.f) {
+			copy(worst.x, contracted)
+			worst.f = fContracted
+			continue
+		}
+
+		for i := 1; i <= n; i++ {
+			combine(simplex[i].x, simplex[0].x, simplex[i].x, 0.5)
+			simplex[i].f = evaluate(simplex[i].x)
+		}
+	}
+
+	sort.SliceStable(simplex, func(i, j int) bool {
+		return simplex[i].f < simplex[j].f
+	})
+
+	return Result{
+		X:           simplex[0].x,
+		F:           simplex[0].f,
+		Evaluations: evaluations,
+		Iterations:  iterations,
+	}
+}
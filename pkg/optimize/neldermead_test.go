@@ -0,0 +1,66 @@
+package optimize
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNelderMead(t *testing.T) {
+	tests := []struct {
+		name string
+		f    func([]float64) float64
+		x0   []float64
+		want []float64
+	}{
+		{name: "one dimension", x0: []float64{0}, want: []float64{3}, f: func(x []float64) float64 {
+			return (x[0] - 3) * (x[0] - 3)
+		}},
+		{name: "quadratic bowl", x0: []float64{0, 0}, want: []float64{1, -2}, f: func(x []float64) float64 {
+			return (x[0]-1)*(x[0]-1) + 4*(x[1]+2)*(x[1]+2)
+		}},
+		{name: "rosenbrock", x0: []float64{-1.2, 1}, want: []float64{1, 1}, f: func(x []float64) float64 {
+			return 100*(x[1]-x[0]*x[0])*(x[1]-x[0]*x[0]) + (1-x[0])*(1-x[0])
+		}},
+		{name: "NaN outside the domain", x0: []float64{2}, want: []float64{1}, f: func(x []float64) float64 {
+			if x[0] < 0.5 {
+				return math.NaN()
+			}
+			return (x[0] - 1) * (x[0] - 1)
+		}},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			result := NelderMead(test.f, test.x0, Settings{MaxEvaluations: 2000, Tolerance: 1e-14, InitialStep: 0.5})
+			for j := 0; j < len(test.want); j++ {
+				if math.Abs(result.X[j]-test.want[j]) > 1e-3 {
+					t.Errorf("got minimum at %v after %d evaluations, want %v", result.X, result.Evaluations, test.want)
+					break
+				}
+			}
+			if result.F != test.f(result.X) {
+				t.Errorf("got F %g, want f(X) %g", result.F, test.f(result.X))
+			}
+		})
+	}
+}
+
+func TestNelderMeadBudget(t *testing.T) {
+	// budgets smaller than the initial simplex and ones that run out part way
+	// through an iteration
+	budgets := []int{1, 3, 7, 20}
+	for i := 0; i < len(budgets); i++ {
+		calls := 0
+		bowl := func(x []float64) float64 {
+			calls++
+			return x[0]*x[0] + x[1]*x[1] + x[2]*x[2] + x[3]*x[3]
+		}
+		result := NelderMead(bowl, []float64{1, 2, 3, 4}, Settings{MaxEvaluations: budgets[i], Tolerance: 1e-14, InitialStep: 0.5})
+		if calls > budgets[i] || result.Evaluations != calls {
+			t.Errorf("budget %d: f was called %d times, reported %d", budgets[i], calls, result.Evaluations)
+		}
+		if math.IsInf(result.F, 1) || result.F != bowl(result.X) {
+			t.Errorf("budget %d: got F %g for X %v", budgets[i], result.F, result.X)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Package units converts the lengths and angles of simulation inputs. Lengths
+// are held in millimetres and angles in radians, and every conversion names
+// its unit so that an unknown or mismatched unit is an error rather than a
+// silent zero.
+package units
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Length is a distance in millimetres
+type Length float64
+
+// Angle is an angle in radians
+type Angle float64
+
+type Kind string
+
+const (
+	KindLength Kind = "length"
+	KindAngle  Kind = "angle"
+)
+
+// article names the kind with its indefinite article, for messages
+func (kind Kind) article() string {
+	if kind == KindAngle {
+		return "an " + string(kind)
+	}
+	return "a " + string(kind)
+}
+
+// a value v in a unit is v*scale/divisor in millimetres or radians. Degrees
+// divide by 180 rather than multiply by π/180 to round like the engine
+// always has.
+type unit struct {
+	name    string
+	kind    Kind
+	scale   float64
+	divisor float64
+}
+
+var unitTable = []unit{
+	{name: "mm", kind: KindLength, scale: 1, divisor: 1},
+	{name: "cm", kind: KindLength, scale: 10, divisor: 1},
+	{name: "m", kind: KindLength, scale: 1000, divisor: 1},
+	{name: "in", kind: KindLength, scale: 25.4, divisor: 1},
+	{name: "ft", kind: KindLength, scale: 25.4 * 12, divisor: 1},
+	{name: "deg", kind: KindAngle, scale: math.Pi, divisor: 180},
+	{name: "rad", kind: KindAngle, scale: 1, divisor: 1},
+}
+
+// Names lists the units of a kind in a stable order, for error messages and
+// documentation
+func Names(kind Kind) []string {
+	names := []string{}
+	for i := 0; i < len(unitTable); i++ {
+		if unitTable[i].kind == kind {
+			names = append(names, unitTable[i].name)
+		}
+	}
+	return names
+}
+
+// LengthUnits and AngleUnits are the names accepted by NewLength and NewAngle
+var LengthUnits = Names(KindLength)
+var AngleUnits = Names(KindAngle)
+
+// UnitError reports a unit that is missing, unknown, or of the wrong kind
+type UnitError struct {
+	Unit string
+	Want Kind
+	// Got is the kind the unit belongs to, empty when it is unknown
+	Got Kind
+}
+
+func (e *UnitError) Error() string {
+	expected := strings.Join(Names(e.Want), ", ")
+	if e.Unit == "" {
+		return fmt.Sprintf("missing %s unit, expected one of %s", e.Want, expected)
+	}
+	if e.Got != "" {
+		return fmt.Sprintf("%q is %s unit, expected %s unit: %s", e.Unit, e.Got.article(), e.Want.article(), expected)
+	}
+	return fmt.Sprintf("unknown %s unit %q, expected one of %s", e.Want, e.Unit, expected)
+}
+
+func lookup(name string, want Kind) (unit, error) {
+	for i := 0; i < len(unitTable); i++ {
+		if unitTable[i].name != name {
+			continue
+		}
+		if unitTable[i].kind != want {
+			return unit{}, &UnitError{Unit: name, Want: want, Got: unitTable[i].kind}
+		}
+		return unitTable[i], nil
+	}
+	return unit{}, &UnitError{Unit: name, Want: want}
+}
+
+// Check returns the error NewLength or NewAngle would give for name
+func Check(name string, want Kind) error {
+	_, err := lookup(name, want)
+	return err
+}
+
+// Converter expresses many millimetre or radian values in one unit without
+// looking the unit up each time
+type Converter struct {
+	u unit
+}
+
+func NewConverter(name string, kind Kind) (Converter, error) {
+	u, err := lookup(name, kind)
+	return Converter{u: u}, err
+}
+
+// Convert takes a value in millimetres or radians to the converter's unit
+func (converter Converter) Convert(val float64) float64 {
+	return val * converter.u.divisor / converter.u.scale
+}
+
+func NewLength(val float64, name string) (Length, error) {
+	u, err := lookup(name, KindLength)
+	if err != nil {
+		return 0, err
+	}
+	return Length(val * u.scale / u.divisor), nil
+}
+
+func NewAngle(val float64, name string) (Angle, error) {
+	u, err := lookup(name, KindAngle)
+	if err != nil {
+		return 0, err
+	}
+	return Angle(val * u.scale / u.divisor), nil
+}
+
+// FromDegrees is NewAngle(val, "deg") for constants
+func FromDegrees(val float64) Angle {
+	return Angle(val * math.Pi / 180)
+}
+
+func (length Length) Millimetres() float64 {
+	return float64(length)
+}
+
+// In expresses length in the named unit
+func (length Length) In(name string) (float64, error) {
+	converter, err := NewConverter(name, KindLength)
+	if err != nil {
+		return 0, err
+	}
+	return converter.Convert(float64(length)), nil
+}
+
+func (angle Angle) Radians() float64 {
+	return float64(angle)
+}
+
+func (angle Angle) Degrees() float64 {
+	return float64(angle) * 180 / math.Pi
+}
+
+// In expresses angle in the named unit
+func (angle Angle) In(name string) (float64, error) {
+	converter, err := NewConverter(name, KindAngle)
+	if err != nil {
+		return 0, err
+	}
+	return converter.Convert(float64(angle)), nil
+}
+
+var ErrSyntax = errors.New("expected a number followed by a unit")
+
+// Quantity is a number and the unit it was written with, before the unit
+// has been checked
+type Quantity struct {
+	Value float64
+	Unit  string
+}
+
+var quantityPattern = regexp.MustCompile(`^\s*([-+]?(?:[0-9]+\.?[0-9]*|\.[0-9]+)(?:[eE][-+]?[0-9]+)?)\s*([A-Za-z]*)\s*$`)
+
+// ParseQuantity splits strings such as "15cm", "2.5 in" or "30deg". The unit
+// may be left out, in which case Unit is empty.
+func ParseQuantity(s string) (Quantity, error) {
+	var quantity Quantity
+	match := quantityPattern.FindStringSubmatch(s)
+	if match == nil {
+		return quantity, fmt.Errorf("%w, got %q", ErrSyntax, s)
+	}
+	val, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return quantity, fmt.Errorf("%w, got %q", ErrSyntax, s)
+	}
+	quantity.Value = val
+	quantity.Unit = match[2]
+	return quantity, nil
+}
+
+func (quantity Quantity) String() string {
+	return strconv.FormatFloat(quantity.Value, 'g', -1, 64) + quantity.Unit
+}
@@ -0,0 +1,83 @@
+package units
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseQuantity(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Quantity
+		err   error
+	}{
+		{input: "15cm", want: Quantity{Value: 15, Unit: "cm"}},
+		{input: "2.5 in", want: Quantity{Value: 2.5, Unit: "in"}},
+		{input: " 30deg ", want: Quantity{Value: 30, Unit: "deg"}},
+		{input: "-1.5e2mm", want: Quantity{Value: -150, Unit: "mm"}},
+		{input: "+.5rad", want: Quantity{Value: 0.5, Unit: "rad"}},
+		{input: "12.", want: Quantity{Value: 12}},
+		{input: "42", want: Quantity{Value: 42}},
+		// the unit is only split off here, checking it is up to the caller
+		{input: "3furlong", want: Quantity{Value: 3, Unit: "furlong"}},
+		{input: "", err: ErrSyntax},
+		{input: "cm", err: ErrSyntax},
+		{input: "1.2.3cm", err: ErrSyntax},
+		{input: "15 c m", err: ErrSyntax},
+		{input: "15cm2", err: ErrSyntax},
+		{input: "1e999", err: ErrSyntax},
+		{input: "NaN", err: ErrSyntax},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.input, func(t *testing.T) {
+			quantity, err := ParseQuantity(test.input)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("got %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if quantity != test.want {
+				t.Errorf("got %+v, want %+v", quantity, test.want)
+			}
+		})
+	}
+}
+
+func TestNewLengthAndAngle(t *testing.T) {
+	length, err := NewLength(2, "in")
+	if err != nil || length.Millimetres() != 50.8 {
+		t.Errorf("2in: got %v, %v", length, err)
+	}
+	angle, err := NewAngle(180, "deg")
+	if err != nil || math.Abs(angle.Radians()-math.Pi) > 1e-15 {
+		t.Errorf("180deg: got %v, %v", angle, err)
+	}
+
+	tests := []struct {
+		unit string
+		kind Kind
+		got  Kind
+	}{
+		{unit: "", kind: KindLength},
+		{unit: "furlong", kind: KindLength},
+		{unit: "deg", kind: KindLength, got: KindAngle},
+		{unit: "mm", kind: KindAngle, got: KindLength},
+	}
+	for i := 0; i < len(tests); i++ {
+		err := Check(tests[i].unit, tests[i].kind)
+		var unitError *UnitError
+		if !errors.As(err, &unitError) {
+			t.Errorf("%q as %s: got %v, want a UnitError", tests[i].unit, tests[i].kind, err)
+			continue
+		}
+		if unitError.Got != tests[i].got {
+			t.Errorf("%q as %s: got kind %q, want %q", tests[i].unit, tests[i].kind, unitError.Got, tests[i].got)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Operational metrics in the Prometheus text format, served on /metrics.
+// Only counters, gauges and histograms are needed, so they are kept here
+// rather than pulling in the client library. Rates such as rays per second
+// come from the counters, rate(amphora_rays_traced_total[1m]), and the cache
+// hit ratio from the lookups split by result.
+
+var (
+	httpRequests = newCounterVec("amphora_http_requests_total",
+		"HTTP requests handled, by route and status code", "method", "route", "code")
+	httpRequestSeconds = newHistogramVec("amphora_http_request_duration_seconds",
+		"time spent handling HTTP requests, by route",
+		[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}, "method", "route")
+
+	simulationsInFlight = newGaugeVec("amphora_simulations_in_flight",
+		"simulations being traced right now")
+	simulationSeconds = newHistogramVec("amphora_simulation_duration_seconds",
+		"time spent tracing a simulation that missed the cache",
+		[]float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120})
+	raysTraced = newCounterVec("amphora_rays_traced_total",
+		"rays emitted by traced simulations")
+	rayBounces = newHistogramVec("amphora_ray_bounces",
+		"reflections per traced ray",
+		[]float64{0, 1, 2, 3, 4, 5, 10, 20, 50, 100, maxRayBounces})
+	outputVertices = newCounterVec("amphora_output_vertices_total",
+		"vertices produced by traced simulations, by mesh", "mesh")
+	simulationVertices = newHistogramVec("amphora_simulation_vertices",
+		"vertices produced by a single traced simulation",
+		[]float64{1e2, 1e3, 1e4, 1e5, 1e6, 1e7})
+	simulationCacheLookups = newCounterVec("amphora_simulation_cache_lookups_total",
+		"simulation cache lookups, by result", "result")
+
+	registeredMetrics = []metric{
+		httpRequests,
+		httpRequestSeconds,
+		simulationsInFlight,
+		simulationSeconds,
+		raysTraced,
+		rayBounces,
+		outputVertices,
+		simulationVertices,
+		simulationCacheLookups,
+	}
+)
+
+type metric interface {
+	write(builder *strings.Builder)
+}
+
+// metricFamily holds what every metric type shares, the samples are keyed by
+// their label values joined with a NUL
+type metricFamily struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (family *metricFamily) key(values []string) string {
+	if len(values) != len(family.labels) {
+		panic(fmt.Sprintf("%s: expected %d label values, got %d", family.name, len(family.labels), len(values)))
+	}
+	return strings.Join(values, "\x00")
+}
+
+func (family *metricFamily) writeHeader(builder *strings.Builder) {
+	fmt.Fprintf(builder, "# HELP %s %s\n", family.name, family.help)
+	fmt.Fprintf(builder, "# TYPE %s %s\n", family.name, family.kind)
+}
+
+// labelPairs renders the label values stored under key, with extra appended,
+// as {a="x",b="y"}
+func (family *metricFamily) labelPairs(key string, extra ...string) string {
+	pairs := []string{}
+	if len(family.labels) > 0 {
+		values := strings.Split(key, "\x00")
+		for i := 0; i < len(family.labels); i++ {
+			pairs = append(pairs, family.labels[i]+"="+quoteLabelValue(values[i]))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+quoteLabelValue(extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// quoteLabelValue quotes val as the text exposition format wants it, which
+// escapes only backslashes, double quotes and newlines. strconv.Quote would
+// also escape other bytes in ways Prometheus does not read back.
+func quoteLabelValue(val string) string {
+	var builder strings.Builder
+	builder.WriteByte('"')
+	for i := 0; i < len(val); i++ {
+		switch val[i] {
+		case '\\':
+			builder.WriteString(`\\`)
+		case '"':
+			builder.WriteString(`\"`)
+		case '\n':
+			builder.WriteString(`\n`)
+		default:
+			builder.WriteByte(val[i])
+		}
+	}
+	builder.WriteByte('"')
+	return builder.String()
+}
+
+func sortedKeys[V any](samples map[string]V) []string {
+	keys := make([]string, 0, len(samples))
+	for key := range samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatSample(val float64) string {
+	if math.IsInf(val, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(val, 'g', -1, 64)
+}
+
+// a counterVec or gaugeVec, the two only differ in the declared type
+type valueVec struct {
+	metricFamily
+	values map[string]float64
+}
+
+func newCounterVec(name string, help string, labels ...string) *valueVec {
+	return &valueVec{metricFamily{name: name, help: help, kind: "counter", labels: labels}, map[string]float64{}}
+}
+
+func newGaugeVec(name string, help string, labels ...string) *valueVec {
+	return &valueVec{metricFamily{name: name, help: help, kind: "gauge", labels: labels}, map[string]float64{}}
+}
+
+func (vec *valueVec) add(val float64, labelValues ...string) {
+	key := vec.key(labelValues)
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+	vec.values[key] += val
+}
+
+func (vec *valueVec) write(builder *strings.Builder) {
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+	vec.writeHeader(builder)
+	if len(vec.labels) == 0 {
+		fmt.Fprintf(builder, "%s %s\n", vec.name, formatSample(vec.values[""]))
+		return
+	}
+	keys := sortedKeys(vec.values)
+	for i := 0; i < len(keys); i++ {
+		fmt.Fprintf(builder, "%s%s %s\n", vec.name, vec.labelPairs(keys[i]), formatSample(vec.values[keys[i]]))
+	}
+}
+
+type histogramVec struct {
+	metricFamily
+	buckets []float64
+	samples map[string]*histogramSample
+}
+
+// counts holds the observations per bucket, not yet cumulative, with the
+// last entry for those above every bucket
+type histogramSample struct {
+	counts []uint64
+	sum    float64
+}
+
+func newHistogramVec(name string, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{metricFamily{name: name, help: help, kind: "histogram", labels: labels}, buckets, map[string]*histogramSample{}}
+}
+
+func (vec *histogramVec) observe(val float64, labelValues ...string) {
+	vec.observeCount(val, 1, labelValues...)
+}
+
+// observeCount records count observations of the same value at once
+func (vec *histogramVec) observeCount(val float64, count uint64, labelValues ...string) {
+	key := vec.key(labelValues)
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+	sample, ok := vec.samples[key]
+	if !ok {
+		sample = &histogramSample{counts: make([]uint64, len(vec.buckets)+1)}
+		vec.samples[key] = sample
+	}
+	sample.counts[sort.SearchFloat64s(vec.buckets, val)] += count
+	sample.sum += val * float64(count)
+}
+
+func (vec *histogramVec) write(builder *strings.Builder) {
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+	vec.writeHeader(builder)
+	keys := sortedKeys(vec.samples)
+	if len(vec.labels) == 0 && len(keys) == 0 {
+		// an unlabelled histogram is reported as empty before its first
+		// observation
+		keys = []string{""}
+	}
+	for i := 0; i < len(keys); i++ {
+		sample, ok := vec.samples[keys[i]]
+		if !ok {
+			sample = &histogramSample{counts: make([]uint64, len(vec.buckets)+1)}
+		}
+		var cumulative uint64
+		for j := 0; j < len(sample.counts); j++ {
+			cumulative += sample.counts[j]
+			bound := math.Inf(1)
+			if j < len(vec.buckets) {
+				bound = vec.buckets[j]
+			}
+			fmt.Fprintf(builder, "%s_bucket%s %d\n", vec.name, vec.labelPairs(keys[i], "le", formatSample(bound)), cumulative)
+		}
+		fmt.Fprintf(builder, "%s_sum%s %s\n", vec.name, vec.labelPairs(keys[i]), formatSample(sample.sum))
+		fmt.Fprintf(builder, "%s_count%s %d\n", vec.name, vec.labelPairs(keys[i]), cumulative)
+	}
+}
+
+// recordRequestMetrics counts every request under the route pattern it
+// matched, so /api/v1/phones/:id is one series however many phones there are
+func recordRequestMetrics(c *gin.Context) {
+	startTime := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	method := c.Request.Method
+	httpRequests.add(1, method, route, strconv.Itoa(c.Writer.Status()))
+	httpRequestSeconds.observe(time.Since(startTime).Seconds(), method, route)
+}
+
+// observeSimulation records the workload of a simulation that was traced
+// rather than served from the cache
+func observeSimulation(simulationOutput *SimulationOutput, duration time.Duration) {
+	simulationSeconds.observe(duration.Seconds())
+	raysTraced.add(float64(simulationOutput.Metrics.RaysEmitted))
+
+	histogram := simulationOutput.Metrics.BounceHistogram
+	for bounces := 0; bounces < len(histogram); bounces++ {
+		if histogram[bounces] > 0 {
+			rayBounces.observeCount(float64(bounces), uint64(histogram[bounces]))
+		}
+	}
+
+	meshes := []string{"phone", "paraboloid", "user"}
+	verticies := [][]float64{simulationOutput.Phone, simulationOutput.Paraboloid, simulationOutput.User}
+	total := 0
+	for i := 0; i < len(meshes); i++ {
+		outputVertices.add(float64(len(verticies[i])/3), meshes[i])
+		total += len(verticies[i]) / 3
+	}
+	simulationVertices.observe(float64(total))
+}
+
+func observeCacheLookup(cached bool) {
+	if cached {
+		simulationCacheLookups.add(1, "hit")
+	} else {
+		simulationCacheLookups.add(1, "miss")
+	}
+}
+
+func HandleMetrics(c *gin.Context) {
+	var builder strings.Builder
+	for i := 0; i < len(registeredMetrics); i++ {
+		registeredMetrics[i].write(&builder)
+	}
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(builder.String()))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestValueVecWrite(t *testing.T) {
+	counter := newCounterVec("test_total", "things counted", "kind", "code")
+	counter.add(1, "b", "200")
+	counter.add(2.5, "a", "404")
+	counter.add(1, "b", "200")
+	gauge := newGaugeVec("test_running", "things running")
+
+	var builder strings.Builder
+	counter.write(&builder)
+	gauge.write(&builder)
+	want := "# HELP test_total things counted\n" +
+		"# TYPE test_total counter\n" +
+		"test_total{kind=\"a\",code=\"404\"} 2.5\n" +
+		"test_total{kind=\"b\",code=\"200\"} 2\n" +
+		"# HELP test_running things running\n" +
+		"# TYPE test_running gauge\n" +
+		"test_running 0\n"
+	if builder.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", builder.String(), want)
+	}
+}
+
+func TestQuoteLabelValue(t *testing.T) {
+	values := map[string]string{
+		"plain":          `"plain"`,
+		`back\slash`:     `"back\\slash"`,
+		`say "hi"`:       `"say \"hi\""`,
+		"two\nlines":     `"two\nlines"`,
+		"tab\tand é":     "\"tab\tand é\"",
+		"/api/v1/phones": `"/api/v1/phones"`,
+	}
+	for val, want := range values {
+		got := quoteLabelValue(val)
+		if got != want {
+			t.Errorf("quoteLabelValue(%q) = %s, want %s", val, got, want)
+		}
+	}
+}
+
+func TestSimulationsInFlight(t *testing.T) {
+	resetShutdown(t)
+	close(simulationsCancelled)
+	simulationInput := testSimulationInput()
+
+	// a simulation that fails still leaves the gauge where it was
+	before := simulationsInFlight.values[""]
+	_, err := generateTrackedSimulation(testPhoneConfig(), &simulationInput, simulationOptions{withParaboloid: true})
+	if err != errSimulationCancelled {
+		t.Fatalf("got %v, want %v", err, errSimulationCancelled)
+	}
+	if simulationsInFlight.values[""] != before {
+		t.Errorf("got %g simulations in flight, want %g", simulationsInFlight.values[""], before)
+	}
+}
+
+func TestHistogramVecWrite(t *testing.T) {
+	histogram := newHistogramVec("test_seconds", "time taken", []float64{1, 5})
+	var builder strings.Builder
+	histogram.write(&builder)
+	empty := "# HELP test_seconds time taken\n" +
+		"# TYPE test_seconds histogram\n" +
+		"test_seconds_bucket{le=\"1\"} 0\n" +
+		"test_seconds_bucket{le=\"5\"} 0\n" +
+		"test_seconds_bucket{le=\"+Inf\"} 0\n" +
+		"test_seconds_sum 0\n" +
+		"test_seconds_count 0\n"
+	if builder.String() != empty {
+		t.Errorf("got\n%s\nbefore any observation, want\n%s", builder.String(), empty)
+	}
+
+	// bucket bounds are inclusive
+	histogram.observe(1)
+	histogram.observe(3)
+	histogram.observeCount(10, 2)
+	builder.Reset()
+	histogram.write(&builder)
+	want := "# HELP test_seconds time taken\n" +
+		"# TYPE test_seconds histogram\n" +
+		"test_seconds_bucket{le=\"1\"} 1\n" +
+		"test_seconds_bucket{le=\"5\"} 2\n" +
+		"test_seconds_bucket{le=\"+Inf\"} 4\n" +
+		"test_seconds_sum 24\n" +
+		"test_seconds_count 4\n"
+	if builder.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", builder.String(), want)
+	}
+}
+
+func TestHandleMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	httpRequests.values = map[string]float64{}
+	r.Use(recordRequestMetrics)
+	r.GET("/metrics", HandleMetrics)
+	r.GET("/phones/:id", func(c *gin.Context) { c.Status(http.StatusNotFound) })
+
+	serveTestRequest(r, http.MethodGet, "/phones/pixel", "")
+	serveTestRequest(r, http.MethodGet, "/phones/nokia", "")
+	serveTestRequest(r, http.MethodGet, "/missing", "")
+	recorder := serveTestRequest(r, http.MethodGet, "/metrics", "")
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("got status %d and content type %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+
+	// requests are counted by route pattern, not by path
+	body := recorder.Body.String()
+	wants := []string{
+		`amphora_http_requests_total{method="GET",route="/phones/:id",code="404"} 2`,
+		`amphora_http_requests_total{method="GET",route="unmatched",code="404"} 1`,
+		"# TYPE amphora_simulation_duration_seconds histogram",
+		"amphora_simulations_in_flight ",
+	}
+	for i := 0; i < len(wants); i++ {
+		if !strings.Contains(body, wants[i]+"\n") && !strings.Contains(body, "\n"+wants[i]) {
+			t.Errorf("missing %q in\n%s", wants[i], body)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"amphora/pkg/units"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Fields tagged quantity:"<kind>,<UnitsField>" accept either a bare number,
+// whose unit is then read from UnitsField, or a string such as "15cm" or
+// "30deg" that carries its own unit. decodeQuantities splits such strings
+// before the usual decoding and records their unit in UnitsField.
+//
+// Input structs opt in with an UnmarshalJSON that decodes through an alias of
+// themselves, which keeps the field tags but drops the method:
+//
+//	func (input *T) UnmarshalJSON(data []byte) error {
+//		type plain T
+//		return decodeQuantities(data, (*plain)(input))
+//	}
+
+type quantityTag struct {
+	kind      units.Kind
+	unitField string
+}
+
+func parseQuantityTag(field reflect.StructField) (quantityTag, bool) {
+	tag, ok := field.Tag.Lookup("quantity")
+	if !ok {
+		return quantityTag{}, false
+	}
+	kind, unitField, _ := strings.Cut(tag, ",")
+	return quantityTag{kind: units.Kind(kind), unitField: unitField}, true
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func decodeQuantities(data []byte, target any) error {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(data, &fields)
+	if err != nil || fields == nil {
+		// not an object, let the plain decoder report it
+		return json.Unmarshal(data, target)
+	}
+
+	value := reflect.ValueOf(target).Elem()
+	written := map[string]string{}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		tag, ok := parseQuantityTag(field)
+		if !ok {
+			continue
+		}
+		name := jsonFieldName(field)
+		raw, ok := fields[name]
+		if !ok || len(raw) == 0 || raw[0] != '"' {
+			continue
+		}
+
+		var text string
+		err = json.Unmarshal(raw, &text)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		quantity, err := units.ParseQuantity(text)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if quantity.Unit != "" {
+			err = units.Check(quantity.Unit, tag.kind)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		fields[name], err = json.Marshal(quantity.Value)
+		if err != nil {
+			return err
+		}
+		written[field.Name] = quantity.Unit
+	}
+
+	data, err = json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, target)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		unit, ok := written[field.Name]
+		if !ok || unit == "" {
+			continue
+		}
+		tag, _ := parseQuantityTag(field)
+		unitValue := value.FieldByName(tag.unitField)
+		if unitValue.String() != "" && unitValue.String() != unit {
+			unitName := jsonFieldName(reflectField(value.Type(), tag.unitField))
+			return fmt.Errorf("%s: written in %s but %s is %q", jsonFieldName(field), unit, unitName, unitValue.String())
+		}
+		unitValue.SetString(unit)
+	}
+	return nil
+}
+
+func reflectField(t reflect.Type, name string) reflect.StructField {
+	field, _ := t.FieldByName(name)
+	return field
+}
+
+// quantitySchema describes a quantity field in the OpenAPI document
+func quantitySchema(field reflect.StructField) map[string]any {
+	tag, _ := parseQuantityTag(field)
+	return map[string]any{
+		"oneOf": []any{
+			map[string]any{"type": "number"},
+			map[string]any{
+				"type":        "string",
+				"description": fmt.Sprintf("a number followed by a unit of %s: %s", tag.kind, strings.Join(units.Names(tag.kind), ", ")),
+			},
+		},
+	}
+}
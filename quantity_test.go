@@ -0,0 +1,58 @@
+package main
+
+import (
+	"amphora/pkg/units"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDecodeQuantities(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  SlicingPlaneInput
+		// a substring of the error, empty when decoding succeeds
+		err string
+	}{
+		{name: "numbers with units fields", input: `{"height": 15, "heightUnits": "mm", "angle": 0.5, "angleUnits": "rad"}`, want: SlicingPlaneInput{Height: 15, HeightUnits: "mm", Angle: 0.5, AngleUnits: "rad"}},
+		{name: "strings with units", input: `{"height": "15cm", "angle": "30 deg"}`, want: SlicingPlaneInput{Height: 15, HeightUnits: "cm", Angle: 30, AngleUnits: "deg"}},
+		{name: "matching units field", input: `{"height": "15cm", "heightUnits": "cm"}`, want: SlicingPlaneInput{Height: 15, HeightUnits: "cm"}},
+		{name: "bare string", input: `{"height": "15", "heightUnits": "in"}`, want: SlicingPlaneInput{Height: 15, HeightUnits: "in"}},
+		{name: "bare string without units", input: `{"height": "15"}`, want: SlicingPlaneInput{Height: 15}},
+		{name: "unit mismatch", input: `{"height": "15cm", "heightUnits": "mm"}`, err: `height: written in cm but heightUnits is "mm"`},
+		{name: "units field first", input: `{"angleUnits": "rad", "angle": "30deg"}`, err: `angle: written in deg but angleUnits is "rad"`},
+		{name: "angle unit on a length", input: `{"height": "30deg"}`, err: `height: "deg" is an angle unit`},
+		{name: "unknown unit", input: `{"height": "3furlong"}`, err: `height: unknown length unit "furlong"`},
+		{name: "not a number", input: `{"height": "tall"}`, err: "height: " + units.ErrSyntax.Error()},
+		{name: "wrong type", input: `{"height": true}`, err: "cannot unmarshal bool"},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			var slicingPlaneInput SlicingPlaneInput
+			err := json.Unmarshal([]byte(test.input), &slicingPlaneInput)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got %v, want an error containing %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if slicingPlaneInput != test.want {
+				t.Errorf("got %+v, want %+v", slicingPlaneInput, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeQuantitiesSyntaxError(t *testing.T) {
+	var userRadiusInput UserRadiusInput
+	err := json.Unmarshal([]byte(`{"radius": "1.2.3m"}`), &userRadiusInput)
+	if !errors.Is(err, units.ErrSyntax) {
+		t.Errorf("got %v, want %v", err, units.ErrSyntax)
+	}
+}
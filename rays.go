@@ -0,0 +1,47 @@
+package main
+
+import "context"
+
+// RayRecord ties every hit in a SimulationOutput back to the ray that made
+// it. Rays are numbered in emission order and Origins holds the speaker
+// point each ray starts from. Bounce indices count the hits along a ray,
+// starting at 1 for the first surface it meets.
+type RayRecord struct {
+	Origins           []float64 `json:"origins"`
+	PhoneRays         []int     `json:"phoneRays"`
+	PhoneBounces      []int     `json:"phoneBounces"`
+	ParaboloidRays    []int     `json:"paraboloidRays"`
+	ParaboloidBounces []int     `json:"paraboloidBounces"`
+	UserRays          []int     `json:"userRays"`
+	UserBounces       []int     `json:"userBounces"`
+}
+
+type simulationOptions struct {
+	// false removes the capsule, leaving only the phone and the listener
+	// sphere while keeping the phone where the capsule puts it
+	withParaboloid bool
+	recordRays     bool
+	// when ctx is done the trace stops at the next row of the speaker grid
+	ctx context.Context
+}
+
+// done is closed once the caller no longer wants the simulation. Without a
+// ctx it is nil and never ready.
+func (options simulationOptions) done() <-chan struct{} {
+	if options.ctx == nil {
+		return nil
+	}
+	return options.ctx.Done()
+}
+
+func newRayRecord() *RayRecord {
+	return &RayRecord{
+		Origins:           []float64{},
+		PhoneRays:         []int{},
+		PhoneBounces:      []int{},
+		ParaboloidRays:    []int{},
+		ParaboloidBounces: []int{},
+		UserRays:          []int{},
+		UserBounces:       []int{},
+	}
+}
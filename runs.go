@@ -0,0 +1,424 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var runIdPattern = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}Z-[0-9a-f]{8}$`)
+
+var ErrRunNotFound = errors.New("run not found")
+var ErrRunStoreClosed = errors.New("run history is closed")
+
+type RunSummary struct {
+	PhoneHits      int               `json:"phoneHits"`
+	ParaboloidHits int               `json:"paraboloidHits"`
+	UserHits       int               `json:"userHits"`
+	Metrics        SimulationMetrics `json:"metrics"`
+}
+
+type RunRecord struct {
+	Id         string          `json:"id"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	DurationMs float64         `json:"durationMs"`
+	Cached     bool            `json:"cached"`
+	HasOutput  bool            `json:"hasOutput"`
+	Input      SimulationInput `json:"input"`
+	Phone      PhoneConfig     `json:"phoneConfig"`
+	Summary    RunSummary      `json:"summary"`
+}
+
+type RunDetail struct {
+	Run    *RunRecord        `json:"run"`
+	Output *SimulationOutput `json:"output"`
+}
+
+type RunFilter struct {
+	Phone       string
+	Since       time.Time
+	Until       time.Time
+	MinUserHits int
+	Limit       int
+}
+
+// RunRetention bounds the run history, zero values keep runs forever
+type RunRetention struct {
+	MaxRuns int
+	MaxAge  time.Duration
+}
+
+// RunStore keeps one JSON document per run in dir. Full outputs are kept in
+// a sibling file so that listing history never has to decode vertex arrays.
+// The records are read once when the store opens and listed from memory.
+type RunStore struct {
+	mu          sync.Mutex
+	dir         string
+	storeOutput bool
+	retention   RunRetention
+	closed      bool
+	// newest first
+	records []RunRecord
+}
+
+// NewRunStore opens the history in dir. Record files that cannot be read are
+// logged and left out rather than failing the whole history.
+func NewRunStore(dir string, storeOutput bool, retention RunRetention) (*RunStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &RunStore{dir: dir, storeOutput: storeOutput, retention: retention, records: []RunRecord{}}
+	for i := 0; i < len(entries); i++ {
+		id, ok := strings.CutSuffix(entries[i].Name(), ".json")
+		if !ok || !runIdPattern.MatchString(id) {
+			continue
+		}
+
+		var record RunRecord
+		err = readJsonFile(store.recordPath(id), &record)
+		if err == nil && record.Id != id {
+			err = fmt.Errorf("record is for run %q", record.Id)
+		}
+		if err != nil {
+			log.Printf("run history: skipping %s: %v", entries[i].Name(), err)
+			continue
+		}
+		store.records = append(store.records, record)
+	}
+
+	sort.Slice(store.records, func(i, j int) bool {
+		return store.records[i].CreatedAt.After(store.records[j].CreatedAt)
+	})
+	store.prune(time.Now())
+	return store, nil
+}
+
+func newRunId(createdAt time.Time) (string, error) {
+	suffix := make([]byte, 4)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return "", err
+	}
+
+	return createdAt.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix), nil
+}
+
+func summarizeOutput(output *SimulationOutput) RunSummary {
+	var summary RunSummary
+	summary.PhoneHits = len(output.Phone) / 3
+	summary.ParaboloidHits = len(output.Paraboloid) / 3
+	summary.UserHits = len(output.User) / 3
+	summary.Metrics = output.Metrics
+
+	return summary
+}
+
+func (store *RunStore) Save(record *RunRecord, output *SimulationOutput) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.closed {
+		return ErrRunStoreClosed
+	}
+
+	id, err := newRunId(record.CreatedAt)
+	if err != nil {
+		return err
+	}
+	record.Id = id
+	record.HasOutput = store.storeOutput && output != nil
+
+	if record.HasOutput {
+		err = writeJsonFile(store.outputPath(id), output)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeJsonFile(store.recordPath(id), record)
+	if err != nil {
+		return err
+	}
+
+	i := sort.Search(len(store.records), func(i int) bool {
+		return !store.records[i].CreatedAt.After(record.CreatedAt)
+	})
+	store.records = append(store.records, RunRecord{})
+	copy(store.records[i+1:], store.records[i:])
+	store.records[i] = *record
+	store.prune(time.Now())
+	return nil
+}
+
+// prune deletes the runs beyond the retention limits, oldest first. The
+// caller holds mu.
+func (store *RunStore) prune(now time.Time) {
+	keep := len(store.records)
+	if store.retention.MaxRuns > 0 && keep > store.retention.MaxRuns {
+		keep = store.retention.MaxRuns
+	}
+	for store.retention.MaxAge > 0 && keep > 0 && now.Sub(store.records[keep-1].CreatedAt) > store.retention.MaxAge {
+		keep--
+	}
+
+	for i := keep; i < len(store.records); i++ {
+		paths := []string{store.outputPath(store.records[i].Id), store.recordPath(store.records[i].Id)}
+		for j := 0; j < len(paths); j++ {
+			err := os.Remove(paths[j])
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("run history: %v", err)
+			}
+		}
+	}
+	store.records = store.records[:keep]
+}
+
+// Close waits for a save in progress to reach the disk and refuses any later
+// ones
+func (store *RunStore) Close() {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.closed = true
+}
+
+func (store *RunStore) Get(id string) (*RunRecord, error) {
+	if !runIdPattern.MatchString(id) {
+		return nil, ErrRunNotFound
+	}
+
+	var record RunRecord
+	err := readJsonFile(store.recordPath(id), &record)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrRunNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+func (store *RunStore) GetOutput(id string) (*SimulationOutput, error) {
+	if !runIdPattern.MatchString(id) {
+		return nil, ErrRunNotFound
+	}
+
+	var output SimulationOutput
+	err := readJsonFile(store.outputPath(id), &output)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrRunNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &output, nil
+}
+
+// List returns the runs matching filter, newest first.
+func (store *RunStore) List(filter RunFilter) ([]RunRecord, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.prune(time.Now())
+
+	records := make([]RunRecord, 0)
+	for i := 0; i < len(store.records); i++ {
+		record := store.records[i]
+		if filter.Phone != "" && record.Input.Phone.Filename != filter.Phone {
+			continue
+		}
+		if !filter.Since.IsZero() && record.CreatedAt.Before(filter.Since) {
+			continue
+		}
+		if !filter.Until.IsZero() && record.CreatedAt.After(filter.Until) {
+			continue
+		}
+		if record.Summary.UserHits < filter.MinUserHits {
+			continue
+		}
+		records = append(records, record)
+		if filter.Limit > 0 && len(records) == filter.Limit {
+			break
+		}
+	}
+	return records, nil
+}
+
+func (store *RunStore) recordPath(id string) string {
+	return filepath.Join(store.dir, id+".json")
+}
+
+func (store *RunStore) outputPath(id string) string {
+	return filepath.Join(store.dir, id+".output.json")
+}
+
+func readJsonFile(path string, v any) error {
+	byteValue, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(byteValue, v)
+}
+
+func writeJsonFile(path string, v any) error {
+	byteValue, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, byteValue)
+}
+
+// writeFileAtomic writes through a temporary file and rename so readers never
+// observe a partially written document
+func writeFileAtomic(path string, byteValue []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(byteValue)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+func parseRunFilter(c *gin.Context) (RunFilter, error) {
+	var filter RunFilter
+	var err error
+
+	filter.Phone = c.Query("phone")
+
+	since := c.Query("since")
+	if since != "" {
+		filter.Since, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			return filter, err
+		}
+	}
+
+	until := c.Query("until")
+	if until != "" {
+		filter.Until, err = time.Parse(time.RFC3339, until)
+		if err != nil {
+			return filter, err
+		}
+	}
+
+	minUserHits := c.Query("minUserHits")
+	if minUserHits != "" {
+		filter.MinUserHits, err = strconv.Atoi(minUserHits)
+		if err != nil {
+			return filter, err
+		}
+	}
+
+	limit := c.Query("limit")
+	if limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			return filter, err
+		}
+	}
+
+	return filter, nil
+}
+
+func HandleApiGetRuns(c *gin.Context) {
+	filter, err := parseRunFilter(c)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	records, err := runStore.List(filter)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, records)
+}
+
+// HandleApiGetRun returns a stored run together with its output. Runs saved
+// without their output are recomputed from the recorded inputs.
+func HandleApiGetRun(c *gin.Context) {
+	record, err := runStore.Get(c.Param("id"))
+	if errors.Is(err, ErrRunNotFound) {
+		c.AbortWithError(http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	var output *SimulationOutput
+	if record.HasOutput {
+		output, err = runStore.GetOutput(record.Id)
+	} else {
+		output, _, err = runSimulation(&record.Phone, &record.Input)
+	}
+	// the output may have been pruned since the record was read
+	if errors.Is(err, ErrRunNotFound) {
+		c.AbortWithError(http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		abortWithSimulationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, RunDetail{Run: record, Output: output})
+}
+
+func HandleHtmxGetRuns(c *gin.Context) {
+	records, err := runStore.List(RunFilter{Limit: 50})
+	if err != nil {
+		c.String(http.StatusInternalServerError, "")
+		return
+	}
+
+	htmlOptions := ""
+	for i := 0; i < len(records); i++ {
+		label := fmt.Sprintf("%s %s (%d user hits)", records[i].CreatedAt.Local().Format("2006-01-02 15:04:05"), strings.Replace(records[i].Input.Phone.Filename, ".xml", "", -1), records[i].Summary.UserHits)
+		htmlOptions += fmt.Sprintf("<option value='%s'>%s</option>", records[i].Id, html.EscapeString(label))
+	}
+
+	c.String(http.StatusOK, htmlOptions)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// saveTestRun stores a run of the default input with userHits hits, created
+// minutes after a fixed time
+func saveTestRun(t *testing.T, store *RunStore, filename string, userHits int, minutes int) *RunRecord {
+	t.Helper()
+	record := &RunRecord{CreatedAt: time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC).Add(time.Duration(minutes) * time.Minute)}
+	record.Input = testSimulationInput()
+	record.Input.Phone.Filename = filename
+	output := &SimulationOutput{User: make([]float64, 3*userHits)}
+	record.Summary = summarizeOutput(output)
+	err := store.Save(record, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func TestRunStoreSaveAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewRunStore(dir, true, RunRetention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := saveTestRun(t, store, "iPhone5.xml", 4, 0)
+	if !runIdPattern.MatchString(saved.Id) || !saved.HasOutput {
+		t.Fatalf("saved %+v", saved)
+	}
+
+	// a new store over the same directory, as after a restart
+	store, err = NewRunStore(dir, true, RunRetention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := store.Get(saved.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !record.CreatedAt.Equal(saved.CreatedAt) || record.Summary.UserHits != 4 || record.Input.Phone.Filename != "iPhone5.xml" {
+		t.Errorf("got %+v, want %+v", record, saved)
+	}
+	output, err := store.GetOutput(saved.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(output.User) != 12 {
+		t.Errorf("output has %d user coordinates, want 12", len(output.User))
+	}
+
+	ids := []string{"", "../" + saved.Id, "20260102T030000Z-zzzzzzzz", "20260102T030000Z-00000000"}
+	for i := 0; i < len(ids); i++ {
+		_, err = store.Get(ids[i])
+		if !errors.Is(err, ErrRunNotFound) {
+			t.Errorf("Get %q: got %v, want %v", ids[i], err, ErrRunNotFound)
+		}
+	}
+}
+
+func TestRunStoreWithoutOutput(t *testing.T) {
+	store, err := NewRunStore(t.TempDir(), false, RunRetention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := saveTestRun(t, store, "iPhone5.xml", 1, 0)
+	if saved.HasOutput {
+		t.Error("a store that keeps no outputs recorded one")
+	}
+	_, err = store.GetOutput(saved.Id)
+	if !errors.Is(err, ErrRunNotFound) {
+		t.Errorf("GetOutput: got %v, want %v", err, ErrRunNotFound)
+	}
+}
+
+func TestRunStoreList(t *testing.T) {
+	store, err := NewRunStore(t.TempDir(), false, RunRetention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := []*RunRecord{
+		saveTestRun(t, store, "iPhone5.xml", 1, 0),
+		saveTestRun(t, store, "iPhone4S.xml", 5, 10),
+		saveTestRun(t, store, "iPhone5.xml", 10, 20),
+		saveTestRun(t, store, "iPhone5.xml", 3, 30),
+	}
+	start := runs[0].CreatedAt
+
+	tests := []struct {
+		name   string
+		filter RunFilter
+		// indices into runs, newest first
+		want []int
+	}{
+		{name: "all", want: []int{3, 2, 1, 0}},
+		{name: "phone", filter: RunFilter{Phone: "iPhone5.xml"}, want: []int{3, 2, 0}},
+		{name: "since", filter: RunFilter{Since: start.Add(10 * time.Minute)}, want: []int{3, 2, 1}},
+		{name: "until", filter: RunFilter{Until: start.Add(10 * time.Minute)}, want: []int{1, 0}},
+		{name: "min user hits", filter: RunFilter{MinUserHits: 4}, want: []int{2, 1}},
+		{name: "limit", filter: RunFilter{Limit: 2}, want: []int{3, 2}},
+		{name: "combined", filter: RunFilter{Phone: "iPhone5.xml", MinUserHits: 2, Limit: 1}, want: []int{3}},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			records, err := store.List(test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != len(test.want) {
+				t.Fatalf("got %d runs, want %d", len(records), len(test.want))
+			}
+			for j := 0; j < len(records); j++ {
+				if records[j].Id != runs[test.want[j]].Id {
+					t.Errorf("run %d is %s, want %s", j, records[j].Id, runs[test.want[j]].Id)
+				}
+			}
+		})
+	}
+}
+
+func TestRunStoreRetention(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewRunStore(dir, true, RunRetention{MaxRuns: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := []*RunRecord{
+		saveTestRun(t, store, "iPhone5.xml", 1, 0),
+		saveTestRun(t, store, "iPhone5.xml", 2, 10),
+		saveTestRun(t, store, "iPhone5.xml", 3, 20),
+	}
+	records, err := store.List(RunFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Id != runs[2].Id || records[1].Id != runs[1].Id {
+		t.Errorf("got %d runs, want the newest two", len(records))
+	}
+	// the oldest run is gone from the disk along with its output
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Errorf("got %d files, want a record and an output for each of 2 runs", len(entries))
+	}
+	_, err = store.Get(runs[0].Id)
+	if !errors.Is(err, ErrRunNotFound) {
+		t.Errorf("pruned run: got %v, want %v", err, ErrRunNotFound)
+	}
+
+	// an unreadable record is skipped, and reopening with a maximum age
+	// prunes the runs that have grown too old
+	err = os.WriteFile(filepath.Join(dir, "20260102T030000Z-00000000.json"), []byte("{"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxAge := time.Since(runs[2].CreatedAt) + 5*time.Minute
+	store, err = NewRunStore(dir, true, RunRetention{MaxAge: maxAge})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err = store.List(RunFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Id != runs[2].Id {
+		t.Errorf("got %d runs after reopening, want only the newest", len(records))
+	}
+}
+
+func TestHandleApiGetRun(t *testing.T) {
+	dir := t.TempDir()
+	var err error
+	runStore, err = NewRunStore(dir, true, RunRetention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { runStore = nil }()
+	r := newTestRouter()
+	saved := saveTestRun(t, runStore, "iPhone5.xml", 2, 0)
+
+	recorder := serveTestRequest(r, http.MethodGet, apiVersionPath+"/runs/"+saved.Id, "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	recorder = serveTestRequest(r, http.MethodGet, apiVersionPath+"/runs/20260102T030000Z-00000000", "")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("unknown run: got status %d, want 404", recorder.Code)
+	}
+
+	// a record whose output has gone is not found rather than a server error
+	err = os.Remove(runStore.outputPath(saved.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder = serveTestRequest(r, http.MethodGet, apiVersionPath+"/runs/"+saved.Id, "")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("run without its output: got status %d, want 404", recorder.Code)
+	}
+}
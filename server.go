@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serverStopping is closed when shutdown begins. Event streams end and the
+// phone catalog stops being watched, so only real work is left to drain.
+var serverStopping = make(chan struct{})
+
+// simulationsCancelled is closed once the drain timeout has passed. Running
+// simulations then stop tracing and fail with errSimulationCancelled.
+var simulationsCancelled = make(chan struct{})
+
+var errSimulationCancelled = errors.New("simulation cancelled, the server is shutting down")
+
+// runningRequests counts the handlers still running. server.Close cuts their
+// connections without waiting for them, and they may yet save a run.
+var runningRequests sync.WaitGroup
+
+func trackRequests(c *gin.Context) {
+	runningRequests.Add(1)
+	defer runningRequests.Done()
+	c.Next()
+}
+
+// how long cancelled requests get to send their error before connections are
+// cut
+const simulationCancelGrace = 5 * time.Second
+
+// abortWithSimulationError reports a failed simulation, telling clients whose
+// simulation was cancelled by a shutdown to try again
+func abortWithSimulationError(c *gin.Context, err error) {
+	if errors.Is(err, errSimulationCancelled) {
+		c.Header("Retry-After", "5")
+		c.AbortWithError(http.StatusServiceUnavailable, err)
+		return
+	}
+	c.AbortWithError(http.StatusInternalServerError, err)
+}
+
+// serve runs handler until SIGINT or SIGTERM, then stops accepting
+// connections and waits up to -drain-timeout for running requests. Simulations
+// still running after that are cancelled. A second signal cancels them
+// straight away.
+func serve(handler http.Handler, flags *serverFlags) error {
+	drainTimeout := *flags.drainTimeout
+	server := &http.Server{Addr: *flags.listen, Handler: handler}
+	var stopping, cancelling sync.Once
+	server.RegisterOnShutdown(func() {
+		stopping.Do(func() { close(serverStopping) })
+	})
+	cancelSimulations := func() {
+		cancelling.Do(func() { close(simulationsCancelled) })
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		if *flags.tlsCert != "" {
+			served <- server.ListenAndServeTLS(*flags.tlsCert, *flags.tlsKey)
+		} else {
+			served <- server.ListenAndServe()
+		}
+	}()
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-served:
+		return err
+	case received := <-signals:
+		log.Printf("%v: draining requests for up to %v", received, drainTimeout)
+	}
+
+	deadline := time.AfterFunc(drainTimeout, func() {
+		log.Printf("drain timeout passed, cancelling running simulations")
+		cancelSimulations()
+	})
+	defer deadline.Stop()
+	go func() {
+		received := <-signals
+		log.Printf("%v: cancelling running simulations", received)
+		cancelSimulations()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout+simulationCancelGrace)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("shutdown: %v", err)
+		cancelSimulations()
+		server.Close()
+	}
+	<-served
+
+	// handlers cut off above return soon now that their simulations are
+	// cancelled, but may still be saving a run
+	runningRequests.Wait()
+	runStore.Close()
+	log.Printf("stopped")
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// resetShutdown gives a test its own shutdown channels, since serve closes
+// them for good
+func resetShutdown(t *testing.T) {
+	stopping, cancelled := serverStopping, simulationsCancelled
+	serverStopping = make(chan struct{})
+	simulationsCancelled = make(chan struct{})
+	t.Cleanup(func() {
+		serverStopping, simulationsCancelled = stopping, cancelled
+	})
+}
+
+func TestGenerateSimulationCancelled(t *testing.T) {
+	resetShutdown(t)
+	close(simulationsCancelled)
+	simulationInput := testSimulationInput()
+	_, err := generateSimulation(testPhoneConfig(), normalizedInput(&simulationInput), simulationOptions{withParaboloid: true})
+	if err != errSimulationCancelled {
+		t.Errorf("got %v, want %v", err, errSimulationCancelled)
+	}
+}
+
+func TestServeDrains(t *testing.T) {
+	resetShutdown(t)
+	var err error
+	runStore, err = NewRunStore(t.TempDir(), false, RunRetention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { runStore = nil }()
+
+	// a request that finishes within the drain timeout and one that only
+	// ends when its simulation is cancelled, then saves a run
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(trackRequests)
+	started := make(chan struct{}, 2)
+	r.GET("/quick", func(c *gin.Context) {
+		started <- struct{}{}
+		time.Sleep(100 * time.Millisecond)
+		c.Status(http.StatusOK)
+	})
+	r.GET("/simulate", func(c *gin.Context) {
+		started <- struct{}{}
+		<-simulationsCancelled
+		abortWithSimulationError(c, errSimulationCancelled)
+		time.Sleep(50 * time.Millisecond)
+		err := runStore.Save(&RunRecord{CreatedAt: time.Now()}, nil)
+		if err != nil {
+			t.Errorf("saving the cut off run: %v", err)
+		}
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	flags := addServerFlags(flag.NewFlagSet("serve", flag.ContinueOnError))
+	*flags.listen = address
+	*flags.drainTimeout = 300 * time.Millisecond
+
+	// keep the interrupt below from stopping the test binary
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	defer signal.Stop(signals)
+
+	served := make(chan error, 1)
+	go func() { served <- serve(r, flags) }()
+
+	responses := make(chan *http.Response, 2)
+	for _, path := range []string{"/quick", "/simulate"} {
+		go func(path string) {
+			for i := 0; i < 100; i++ {
+				response, err := http.Get("http://" + address + path)
+				if err == nil {
+					responses <- response
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			responses <- nil
+		}(path)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(2 * time.Second):
+			t.Fatal("the server never answered")
+		}
+	}
+
+	err = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-served:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return after the drain timeout")
+	}
+
+	statuses := map[int]int{}
+	for i := 0; i < 2; i++ {
+		response := <-responses
+		if response == nil {
+			t.Fatal("a request failed")
+		}
+		statuses[response.StatusCode]++
+		if response.StatusCode == http.StatusServiceUnavailable && response.Header.Get("Retry-After") == "" {
+			t.Error("a cancelled simulation did not say when to retry")
+		}
+		response.Body.Close()
+	}
+	if statuses[http.StatusOK] != 1 || statuses[http.StatusServiceUnavailable] != 1 {
+		t.Errorf("got statuses %v, want one 200 and one 503", statuses)
+	}
+
+	select {
+	case <-serverStopping:
+	default:
+		t.Error("event streams were not told the server is stopping")
+	}
+	// the history is closed only once the handler above has saved its run
+	records, err := runStore.List(RunFilter{})
+	if err != nil || len(records) != 1 {
+		t.Errorf("got %d runs and %v, want the cut off run saved", len(records), err)
+	}
+	err = runStore.Save(&RunRecord{CreatedAt: time.Now()}, nil)
+	if err != ErrRunStoreClosed {
+		t.Errorf("saving after shutdown got %v, want %v", err, ErrRunStoreClosed)
+	}
+}
@@ -0,0 +1,12 @@
+// reloads the phone dropdown whenever the server reports that the catalog
+// changed. The stream only exists while the server caches the catalog, and
+// EventSource gives up by itself when it is answered with a 404.
+(function () {
+    if (!window.EventSource) {
+        return;
+    }
+    const source = new EventSource("htmx/phones/events");
+    source.addEventListener("phonesChanged", function () {
+        htmx.trigger(document.body, "phonesChanged");
+    });
+})();
@@ -18,6 +18,8 @@ const fragmentShaderSource = `
 
 var positions,
     colors,
+    pinnedPayload,
+    comparison,
     currTranslation,
     currRotation,
     currScale,
@@ -31,6 +33,11 @@ main();
 
 document.getElementById("simulateBtn").onclick = simulationButtonClickHandler.bind(document);
 document.getElementById("resetSimulationBtn").onclick = resetButtonClickHandler.bind(document);
+document.getElementById("loadRunBtn").onclick = loadRunButtonClickHandler.bind(document);
+document.getElementById("pinBtn").onclick = pinButtonClickHandler.bind(document);
+document.getElementById("compareBtn").onclick = compareButtonClickHandler.bind(document);
+document.getElementById("sceneSelector").onchange = sceneSelectorChangeHandler.bind(document);
+document.getElementById("exportBtn").onclick = exportButtonClickHandler.bind(document);
 
 
 document.querySelector("canvas").onmousedown = mouseDownHandler.bind(document);
@@ -100,6 +107,33 @@ function mouseMoveHandler(evt) {
 
 function simulationButtonClickHandler() {
     document.getElementById("simulateBtn").disabled=true;
+    getSimulation(buildPayload());
+}
+
+function pinButtonClickHandler() {
+    pinnedPayload = buildPayload();
+    document.getElementById("compareBtn").disabled=false;
+}
+
+function compareButtonClickHandler() {
+    getComparison({a: pinnedPayload, b: buildPayload()});
+}
+
+function exportButtonClickHandler() {
+    downloadExport(buildPayload(), document.getElementById("exportFormat").value);
+}
+
+function sceneSelectorChangeHandler() {
+    var scene = comparison[document.getElementById("sceneSelector").value];
+    if(!scene) {
+        return;
+    }
+    positions.phone = scene.phone || [];
+    positions.paraboloid = scene.paraboloid || [];
+    positions.user = scene.user || [];
+}
+
+function buildPayload() {
     //Phone
     var phone = {
         filename: document.getElementById("phoneSelector").value,
@@ -136,14 +170,28 @@ function simulationButtonClickHandler() {
         angular: Number(document.getElementById("angularResolution").value),
     }
 
+    //Hit Map
+    var hitMap = {
+        scheme: "equalArea",
+        thetaBins: 18,
+        phiBins: 36,
+        axis: "paraboloid",
+    }
+
     var payload = {
         phone: phone,
         paraboloid: paraboloid,
         slicingPlane: slicingPlane,
         userRadius: userRadius,
         resolution: resolution,
+        hitMap: hitMap,
     }
-    getSimulation(payload);
+
+    //Baseline
+    if(document.getElementById("baselineEnabled").checked) {
+        payload.baseline = {};
+    }
+    return payload;
 }
 
 function init() {
@@ -173,6 +221,14 @@ function resetButtonClickHandler() {
     init();
 }
 
+function loadRunButtonClickHandler() {
+    var runId = document.getElementById("runSelector").value;
+    if(!runId) {
+        return;
+    }
+    getRun(runId);
+}
+
 
 //
 // start here
@@ -326,22 +382,164 @@ function drawScene(gl, programInfo, buffers, positions, color, matrix) {
     }
 }
 
+// the page is served from the base path, so the API sits next to it on the
+// same origin wherever the server is mounted
+function apiUrl(path) {
+    return new URL(`api/v1/${path}`, document.baseURI).href;
+}
+
 function getSimulation(payload) {
     opts = {
         method: "POST",
         body: JSON.stringify(payload),
     }
-    fetch(`http://localhost:8080/api/simulation`, opts).then(function(response) {
+    fetch(apiUrl("simulation"), opts).then(function(response) {
         return response.json();
     }).then(function(data) {
-        positions.phone = data.Phone || [];
-        positions.paraboloid = data.Paraboloid || [];
-        positions.user = data.User || [];
+        if(data.errors) {
+            alert(data.errors.map(e => `${e.field}: ${e.message}`).join("\n"));
+            document.getElementById("simulateBtn").disabled=false;
+            return;
+        }
+
+        positions.phone = data.phone || [];
+        positions.paraboloid = data.paraboloid || [];
+        positions.user = data.user || [];
+        showMetrics(data.metrics, data.baseline);
+        showHitMap(data.hitMap);
 
         document.getElementById("simulateBtn").disabled=false;
+        htmx.trigger(document.body, "runSaved");
     });
 }
 
+function getRun(runId) {
+    fetch(apiUrl(`runs/${runId}`)).then(function(response) {
+        return response.json();
+    }).then(function(data) {
+        positions.phone = data.output.phone || [];
+        positions.paraboloid = data.output.paraboloid || [];
+        positions.user = data.output.user || [];
+        showMetrics(data.output.metrics, data.output.baseline);
+        showHitMap(data.output.hitMap);
+    });
+}
+
+function getComparison(payload) {
+    opts = {
+        method: "POST",
+        body: JSON.stringify(payload),
+    }
+    fetch(apiUrl("compare"), opts).then(function(response) {
+        return response.json();
+    }).then(function(data) {
+        if(data.errors) {
+            alert(data.errors.map(e => `${e.field}: ${e.message}`).join("\n"));
+            return;
+        }
+
+        comparison = data;
+        document.getElementById("sceneSelector").value = "b";
+        sceneSelectorChangeHandler();
+        showMetrics(data.b.metrics, data.b.baseline);
+        showDifference(data.difference);
+    });
+}
+
+function downloadExport(payload, format) {
+    opts = {
+        method: "POST",
+        body: JSON.stringify(payload),
+    }
+    fetch(apiUrl(`export/${format}`), opts).then(function(response) {
+        if(!response.ok) {
+            return response.json().then(function(data) {
+                alert(data.errors.map(e => `${e.field}: ${e.message}`).join("\n"));
+            });
+        }
+        return response.blob().then(function(blob) {
+            var link = document.createElement("a");
+            link.href = URL.createObjectURL(blob);
+            link.download = `simulation.${format}`;
+            link.click();
+            URL.revokeObjectURL(link.href);
+        });
+    });
+}
+
+function showDifference(difference) {
+    var lines = [
+        `B vs A: total ${formatDb(difference.totalGainDb)}, on-axis ${formatDb(difference.onAxisGainDb)}`,
+        `mean bounces: ${difference.meanBouncesDifference >= 0 ? "+" : ""}${difference.meanBouncesDifference.toFixed(2)}`,
+        `angular spread: ${difference.angularSpreadDifference >= 0 ? "+" : ""}${(difference.angularSpreadDifference*180/Math.PI).toFixed(1)}°`,
+        `bounce histogram: ${difference.bounceHistogramDifference.join(" ")}`,
+    ];
+    document.getElementById("difference").textContent = lines.join("\n");
+
+    // red where B concentrates more energy than A, blue where it delivers less
+    const canvas = document.getElementById("hitMap");
+    const ctx = canvas.getContext("2d");
+    ctx.clearRect(0, 0, canvas.width, canvas.height);
+
+    var maxDifference = Math.max(...difference.densityDifference.map(Math.abs));
+    var cellWidth = canvas.width/difference.phiBins;
+    var cellHeight = canvas.height/difference.thetaBins;
+    for(var row = 0; row < difference.thetaBins; row++) {
+        for(var column = 0; column < difference.phiBins; column++) {
+            var val = maxDifference > 0 ? difference.densityDifference[row*difference.phiBins + column]/maxDifference : 0;
+            var intensity = Math.round(255*Math.abs(val));
+            ctx.fillStyle = val >= 0 ? `rgb(${intensity}, 0, 0)` : `rgb(0, 0, ${intensity})`;
+            ctx.fillRect(column*cellWidth, row*cellHeight, cellWidth, cellHeight);
+        }
+    }
+}
+
+function showHitMap(hitMap) {
+    const canvas = document.getElementById("hitMap");
+    const ctx = canvas.getContext("2d");
+    ctx.clearRect(0, 0, canvas.width, canvas.height);
+    if(!hitMap) {
+        return;
+    }
+
+    var maxDensity = Math.max(...hitMap.densities);
+    var cellWidth = canvas.width/hitMap.phiBins;
+    var cellHeight = canvas.height/hitMap.thetaBins;
+    for(var row = 0; row < hitMap.thetaBins; row++) {
+        for(var column = 0; column < hitMap.phiBins; column++) {
+            var intensity = maxDensity > 0 ? hitMap.densities[row*hitMap.phiBins + column]/maxDensity : 0;
+            ctx.fillStyle = `rgb(0, 0, ${Math.round(255*intensity)})`;
+            ctx.fillRect(column*cellWidth, row*cellHeight, cellWidth, cellHeight);
+        }
+    }
+}
+
+function formatDb(gain) {
+    return gain === null ? "n/a" : `${gain.toFixed(2)} dB`;
+}
+
+function showMetrics(metrics, baseline) {
+    if(!metrics) {
+        document.getElementById("metrics").textContent = "";
+        return;
+    }
+
+    var direction = metrics.centroidDirection.map(v => v.toFixed(3)).join(", ");
+    var lines = [
+        `rays emitted: ${metrics.raysEmitted}`,
+        `rays at user: ${metrics.raysAtUser}`,
+        `bounces: mean ${metrics.meanBounces.toFixed(2)}, max ${metrics.maxBounces}`,
+        `hit paraboloid: ${(100*metrics.paraboloidFraction).toFixed(1)}%`,
+        `re-hit phone: ${(100*metrics.phoneRehitFraction).toFixed(1)}%`,
+        `angular spread: ${(metrics.angularSpread*180/Math.PI).toFixed(1)}°`,
+        `centroid: (${direction})`,
+    ];
+    if(baseline) {
+        lines.push(`gain over bare phone: total ${formatDb(baseline.gain.totalDb)}, on-axis ${formatDb(baseline.gain.onAxisDb)}`);
+    }
+    document.getElementById("metrics").textContent = lines.join("\n");
+}
+
 function degToRad(deg) {
     return deg*Math.PI/180;
 }
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+)
+
+// rays still bouncing after this many reflections are treated as trapped
+const maxRayBounces = 1000
+
+type SimulationMetrics struct {
+	RaysEmitted        int       `json:"raysEmitted"`
+	RaysAtUser         int       `json:"raysAtUser"`
+	RaysLost           int       `json:"raysLost"`
+	MeanBounces        float64   `json:"meanBounces"`
+	MaxBounces         int       `json:"maxBounces"`
+	BounceHistogram    []int     `json:"bounceHistogram"`
+	ParaboloidFraction float64   `json:"paraboloidFraction"`
+	PhoneRehitFraction float64   `json:"phoneRehitFraction"`
+	AngularSpread      float64   `json:"angularSpread"`
+	CentroidDirection  []float64 `json:"centroidDirection"`
+}
+
+// metricsAccumulator collects per-ray counts while generateSimulation traces
+type metricsAccumulator struct {
+	raysEmitted     int
+	raysAtUser      int
+	raysLost        int
+	totalBounces    int
+	maxBounces      int
+	bounceHistogram []int
+	raysParaboloid  int
+	raysPhone       int
+}
+
+func (accumulator *metricsAccumulator) addRay(bounces int, hitParaboloid bool, hitPhone bool, atUser bool) {
+	accumulator.raysEmitted++
+	if atUser {
+		accumulator.raysAtUser++
+	} else {
+		accumulator.raysLost++
+	}
+
+	accumulator.totalBounces += bounces
+	if bounces > accumulator.maxBounces {
+		accumulator.maxBounces = bounces
+	}
+	for len(accumulator.bounceHistogram) <= bounces {
+		accumulator.bounceHistogram = append(accumulator.bounceHistogram, 0)
+	}
+	accumulator.bounceHistogram[bounces]++
+
+	if hitParaboloid {
+		accumulator.raysParaboloid++
+	}
+	if hitPhone {
+		accumulator.raysPhone++
+	}
+}
+
+// finish derives the ratios and the direction statistics of the user hits.
+// The spread is the RMS angle in radians between each hit direction, seen
+// from the origin, and the centroid direction.
+func (accumulator *metricsAccumulator) finish(userVerticies []float64) SimulationMetrics {
+	var metrics SimulationMetrics
+	metrics.RaysEmitted = accumulator.raysEmitted
+	metrics.RaysAtUser = accumulator.raysAtUser
+	metrics.RaysLost = accumulator.raysLost
+	metrics.MaxBounces = accumulator.maxBounces
+	metrics.BounceHistogram = accumulator.bounceHistogram
+	if metrics.BounceHistogram == nil {
+		metrics.BounceHistogram = []int{}
+	}
+	metrics.CentroidDirection = []float64{0, 0, 0}
+
+	if accumulator.raysEmitted > 0 {
+		metrics.MeanBounces = float64(accumulator.totalBounces) / float64(accumulator.raysEmitted)
+		metrics.ParaboloidFraction = float64(accumulator.raysParaboloid) / float64(accumulator.raysEmitted)
+		metrics.PhoneRehitFraction = float64(accumulator.raysPhone) / float64(accumulator.raysEmitted)
+	}
+
+	metrics.CentroidDirection, metrics.AngularSpread = directionStatistics(userVerticies)
+	return metrics
+}
+
+func directionStatistics(verticies []float64) ([]float64, float64) {
+	centroid := []float64{0, 0, 0}
+	for i := 0; i+2 < len(verticies); i += 3 {
+		length := math.Sqrt(verticies[i]*verticies[i] + verticies[i+1]*verticies[i+1] + verticies[i+2]*verticies[i+2])
+		if length == 0 {
+			continue
+		}
+		for j := 0; j < 3; j++ {
+			centroid[j] += verticies[i+j] / length
+		}
+	}
+
+	centroidLength := math.Sqrt(centroid[0]*centroid[0] + centroid[1]*centroid[1] + centroid[2]*centroid[2])
+	if centroidLength == 0 {
+		return centroid, 0
+	}
+	for j := 0; j < 3; j++ {
+		centroid[j] /= centroidLength
+	}
+
+	sumSquares := 0.0
+	count := 0
+	for i := 0; i+2 < len(verticies); i += 3 {
+		length := math.Sqrt(verticies[i]*verticies[i] + verticies[i+1]*verticies[i+1] + verticies[i+2]*verticies[i+2])
+		if length == 0 {
+			continue
+		}
+		cosAngle := (verticies[i]*centroid[0] + verticies[i+1]*centroid[1] + verticies[i+2]*centroid[2]) / length
+		angle := math.Acos(math.Max(-1, math.Min(1, cosAngle)))
+		sumSquares += angle * angle
+		count++
+	}
+
+	return centroid, math.Sqrt(sumSquares / float64(count))
+}
+
+// countWithinCone counts the verticies whose direction from the origin lies
+// within the cone around the unit vector axis
+func countWithinCone(verticies []float64, axis []float64, cosCone float64) int {
+	count := 0
+	for i := 0; i+2 < len(verticies); i += 3 {
+		length := math.Sqrt(verticies[i]*verticies[i] + verticies[i+1]*verticies[i+1] + verticies[i+2]*verticies[i+2])
+		if length > 0 && (axis[0]*verticies[i]+axis[1]*verticies[i+1]+axis[2]*verticies[i+2])/length >= cosCone {
+			count++
+		}
+	}
+	return count
+}
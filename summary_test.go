@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestMetricsAccumulator(t *testing.T) {
+	var accumulator metricsAccumulator
+	accumulator.addRay(0, false, false, false)
+	accumulator.addRay(2, true, false, true)
+	accumulator.addRay(3, true, true, true)
+	accumulator.addRay(2, true, false, false)
+
+	metrics := accumulator.finish([]float64{0, 0, 2, 0, 0, 5})
+	if metrics.RaysEmitted != 4 || metrics.RaysAtUser != 2 || metrics.RaysLost != 2 || metrics.MaxBounces != 3 {
+		t.Errorf("got counts %+v", metrics)
+	}
+	if !reflect.DeepEqual(metrics.BounceHistogram, []int{1, 0, 2, 1}) {
+		t.Errorf("got histogram %v, want [1 0 2 1]", metrics.BounceHistogram)
+	}
+	if metrics.MeanBounces != 1.75 || metrics.ParaboloidFraction != 0.75 || metrics.PhoneRehitFraction != 0.25 {
+		t.Errorf("got ratios %+v", metrics)
+	}
+	if !reflect.DeepEqual(metrics.CentroidDirection, []float64{0, 0, 1}) || metrics.AngularSpread != 0 {
+		t.Errorf("got direction %v with spread %g", metrics.CentroidDirection, metrics.AngularSpread)
+	}
+
+	// no rays at all still reports empty lists rather than null
+	empty := (&metricsAccumulator{}).finish(nil)
+	if empty.BounceHistogram == nil || len(empty.CentroidDirection) != 3 || empty.MeanBounces != 0 {
+		t.Errorf("got %+v for no rays", empty)
+	}
+}
+
+func TestDirectionStatistics(t *testing.T) {
+	// two hits 90° apart, the centroid lies between them at 45° to each
+	centroid, spread := directionStatistics([]float64{1, 0, 0, 0, 3, 0})
+	want := []float64{math.Sqrt(0.5), math.Sqrt(0.5), 0}
+	for i := 0; i < 3; i++ {
+		if math.Abs(centroid[i]-want[i]) > 1e-12 {
+			t.Fatalf("got centroid %v, want %v", centroid, want)
+		}
+	}
+	if math.Abs(spread-math.Pi/4) > 1e-12 {
+		t.Errorf("got spread %g, want %g", spread, math.Pi/4)
+	}
+}
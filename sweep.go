@@ -0,0 +1,353 @@
+package main
+
+import (
+	"amphora/pkg/units"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var maxSweepPoints = 1000
+
+// maxSweepRays bounds the rays of all points together, as maxSimulationRays
+// only bounds each one
+var maxSweepRays = 50_000_000
+
+// simulationParameter sets one numeric field of a SimulationInput. units is
+// optional and, when given, replaces the unit recorded for that field.
+type simulationParameter func(simulationInput *SimulationInput, val float64, units string)
+
+// simulationParameters lists every numeric input that can be swept or
+// optimized, keyed by its JSON path
+var simulationParameters = map[string]simulationParameter{
+	"phone.angle": func(simulationInput *SimulationInput, val float64, units string) {
+		simulationInput.Phone.Angle = val
+		if units != "" {
+			simulationInput.Phone.AngleUnits = units
+		}
+	},
+	"paraboloid.x": func(simulationInput *SimulationInput, val float64, units string) {
+		simulationInput.Paraboloid.X = val
+	},
+	"paraboloid.y": func(simulationInput *SimulationInput, val float64, units string) {
+		simulationInput.Paraboloid.Y = val
+	},
+	"paraboloid.z": func(simulationInput *SimulationInput, val float64, units string) {
+		simulationInput.Paraboloid.Z = val
+	},
+	"paraboloid.angle": func(simulationInput *SimulationInput, val float64, units string) {
+		simulationInput.Paraboloid.Angle = val
+		if units != "" {
+			simulationInput.Paraboloid.AngleUnits = units
+		}
+	},
+	"slicingPlane.height": func(simulationInput *SimulationInput, val float64, units string) {
+		simulationInput.SlicingPlane.Height = val
+		if units != "" {
+			simulationInput.SlicingPlane.HeightUnits = units
+		}
+	},
+	"slicingPlane.angle": func(simulationInput *SimulationInput, val float64, units string) {
+		simulationInput.SlicingPlane.Angle = val
+		if units != "" {
+			simulationInput.SlicingPlane.AngleUnits = units
+		}
+	},
+	"userRadius.radius": func(simulationInput *SimulationInput, val float64, units string) {
+		simulationInput.UserRadius.Radius = val
+		if units != "" {
+			simulationInput.UserRadius.RadiusUnits = units
+		}
+	},
+	"resolution.linear": func(simulationInput *SimulationInput, val float64, units string) {
+		simulationInput.Resolution.Linear = val
+		if units != "" {
+			simulationInput.Resolution.LinearUnits = units
+		}
+	},
+	"resolution.angular": func(simulationInput *SimulationInput, val float64, units string) {
+		simulationInput.Resolution.Angular = val
+		if units != "" {
+			simulationInput.Resolution.AngularUnits = units
+		}
+	},
+}
+
+// simulationParameterKinds gives the kind of unit each parameter is measured
+// in. The paraboloid coefficients are plain numbers and take no units.
+var simulationParameterKinds = map[string]units.Kind{
+	"phone.angle":         units.KindAngle,
+	"paraboloid.angle":    units.KindAngle,
+	"slicingPlane.height": units.KindLength,
+	"slicingPlane.angle":  units.KindAngle,
+	"userRadius.radius":   units.KindLength,
+	"resolution.linear":   units.KindLength,
+	"resolution.angular":  units.KindAngle,
+}
+
+// validateParameterUnits checks units given for a swept or optimized
+// parameter, which must be of its kind and only given when it has one
+func validateParameterUnits(validationError *ValidationError, field string, path string, unit string) {
+	if unit == "" {
+		return
+	}
+	kind, ok := simulationParameterKinds[path]
+	if !ok {
+		validationError.Add(field, "parameter %q takes no units", path)
+		return
+	}
+	validateUnit(validationError, field, unit, kind)
+}
+
+func simulationParameterNames() []string {
+	names := make([]string, 0, len(simulationParameters))
+	for name := range simulationParameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type SweepRange struct {
+	Path   string    `json:"path"`
+	Start  float64   `json:"start,omitempty"`
+	Stop   float64   `json:"stop,omitempty"`
+	Step   float64   `json:"step,omitempty"`
+	Values []float64 `json:"values,omitempty"`
+	Units  string    `json:"units,omitempty"`
+}
+
+type SweepInput struct {
+	Base   SimulationInput `json:"base"`
+	Ranges []SweepRange    `json:"ranges"`
+}
+
+type SweepPoint struct {
+	Values     map[string]float64 `json:"values"`
+	Cached     bool               `json:"cached"`
+	DurationMs float64            `json:"durationMs"`
+	Summary    RunSummary         `json:"summary"`
+}
+
+type SweepOutput struct {
+	Ranges []SweepRange `json:"ranges"`
+	Points []SweepPoint `json:"points"`
+}
+
+// values expands the range into the list of values it covers, inclusive of
+// stop when it falls on a step
+func (sweepRange *SweepRange) values() []float64 {
+	if len(sweepRange.Values) > 0 {
+		return sweepRange.Values
+	}
+
+	count := int(math.Floor((sweepRange.Stop-sweepRange.Start)/sweepRange.Step+1e-9)) + 1
+	values := make([]float64, 0, count)
+	for i := 0; i < count; i++ {
+		values = append(values, sweepRange.Start+float64(i)*sweepRange.Step)
+	}
+	return values
+}
+
+func validateSweepRanges(ranges []SweepRange) *ValidationError {
+	validationError := &ValidationError{Errors: []FieldError{}}
+
+	if len(ranges) == 0 {
+		validationError.Add("ranges", "at least one range is required")
+		return validationError
+	}
+
+	seen := map[string]bool{}
+	pointCount := 1.0
+	for i := 0; i < len(ranges); i++ {
+		field := fmt.Sprintf("ranges[%d]", i)
+
+		_, ok := simulationParameters[ranges[i].Path]
+		if !ok {
+			validationError.Add(field+".path", "unknown parameter %q, expected one of %s", ranges[i].Path, strings.Join(simulationParameterNames(), ", "))
+			continue
+		}
+		if seen[ranges[i].Path] {
+			validationError.Add(field+".path", "parameter %q is swept more than once", ranges[i].Path)
+			continue
+		}
+		seen[ranges[i].Path] = true
+		validateParameterUnits(validationError, field+".units", ranges[i].Path, ranges[i].Units)
+
+		if len(ranges[i].Values) > 0 {
+			pointCount *= float64(len(ranges[i].Values))
+			continue
+		}
+
+		if !validatePositive(validationError, field+".step", ranges[i].Step) {
+			continue
+		}
+		if ranges[i].Stop < ranges[i].Start {
+			validationError.Add(field+".stop", "must not be less than start")
+			continue
+		}
+		pointCount *= math.Floor((ranges[i].Stop-ranges[i].Start)/ranges[i].Step+1e-9) + 1
+	}
+
+	if validationError.Empty() && pointCount > float64(maxSweepPoints) {
+		validationError.Add("ranges", "sweep covers %.0f points, the limit is %d", pointCount, maxSweepPoints)
+	}
+	return validationError
+}
+
+// sweepGrid returns the cartesian product of all ranges as one input per point
+func sweepGrid(base *SimulationInput, ranges []SweepRange) ([]SimulationInput, []map[string]float64) {
+	inputs := []SimulationInput{*base}
+	values := []map[string]float64{{}}
+
+	for i := 0; i < len(ranges); i++ {
+		setter := simulationParameters[ranges[i].Path]
+		rangeValues := ranges[i].values()
+
+		nextInputs := make([]SimulationInput, 0, len(inputs)*len(rangeValues))
+		nextValues := make([]map[string]float64, 0, len(inputs)*len(rangeValues))
+		for j := 0; j < len(inputs); j++ {
+			for k := 0; k < len(rangeValues); k++ {
+				input := inputs[j]
+				setter(&input, rangeValues[k], ranges[i].Units)
+				nextInputs = append(nextInputs, input)
+
+				pointValues := make(map[string]float64, len(values[j])+1)
+				for path, val := range values[j] {
+					pointValues[path] = val
+				}
+				pointValues[ranges[i].Path] = rangeValues[k]
+				nextValues = append(nextValues, pointValues)
+			}
+		}
+		inputs = nextInputs
+		values = nextValues
+	}
+
+	return inputs, values
+}
+
+// runSimulations evaluates every input on a pool of GOMAXPROCS workers and
+// returns the points in input order. It stops handing out points once one
+// fails or ctx is done, such as when the client goes away.
+func runSimulations(ctx context.Context, phoneConfig *PhoneConfig, inputs []SimulationInput) ([]SweepPoint, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	points := make([]SweepPoint, len(inputs))
+	var firstErr error
+	var errOnce sync.Once
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				startTime := time.Now()
+				output, cached, err := runWorldSimulation(ctx, phoneConfig, &inputs[i])
+				if err != nil {
+					errOnce.Do(func() { firstErr = err })
+					cancel()
+					continue
+				}
+
+				points[i].Cached = cached
+				points[i].DurationMs = float64(time.Since(startTime).Microseconds()) / 1000
+				points[i].Summary = summarizeOutput(output)
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < len(inputs); i++ {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return points, nil
+}
+
+func HandleApiSweep(c *gin.Context) {
+	var sweepInput SweepInput
+	err := c.ShouldBindJSON(&sweepInput)
+	if err != nil {
+		validationError := &ValidationError{}
+		validationError.Add("body", "malformed request body: %v", err)
+		abortWithValidationError(c, validationError)
+		return
+	}
+
+	sweepOutput, validationError, err := executeSweep(c.Request.Context(), &sweepInput)
+	if validationError != nil {
+		abortWithValidationError(c, validationError)
+		return
+	}
+	if err != nil {
+		abortWithSimulationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, sweepOutput)
+}
+
+// executeSweep validates every point of the sweep before running any of them.
+// A non-nil ValidationError means nothing was simulated.
+func executeSweep(ctx context.Context, sweepInput *SweepInput) (*SweepOutput, *ValidationError, error) {
+	validationError := validateSweepRanges(sweepInput.Ranges)
+	if !validationError.Empty() {
+		return nil, validationError, nil
+	}
+
+	phoneConfig := lookupPhoneConfig(sweepInput.Base.Phone.Filename)
+
+	inputs, values := sweepGrid(&sweepInput.Base, sweepInput.Ranges)
+	for i := 0; i < len(inputs); i++ {
+		pointError := validateSimulationInput(&inputs[i], phoneConfig)
+		for j := 0; j < len(pointError.Errors); j++ {
+			validationError.Add(fmt.Sprintf("points[%d].%s", i, pointError.Errors[j].Field), "%s", pointError.Errors[j].Message)
+		}
+	}
+	if !validationError.Empty() {
+		return nil, validationError, nil
+	}
+
+	rayCount := 0.0
+	for i := 0; i < len(inputs); i++ {
+		rayCount += estimateRayCount(phoneConfig, normalizedInput(&inputs[i]))
+	}
+	if rayCount > float64(maxSweepRays) {
+		validationError.Add("ranges", "sweep would trace %.0f rays, the limit is %d", rayCount, maxSweepRays)
+		return nil, validationError, nil
+	}
+
+	points, err := runSimulations(ctx, phoneConfig, inputs)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := 0; i < len(points); i++ {
+		points[i].Values = values[i]
+	}
+
+	return &SweepOutput{Ranges: sweepInput.Ranges, Points: points}, nil, nil
+}
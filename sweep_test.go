@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSweepRangeValues(t *testing.T) {
+	tests := []struct {
+		name       string
+		sweepRange SweepRange
+		want       []float64
+	}{
+		{name: "explicit values", sweepRange: SweepRange{Values: []float64{3, 1, 2}, Start: 0, Stop: 10, Step: 1}, want: []float64{3, 1, 2}},
+		{name: "stop on a step", sweepRange: SweepRange{Start: 0, Stop: 1, Step: 0.25}, want: []float64{0, 0.25, 0.5, 0.75, 1}},
+		{name: "stop between steps", sweepRange: SweepRange{Start: 10, Stop: 25, Step: 10}, want: []float64{10, 20}},
+		{name: "rounding error in the step", sweepRange: SweepRange{Start: 0, Stop: 0.3, Step: 0.1}, want: []float64{0, 0.1, 0.2, 0.3}},
+		{name: "single point", sweepRange: SweepRange{Start: 5, Stop: 5, Step: 1}, want: []float64{5}},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			values := test.sweepRange.values()
+			if len(values) != len(test.want) {
+				t.Fatalf("got %v, want %v", values, test.want)
+			}
+			for j := 0; j < len(values); j++ {
+				if math.Abs(values[j]-test.want[j]) > 1e-9 {
+					t.Errorf("got %v, want %v", values, test.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSweepRanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranges []SweepRange
+		// fields expected to be reported, in order
+		fields []string
+	}{
+		{name: "valid", ranges: []SweepRange{{Path: "phone.angle", Start: 0, Stop: 10, Step: 5}, {Path: "paraboloid.z", Values: []float64{1, 2}}}},
+		{name: "no ranges", fields: []string{"ranges"}},
+		{name: "unknown path", ranges: []SweepRange{{Path: "phone.colour", Values: []float64{1}}}, fields: []string{"ranges[0].path"}},
+		{name: "duplicate path", ranges: []SweepRange{{Path: "phone.angle", Values: []float64{1}}, {Path: "phone.angle", Values: []float64{2}}}, fields: []string{"ranges[1].path"}},
+		{name: "zero step", ranges: []SweepRange{{Path: "phone.angle", Start: 0, Stop: 10}}, fields: []string{"ranges[0].step"}},
+		{name: "stop before start", ranges: []SweepRange{{Path: "phone.angle", Start: 10, Stop: 0, Step: 1}}, fields: []string{"ranges[0].stop"}},
+		{name: "too many points", ranges: []SweepRange{{Path: "phone.angle", Start: 0, Stop: 99, Step: 1}, {Path: "paraboloid.angle", Start: 0, Stop: 99, Step: 1}}, fields: []string{"ranges"}},
+		{name: "units", ranges: []SweepRange{{Path: "phone.angle", Values: []float64{1}, Units: "rad"}, {Path: "slicingPlane.height", Values: []float64{1}, Units: "in"}}},
+		{name: "length units on an angle", ranges: []SweepRange{{Path: "phone.angle", Values: []float64{1}, Units: "cm"}}, fields: []string{"ranges[0].units"}},
+		{name: "units on a coefficient", ranges: []SweepRange{{Path: "paraboloid.x", Values: []float64{1}, Units: "mm"}}, fields: []string{"ranges[0].units"}},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			validationError := validateSweepRanges(test.ranges)
+			fields := []string{}
+			for j := 0; j < len(validationError.Errors); j++ {
+				fields = append(fields, validationError.Errors[j].Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(test.fields, ",") {
+				t.Errorf("got errors %+v, want them on %v", validationError.Errors, test.fields)
+			}
+		})
+	}
+}
+
+func TestSweepGrid(t *testing.T) {
+	base := testSimulationInput()
+	ranges := []SweepRange{
+		{Path: "phone.angle", Values: []float64{1, 2}, Units: "rad"},
+		{Path: "slicingPlane.height", Values: []float64{10, 20, 30}},
+	}
+	inputs, values := sweepGrid(&base, ranges)
+	if len(inputs) != 6 || len(values) != 6 {
+		t.Fatalf("got %d inputs and %d value sets, want 6", len(inputs), len(values))
+	}
+
+	// the last range varies fastest
+	for i := 0; i < len(inputs); i++ {
+		wantAngle := ranges[0].Values[i/3]
+		wantHeight := ranges[1].Values[i%3]
+		if inputs[i].Phone.Angle != wantAngle || inputs[i].Phone.AngleUnits != "rad" || inputs[i].SlicingPlane.Height != wantHeight || inputs[i].SlicingPlane.HeightUnits != "cm" {
+			t.Errorf("point %d: got phone %+v and slicing plane %+v", i, inputs[i].Phone, inputs[i].SlicingPlane)
+		}
+		if len(values[i]) != 2 || values[i]["phone.angle"] != wantAngle || values[i]["slicingPlane.height"] != wantHeight {
+			t.Errorf("point %d: got values %v", i, values[i])
+		}
+	}
+	if base.Phone.Angle != 5 || base.SlicingPlane.Height != 15 {
+		t.Error("sweepGrid changed the base input")
+	}
+}
+
+func TestRunSimulations(t *testing.T) {
+	var err error
+	simulationCache, err = NewSimulationCache(10, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { simulationCache = nil }()
+
+	base := testSimulationInput()
+	base.Resolution = ResolutionInput{Linear: 2, Angular: 0.5}
+	inputs, _ := sweepGrid(&base, []SweepRange{{Path: "phone.angle", Values: []float64{0, 5, 10, 5}}})
+
+	points, err := runSimulations(context.Background(), testPhoneConfig(), inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != len(inputs) {
+		t.Fatalf("got %d points, want %d", len(points), len(inputs))
+	}
+	for i := 0; i < len(points); i++ {
+		output, _, err := runSimulation(testPhoneConfig(), &inputs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(points[i].Summary, summarizeOutput(output)) {
+			t.Errorf("point %d: got summary %+v, want %+v", i, points[i].Summary, summarizeOutput(output))
+		}
+	}
+}
+
+func TestRunSimulationsCancelled(t *testing.T) {
+	var err error
+	simulationCache, err = NewSimulationCache(10, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { simulationCache = nil }()
+
+	base := testSimulationInput()
+	base.Resolution = ResolutionInput{Linear: 2, Angular: 0.5}
+	inputs, _ := sweepGrid(&base, []SweepRange{{Path: "phone.angle", Values: []float64{0, 5, 10}}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	points, err := runSimulations(ctx, testPhoneConfig(), inputs)
+	if err != context.Canceled || points != nil {
+		t.Errorf("got %d points and %v, want %v", len(points), err, context.Canceled)
+	}
+
+	// a failing point stops the sweep with its error
+	resetShutdown(t)
+	close(simulationsCancelled)
+	_, err = runSimulations(context.Background(), testPhoneConfig(), inputs)
+	if err != errSimulationCancelled {
+		t.Errorf("got %v, want %v", err, errSimulationCancelled)
+	}
+}
+
+func TestGenerateSimulationContextDone(t *testing.T) {
+	simulationInput := testSimulationInput()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// a point that is already running stops at the next row of the speaker grid
+	_, err := generateSimulation(testPhoneConfig(), normalizedInput(&simulationInput), simulationOptions{withParaboloid: true, ctx: ctx})
+	if err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestExecuteSweepRayLimit(t *testing.T) {
+	phoneStore = NewMemoryPhoneStore(map[string]PhoneConfig{"iPhone5": *testPhoneConfig()})
+	defer func() { phoneStore = nil }()
+	defer func(limit int) { maxSweepRays = limit }(maxSweepRays)
+
+	sweepInput := SweepInput{Base: testSimulationInput(), Ranges: []SweepRange{{Path: "phone.angle", Values: []float64{0, 5, 10}}}}
+	sweepInput.Base.Resolution = ResolutionInput{Linear: 2, Angular: 0.5}
+	pointRays := estimateRayCount(testPhoneConfig(), normalizedInput(&sweepInput.Base))
+	maxSweepRays = int(3*pointRays) - 1
+
+	_, validationError, err := executeSweep(context.Background(), &sweepInput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validationError == nil || len(validationError.Errors) != 1 || validationError.Errors[0].Field != "ranges" || !strings.Contains(validationError.Errors[0].Message, "rays") {
+		t.Errorf("got %+v, want the ray limit on ranges", validationError)
+	}
+}
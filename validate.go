@@ -0,0 +1,223 @@
+package main
+
+import (
+	"amphora/pkg/units"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// upper bound on the rays a single simulation may trace, roughly 30s of work
+var maxSimulationRays = 5_000_000
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (validationError *ValidationError) Error() string {
+	messages := make([]string, 0, len(validationError.Errors))
+	for i := 0; i < len(validationError.Errors); i++ {
+		messages = append(messages, validationError.Errors[i].Field+": "+validationError.Errors[i].Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (validationError *ValidationError) Add(field string, format string, args ...any) {
+	validationError.Errors = append(validationError.Errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (validationError *ValidationError) Empty() bool {
+	return len(validationError.Errors) == 0
+}
+
+func abortWithValidationError(c *gin.Context, validationError *ValidationError) {
+	c.AbortWithStatusJSON(http.StatusBadRequest, validationError)
+}
+
+// isOneOf reports whether val is among the allowed names, such as frames,
+// hit map schemes or parameter paths
+func isOneOf(val string, allowed []string) bool {
+	for i := 0; i < len(allowed); i++ {
+		if allowed[i] == val {
+			return true
+		}
+	}
+	return false
+}
+
+func validateUnit(validationError *ValidationError, field string, unit string, kind units.Kind) bool {
+	err := units.Check(unit, kind)
+	if err != nil {
+		validationError.Add(field, "%v", err)
+		return false
+	}
+	return true
+}
+
+func validatePositive(validationError *ValidationError, field string, val float64) bool {
+	if !(val > 0) || math.IsInf(val, 0) {
+		validationError.Add(field, "must be a positive number, got %g", val)
+		return false
+	}
+	return true
+}
+
+func validateNonNegative(validationError *ValidationError, field string, val float64) bool {
+	if !(val >= 0) || math.IsInf(val, 0) {
+		validationError.Add(field, "must be zero or a positive number, got %g", val)
+		return false
+	}
+	return true
+}
+
+// estimateRayCount mirrors the emission loops in generateSimulation
+func estimateRayCount(phoneConfig *PhoneConfig, normalized NormalizedSimulationInput) float64 {
+	spanAzimuthal := emissionSpanAzimuthal.Radians()
+	spanPolar := emissionSpanPolar.Radians()
+	linearResolution := normalized.LinearResolution.Millimetres()
+	angularResolution := normalized.AngularResolution.Radians()
+
+	countWidth := math.Floor(phoneConfig.Speaker.Width/linearResolution) + 1
+	countHeight := math.Floor(phoneConfig.Speaker.Height/linearResolution) + 1
+	countAzimuthal := math.Floor(spanAzimuthal/angularResolution) + 1
+	countPolar := math.Floor(spanPolar/angularResolution) + 1
+
+	// the zero azimuth only emits a single ray
+	return countWidth * countHeight * (1 + (countAzimuthal-1)*countPolar)
+}
+
+// lookupPhoneConfig loads the phone referenced by an input, returning nil when
+// there is none so that validation can report it alongside other problems
+func lookupPhoneConfig(filename string) *PhoneConfig {
+	if filename == "" {
+		return nil
+	}
+
+	phoneConfig, err := phoneStore.Get(phoneIdFromFilename(filename))
+	if err != nil {
+		return nil
+	}
+	return phoneConfig
+}
+
+// describePhoneLookupError explains why lookupPhoneConfig found no phone
+func describePhoneLookupError(filename string) string {
+	_, err := phoneStore.Get(phoneIdFromFilename(filename))
+	var definitionErrors PhoneDefinitionErrors
+	if errors.As(err, &definitionErrors) {
+		return fmt.Sprintf("invalid phone definition: %v", err)
+	}
+	return fmt.Sprintf("unknown phone %q", filename)
+}
+
+// prepareSimulation loads the phone for simulationInput and validates it
+func prepareSimulation(simulationInput *SimulationInput) (*PhoneConfig, *ValidationError) {
+	phoneConfig := lookupPhoneConfig(simulationInput.Phone.Filename)
+
+	validationError := validateSimulationInput(simulationInput, phoneConfig)
+	if !validationError.Empty() {
+		return nil, validationError
+	}
+	return phoneConfig, nil
+}
+
+// validateSimulationInput checks every field of simulationInput and returns
+// all problems at once. phoneConfig may be nil when the phone could not be
+// loaded, in which case checks that depend on the phone are skipped.
+func validateSimulationInput(simulationInput *SimulationInput, phoneConfig *PhoneConfig) *ValidationError {
+	validationError := &ValidationError{Errors: []FieldError{}}
+
+	if simulationInput.Phone.Filename == "" {
+		validationError.Add("phone.filename", "is required")
+	} else if phoneConfig == nil {
+		validationError.Add("phone.filename", "%s", describePhoneLookupError(simulationInput.Phone.Filename))
+	}
+
+	normalized, unitError := normalizeSimulationInput(simulationInput)
+	validationError.Errors = append(validationError.Errors, unitError.Errors...)
+	unitOk := func(field string) bool {
+		for i := 0; i < len(unitError.Errors); i++ {
+			if unitError.Errors[i].Field == field {
+				return false
+			}
+		}
+		return true
+	}
+	phoneAngleOk := unitOk("phone.angleUnits")
+	paraboloidAngleOk := unitOk("paraboloid.angleUnits")
+	slicingPlaneHeightOk := unitOk("slicingPlane.heightUnits")
+	slicingPlaneAngleOk := unitOk("slicingPlane.angleUnits")
+	userRadiusOk := unitOk("userRadius.radiusUnits")
+
+	// the paraboloid coefficients appear as divisors in the phone placement
+	validatePositive(validationError, "paraboloid.x", simulationInput.Paraboloid.X)
+	validatePositive(validationError, "paraboloid.y", simulationInput.Paraboloid.Y)
+	validatePositive(validationError, "paraboloid.z", simulationInput.Paraboloid.Z)
+
+	linearOk := unitOk("resolution.linearUnits") && validatePositive(validationError, "resolution.linear", simulationInput.Resolution.Linear)
+	angularOk := unitOk("resolution.angularUnits") && validatePositive(validationError, "resolution.angular", simulationInput.Resolution.Angular)
+
+	phoneAngle := normalized.PhoneAngle.Radians()
+	paraboloidAngle := normalized.ParaboloidAngle.Radians()
+	slicingPlaneAngle := normalized.SlicingPlaneAngle.Radians()
+
+	if phoneAngleOk && (phoneAngle < 0 || phoneAngle >= math.Pi/2) {
+		validationError.Add("phone.angle", "must be at least 0° and less than 90°")
+	}
+
+	if paraboloidAngleOk && (paraboloidAngle <= 0 || paraboloidAngle >= math.Pi) {
+		validationError.Add("paraboloid.angle", "must be between 0° and 180° exclusive")
+	}
+
+	if phoneAngleOk && paraboloidAngleOk && math.Abs(math.Sin(paraboloidAngle+phoneAngle)) < 1e-6 {
+		validationError.Add("paraboloid.angle", "phone and paraboloid angles must not sum to a multiple of 180°")
+	}
+
+	if slicingPlaneHeightOk {
+		validatePositive(validationError, "slicingPlane.height", simulationInput.SlicingPlane.Height)
+	}
+
+	if slicingPlaneAngleOk && (slicingPlaneAngle <= -math.Pi/2 || slicingPlaneAngle >= math.Pi/2) {
+		validationError.Add("slicingPlane.angle", "must be between -90° and 90° exclusive")
+	}
+
+	if userRadiusOk && validatePositive(validationError, "userRadius.radius", simulationInput.UserRadius.Radius) {
+		if slicingPlaneHeightOk && normalized.UserRadius <= normalized.SlicingPlaneHeight {
+			validationError.Add("userRadius.radius", "listener sphere must lie outside the slicing plane height")
+		}
+	}
+
+	if angularOk && normalized.AngularResolution > 2*math.Pi {
+		validationError.Add("resolution.angular", "must not exceed 2π rad")
+	}
+
+	if simulationInput.HitMap != nil {
+		validateHitMapInput(validationError, simulationInput.HitMap)
+	}
+
+	if simulationInput.Baseline != nil {
+		validateBaselineInput(validationError, simulationInput.Baseline)
+	}
+
+	if simulationInput.Output != nil {
+		validateOutputInput(validationError, simulationInput.Output)
+	}
+
+	if phoneConfig != nil && linearOk && angularOk {
+		rayCount := estimateRayCount(phoneConfig, normalized)
+		if rayCount > float64(maxSimulationRays) {
+			validationError.Add("resolution", "would trace %.0f rays, the limit is %d", rayCount, maxSimulationRays)
+		}
+	}
+
+	return validationError
+}
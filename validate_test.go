@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestValidateSimulationInput(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(simulationInput *SimulationInput)
+		// fields expected to be reported, in order
+		fields []string
+	}{
+		{name: "valid", change: func(*SimulationInput) {}},
+		{name: "missing phone", fields: []string{"phone.filename"}, change: func(simulationInput *SimulationInput) {
+			simulationInput.Phone.Filename = ""
+		}},
+		{name: "unknown units", fields: []string{"phone.angleUnits", "userRadius.radiusUnits"}, change: func(simulationInput *SimulationInput) {
+			simulationInput.Phone.AngleUnits = "grad"
+			simulationInput.UserRadius.RadiusUnits = "deg"
+		}},
+		{name: "zero coefficient", fields: []string{"paraboloid.y"}, change: func(simulationInput *SimulationInput) {
+			simulationInput.Paraboloid.Y = 0
+		}},
+		{name: "phone angle out of range", fields: []string{"phone.angle"}, change: func(simulationInput *SimulationInput) {
+			simulationInput.Phone.Angle = 90
+		}},
+		{name: "angles summing to 180°", fields: []string{"paraboloid.angle"}, change: func(simulationInput *SimulationInput) {
+			simulationInput.Phone.Angle = 60
+			simulationInput.Paraboloid.Angle = 120
+		}},
+		{name: "listener inside the slicing plane", fields: []string{"userRadius.radius"}, change: func(simulationInput *SimulationInput) {
+			simulationInput.UserRadius.Radius = 10
+			simulationInput.UserRadius.RadiusUnits = "cm"
+		}},
+		{name: "too many rays", fields: []string{"resolution"}, change: func(simulationInput *SimulationInput) {
+			simulationInput.Resolution.Linear = 0.001
+		}},
+		{name: "every problem at once", fields: []string{"slicingPlane.heightUnits", "resolution.linear", "resolution.angular"}, change: func(simulationInput *SimulationInput) {
+			simulationInput.SlicingPlane.HeightUnits = ""
+			simulationInput.Resolution.Linear = -1
+			simulationInput.Resolution.Angular = 0
+		}},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			simulationInput := testSimulationInput()
+			test.change(&simulationInput)
+			phoneConfig := testPhoneConfig()
+			if simulationInput.Phone.Filename == "" {
+				phoneConfig = nil
+			}
+			validationError := validateSimulationInput(&simulationInput, phoneConfig)
+			fields := []string{}
+			for j := 0; j < len(validationError.Errors); j++ {
+				fields = append(fields, validationError.Errors[j].Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(test.fields, ",") {
+				t.Errorf("got errors %+v, want them on %v", validationError.Errors, test.fields)
+			}
+		})
+	}
+}
+
+func TestAbortWithValidationError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+
+	validationError := &ValidationError{}
+	validationError.Add("paraboloid.x", "must be a positive number, got %g", -1.0)
+	abortWithValidationError(c, validationError)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+	var body struct {
+		Errors []FieldError `json:"errors"`
+	}
+	err := json.Unmarshal(recorder.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Errors) != 1 || body.Errors[0].Field != "paraboloid.x" || body.Errors[0].Message != "must be a positive number, got -1" {
+		t.Errorf("got body %s", recorder.Body.String())
+	}
+}